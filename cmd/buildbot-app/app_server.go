@@ -1,20 +1,28 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
 	"strconv"
+	"sync"
+	"time"
 
 	ghinstallation "github.com/bradleyfalzon/ghinstallation/v2"
 	"github.com/cbrgm/githubevents/githubevents"
 	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
 )
 
 // AppServer stores all objects needed to talk to github, handle HTTP requests
 // and talk to buildbot or receive
+//
+// NOTE: The running builds, timeouts, rate limits and check runs of pull
+// requests that the AppServer keeps track of only live in memory and are lost
+// when the app is (re)started. The caches are filled again on demand.
 type AppServer struct {
 	// This is where we
 	GithubEventHandler *githubevents.EventHandler
@@ -28,6 +36,36 @@ type AppServer struct {
 	buildbotMaster      string
 	buildbotTryUser     string
 	buildbotTryPassword string
+
+	// The Buildbot web interface and REST API is reachable under this URL.
+	buildbotWWWURL      string
+	buildbotWWWUser     string
+	buildbotWWWPassword string
+
+	// Keeps track of running Buildbot builds per check run
+	builds *buildTracker
+
+	// Timers for check runs whose builds have a timeout
+	timeouts *buildTimeouts
+
+	// Serializes updates of build log comments
+	buildLogCommentMu sync.Mutex
+
+	// Path to a JSON file with the builder inventory (see
+	// command.InventoryFromFile)
+	builderInventoryFilePath string
+
+	// Configurations of the repositories per installation
+	repoConfigs *repoConfigCache
+
+	// Permissions that the installations granted the app
+	permissions *installationPermissionsCache
+
+	// Token buckets and worker time per commenter and repository
+	limiter *rateLimiter
+
+	// Check runs of pull requests whose builds may still be running
+	prBuilds *pullRequestBuilds
 }
 
 // NewAppServer returns a new app server
@@ -47,6 +85,18 @@ func NewAppServer() (*AppServer, error) {
 		buildbotMaster:      os.Getenv("BUILDBOT_MASTER"),
 		buildbotTryUser:     os.Getenv("BUILDBOT_TRY_USER"),
 		buildbotTryPassword: os.Getenv("BUILDBOT_TRY_PASSWORD"),
+
+		buildbotWWWURL:      os.Getenv("BUILDBOT_WWW_URL"),
+		buildbotWWWUser:     os.Getenv("BUILDBOT_WWW_USER"),
+		buildbotWWWPassword: os.Getenv("BUILDBOT_WWW_PASSWORD"),
+		builds:              newBuildTracker(),
+		timeouts:            newBuildTimeouts(),
+
+		builderInventoryFilePath: os.Getenv("BUILDBOT_BUILDER_INVENTORY_FILE"),
+		repoConfigs:              newRepoConfigCache(repoConfigCacheTTL),
+		permissions:              newInstallationPermissionsCache(installationPermissionsCacheTTL),
+		limiter:                  newRateLimiter(),
+		prBuilds:                 newPullRequestBuilds(),
 	}, nil
 }
 
@@ -66,6 +116,37 @@ func (srv *AppServer) NewGithubClient(appInstallationID int64) (*github.Client,
 	return github.NewClient(&http.Client{Transport: transport}), nil
 }
 
+// GetInstallationPermissions returns the permissions of the access token of
+// the given installation, which are the permissions that the installation
+// granted the app. Permissions are cached per installation for a few minutes,
+// because every call has to get a new installation token.
+func (srv *AppServer) GetInstallationPermissions(appInstallationID int64) (*github.InstallationPermissions, error) {
+	if permissions, ok := srv.permissions.Get(appInstallationID); ok {
+		return permissions, nil
+	}
+	transport, err := ghinstallation.NewKeyFromFile(
+		http.DefaultTransport,
+		srv.appID,
+		appInstallationID,
+		srv.privateKeyFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new key from file: %w", err)
+	}
+	if _, err := transport.Token(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to get installation token: %w", err)
+	}
+	permissions, err := transport.Permissions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get permissions of installation token: %w", err)
+	}
+	srv.permissions.Put(appInstallationID, &permissions)
+	return &permissions, nil
+}
+
+// delegationBuilderName is the builder that "buildbot try" runs. It triggers
+// the builds of the command's builders (see infra/bb-master/cfg/master.cfg).
+const delegationBuilderName = "delegationBuilder"
+
 // RunTryBot runs the "buildbot try" command against the configure buildbot
 // master with a try-bot username and password. The command output is written to
 // the logs.
@@ -81,7 +162,7 @@ func (srv *AppServer) RunTryBot(responsibleGithubLogin string, githubRepoOwner s
 	args := append([]string{
 		"try",
 		fmt.Sprintf("--master=%s", srv.buildbotMaster),
-		fmt.Sprintf("--builder=%s", "delegationBuilder"),
+		fmt.Sprintf("--builder=%s", delegationBuilderName),
 		fmt.Sprintf("--username=%s", srv.buildbotTryUser),
 		fmt.Sprintf("--passwd=%s", srv.buildbotTryPassword),
 		fmt.Sprintf("--diff=%s", dummyDiffFile.Name()),
@@ -98,6 +179,97 @@ func (srv *AppServer) RunTryBot(responsibleGithubLogin string, githubRepoOwner s
 	return string(output), err
 }
 
+// GetBuilderInventory reads the builder inventory from the file given in the
+// BUILDBOT_BUILDER_INVENTORY_FILE environment variable. The file is read on
+// every call so that it can be changed without restarting the app. When no
+// file is configured, an empty inventory is returned.
+func (srv *AppServer) GetBuilderInventory() (command.Inventory, error) {
+	if srv.builderInventoryFilePath == "" {
+		return command.Inventory{}, nil
+	}
+	return command.InventoryFromFile(srv.builderInventoryFilePath)
+}
+
+// GetRepoConfig returns the configuration of the given repository from
+// RepoConfigPath on its default branch. Configurations are cached per
+// installation for a few minutes, so changes take a moment to be picked up.
+func (srv *AppServer) GetRepoConfig(appInstallationID int64, repoOwner string, repoName string) (*RepoConfig, error) {
+	if config, ok := srv.repoConfigs.Get(appInstallationID, repoOwner, repoName); ok {
+		return config, nil
+	}
+	gh, err := srv.NewGithubClient(appInstallationID)
+	if err != nil {
+		return nil, fmt.Errorf("error creating github client: %w", err)
+	}
+	config, err := loadRepoConfig(gh, repoOwner, repoName)
+	if err != nil {
+		return nil, err
+	}
+	srv.repoConfigs.Put(appInstallationID, repoOwner, repoName, config)
+	return config, nil
+}
+
+// CancelBuilds stops all Buildbot builds that are known to run for the given
+// check run.
+func (srv *AppServer) CancelBuilds(checkRunID int64, reason string) error {
+	for _, buildID := range srv.builds.Builds(checkRunID) {
+		if err := srv.stopBuildbotBuild(buildID, reason); err != nil {
+			return fmt.Errorf("failed to stop build %d of check run %d: %w", buildID, checkRunID, err)
+		}
+		log.Printf("stopped build %d of check run %d", buildID, checkRunID)
+	}
+	return nil
+}
+
+// WatchBuildTimeout cancels the builds of the given check run and completes it
+// as timed out unless Buildbot reports a completed build for it within the
+// given duration.
+func (srv *AppServer) WatchBuildTimeout(appInstallationID int64, repoOwner string, repoName string, checkRunID int64, timeout time.Duration) {
+	srv.timeouts.Start(checkRunID, timeout, func() {
+		if err := srv.timeOutCheckRun(appInstallationID, repoOwner, repoName, checkRunID, timeout); err != nil {
+			log.Printf("failed to time out check run %d: %v", checkRunID, err)
+		}
+	})
+}
+
+// TakeBuildToken counts a build or retry command of the commenter against the
+// rate limits of the repository.
+func (srv *AppServer) TakeBuildToken(repoOwner string, repoName string, commenter Commenter, limits RateLimits) error {
+	return srv.limiter.Take(repoOwner, repoName, commenter, limits)
+}
+
+// TrackCheckRun remembers a check run that was created for a build of the
+// given pull request until its builds are complete.
+func (srv *AppServer) TrackCheckRun(repoOwner string, repoName string, prNumber int, run inFlightCheckRun) {
+	srv.prBuilds.Add(repoOwner, repoName, prNumber, run)
+}
+
+// TakeSupersededCheckRuns forgets about and returns the tracked check runs of
+// the pull request that were created for another head SHA than the given one.
+func (srv *AppServer) TakeSupersededCheckRuns(repoOwner string, repoName string, prNumber int, headSHA string) []inFlightCheckRun {
+	return srv.prBuilds.TakeIf(repoOwner, repoName, prNumber, func(run inFlightCheckRun) bool {
+		return headSHA == "" || run.HeadSHA != headSHA
+	})
+}
+
+// timeOutCheckRun stops the builds of the given check run and completes it as
+// timed out unless it is already completed.
+func (srv *AppServer) timeOutCheckRun(appInstallationID int64, repoOwner string, repoName string, checkRunID int64, timeout time.Duration) error {
+	gh, err := srv.NewGithubClient(appInstallationID)
+	if err != nil {
+		return fmt.Errorf("error creating github client: %w", err)
+	}
+	checkRun, _, err := gh.Checks.GetCheckRun(context.Background(), repoOwner, repoName, checkRunID)
+	if err != nil {
+		return fmt.Errorf("error getting check run: %w", err)
+	}
+	if checkRun.GetStatus() == string(CheckRunStateCompleted) {
+		return nil
+	}
+	log.Printf("check run %d timed out after %s", checkRunID, timeout)
+	return StopCheckRun(srv, gh, repoOwner, repoName, checkRun, CheckRunConclusionTimedOut, fmt.Sprintf("Timed out after %s", timeout))
+}
+
 // ListenAndServer runs the app server's HTTP interface
 func (srv *AppServer) ListenAndServe() {
 	log.Printf("Listing for requests at http://%s\n", srv.bindAddress)
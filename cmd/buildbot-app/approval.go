@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+)
+
+// tag::park_for_approval[]
+// parkForApproval doesn't run the build of a first-time or external
+// contributor but creates a check run that waits for a maintainer's approval
+// and asks for it in a comment. The check run belongs to the pull request's
+// head SHA, so an approval only ever applies to the code that was there when
+// the build was requested. New commits need a new build request. Without
+// check runs (see Reporter.TracksBuilds) nothing can wait for an approval and
+// the build is refused.
+func (r commentRequest) parkForApproval(cmd *command.Command, policy AuthorizationPolicy) error {
+	if !r.reporter.TracksBuilds() {
+		msg := fmt.Sprintf(
+			"Sorry, but builds of first-time and external contributors have to be approved by a maintainer (%s) and approvals need check runs, which this repository doesn't use for builds. A maintainer can request the build instead.",
+			policy.approvers().whoMay(),
+		)
+		if err := r.reply(msg); err != nil {
+			return fmt.Errorf("failed to write comment about build that needs approval: %w", err)
+		}
+		return nil
+	}
+	msg := fmt.Sprintf(
+		"Builds of first-time and external contributors have to be approved by a maintainer (%s). A maintainer can approve this build for the current head SHA (%s) with <code>%s %s</code> or with the <i>Approve build</i> button of the check run. New commits need a new approval.",
+		policy.approvers().whoMay(),
+		r.pr.GetHead().GetSHA(),
+		command.BuildbotCommand,
+		command.SubcommandApprove,
+	)
+	meta := CheckRunMeta{
+		Command:  cmd,
+		Approval: &Approval{PullRequestNumber: r.prNumber, AuthorAssociation: r.commenter.AuthorAssociation},
+	}
+	return r.replyWithActionRequired(meta, "Waiting for approval", msg, ApprovalCheckRunActions())
+}
+
+// end::park_for_approval[]
+
+// approve runs the builds of the pull request's head SHA that wait for
+// approval if the commenter may approve them.
+func (r commentRequest) approve(policy AuthorizationPolicy) error {
+	approver := r.commenter
+	ok, err := policy.MayApprove(approver, githubTeamMembership(r.gh))
+	if err != nil {
+		return fmt.Errorf("failed to check if %s may approve builds: %w", approver.Login, err)
+	}
+	if !ok {
+		if err := r.reply(fmt.Sprintf("Sorry, but only %s may approve builds.", policy.approvers().whoMay())); err != nil {
+			return fmt.Errorf("failed to write comment about unauthorized approval: %w", err)
+		}
+		return nil
+	}
+
+	checkRuns, err := GetAllCheckRunsForPullRequest(r.gh, r.appInstallationID, r.pr)
+	if err != nil {
+		return fmt.Errorf("failed to get all check runs for pull request: %w", err)
+	}
+	approved, rateLimited, err := approveBuilds(r.srv, r.gh, r.appInstallationID, r.pr, approver.Login, checkRuns, r.config.RateLimits, r.thankYouComment)
+	if err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("There are no builds waiting for approval for this pull request's SHA (%s).", r.pr.GetHead().GetSHA())
+	if len(approved) > 0 || len(rateLimited) > 0 {
+		msg = approvalMessage(approved, rateLimited)
+	}
+	if err := r.reply(msg); err != nil {
+		return fmt.Errorf("failed to write approval comment: %w", err)
+	}
+	return nil
+}
+
+// waitsForApproval returns the meta data of the check run if it stands in
+// for a build that hasn't been approved yet.
+func waitsForApproval(checkRun *github.CheckRun) (*CheckRunMeta, bool) {
+	meta, err := CheckRunMetaFromCheckRun(checkRun)
+	if err != nil || meta.Approval == nil || meta.Approval.ApprovedBy != "" {
+		return nil, false
+	}
+	return meta, true
+}
+
+// approveBuilds runs the builds of all given check runs that wait for approval
+// and that belong to the pull request's current head SHA. The check runs are
+// completed as approved. Every build counts against the rate limits of the
+// user who requested it and builds over the limits keep waiting for approval.
+// For every approved and every rate limited check run, a markdown list entry
+// is returned.
+func approveBuilds(srv Server, gh *github.Client, appInstallationID int64, pr *github.PullRequest, approver string, checkRuns []*github.CheckRun, limits RateLimits, thankYouComment string) ([]string, []string, error) {
+	repoOwner := pr.GetBase().GetRepo().GetOwner().GetLogin()
+	repoName := pr.GetBase().GetRepo().GetName()
+	approved := []string{}
+	rateLimited := []string{}
+	for _, checkRun := range checkRuns {
+		meta, ok := waitsForApproval(checkRun)
+		if !ok || checkRun.GetHeadSHA() != pr.GetHead().GetSHA() {
+			continue
+		}
+		requester := Commenter{Login: meta.Command.CommentAuthor, AuthorAssociation: meta.Approval.AuthorAssociation}
+		var rateLimitErr *RateLimitError
+		if err := srv.TakeBuildToken(repoOwner, repoName, requester, limits); errors.As(err, &rateLimitErr) {
+			log.Printf("rate limited approved check run %d of %s: %v", checkRun.GetID(), requester.Login, rateLimitErr)
+			rateLimited = append(rateLimited, fmt.Sprintf("* %s: %s", checkRun.GetName(), rateLimitMessage(requester.Login, rateLimitErr, time.Now())))
+			continue
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("failed to check rate limits: %w", err)
+		}
+		log.Printf("%s approved check run %d", approver, checkRun.GetID())
+		if err := runBuild(srv, gh, appInstallationID, NewBuildTarget(pr), meta.Command, thankYouComment); err != nil {
+			return nil, nil, fmt.Errorf("failed to run approved build of check run %d: %w", checkRun.GetID(), err)
+		}
+
+		meta.Approval.ApprovedBy = approver
+		externalID, err := meta.ExternalID()
+		if err != nil {
+			return nil, nil, err
+		}
+		summary := WrapMsgWithTimePrefix(fmt.Sprintf("Approved by @%s. The build runs in a new check run.", approver), time.Now())
+		if checkRun.GetOutput().GetSummary() != "" {
+			summary = strings.Join([]string{checkRun.GetOutput().GetSummary(), summary}, "\n")
+		}
+		_, _, err = gh.Checks.UpdateCheckRun(context.Background(), repoOwner, repoName, checkRun.GetID(), github.UpdateCheckRunOptions{
+			Name:       checkRun.GetName(),
+			ExternalID: github.String(externalID),
+			Status:     github.String(string(CheckRunStateCompleted)),
+			Conclusion: github.String(string(CheckRunConclusionNeutral)),
+			Output: &github.CheckRunOutput{
+				Title:   github.String("Approved"),
+				Summary: github.String(summary),
+			},
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to complete check run %d as approved: %w", checkRun.GetID(), err)
+		}
+		approved = append(approved, fmt.Sprintf("* %s", checkRun.GetName()))
+	}
+	return approved, rateLimited, nil
+}
+
+// approvalMessage lists the builds that were approved and started and the
+// builds that still wait for approval because of rate limits.
+func approvalMessage(approved []string, rateLimited []string) string {
+	sections := []string{}
+	if len(approved) > 0 {
+		sections = append(sections, "These builds were approved and started:\n\n"+strings.Join(approved, "\n"))
+	}
+	if len(rateLimited) > 0 {
+		sections = append(sections, "These builds were not started because of rate limits and still wait for approval:\n\n"+strings.Join(rateLimited, "\n"))
+	}
+	return strings.Join(sections, "\n\n")
+}
+
+// onApproveBuildAction handles a click on the "Approve build" button of a
+// check run that waits for approval.
+func onApproveBuildAction(srv Server, event *github.CheckRunEvent) error {
+	checkRun := event.GetCheckRun()
+	meta, ok := waitsForApproval(checkRun)
+	if !ok {
+		log.Printf("check run %d doesn't wait for approval", checkRun.GetID())
+		return nil
+	}
+
+	appInstallationID := event.GetInstallation().GetID()
+	gh, err := srv.NewGithubClient(appInstallationID)
+	if err != nil {
+		return fmt.Errorf("error creating github client: %w", err)
+	}
+	repoOwner := event.GetRepo().GetOwner().GetLogin()
+	repoName := event.GetRepo().GetName()
+	// Check runs of pull requests from forks don't know their pull request,
+	// that's why we remember it ourselves.
+	pr, _, err := gh.PullRequests.Get(context.Background(), repoOwner, repoName, meta.Approval.PullRequestNumber)
+	if err != nil {
+		return fmt.Errorf("failed to get pull request: %w", err)
+	}
+	reply := func(msg string) error {
+		_, _, err := gh.Issues.CreateComment(context.Background(), repoOwner, repoName, pr.GetNumber(), &github.IssueComment{
+			Body: github.String(msg),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write approval comment: %w", err)
+		}
+		return nil
+	}
+
+	login := event.GetSender().GetLogin()
+	association, err := githubAuthorAssociation(gh, event.GetRepo(), login)
+	if err != nil {
+		return err
+	}
+	config, err := srv.GetRepoConfig(appInstallationID, repoOwner, repoName)
+	if err != nil {
+		return fmt.Errorf("failed to get repository configuration: %w", err)
+	}
+	policy := config.Authorization
+	ok, err = policy.MayApprove(Commenter{Login: login, AuthorAssociation: association}, githubTeamMembership(gh))
+	if err != nil {
+		return fmt.Errorf("failed to check if %s may approve builds: %w", login, err)
+	}
+	if !ok {
+		return reply(fmt.Sprintf("Sorry @%s, but only %s may approve builds.", login, policy.approvers().whoMay()))
+	}
+	if checkRun.GetHeadSHA() != pr.GetHead().GetSHA() {
+		return reply(fmt.Sprintf("Sorry @%s, but <code>%s</code> was requested for %s and new commits have been pushed since. Approvals only apply to the SHA that a build was requested for, so please request a new build.", login, checkRun.GetName(), checkRun.GetHeadSHA()))
+	}
+
+	thankYouComment := fmt.Sprintf("Thank you @%s for approving the build that @%s requested! ", login, meta.Command.CommentAuthor)
+	_, rateLimited, err := approveBuilds(srv, gh, appInstallationID, pr, login, []*github.CheckRun{checkRun}, config.RateLimits, thankYouComment)
+	if err != nil {
+		return err
+	}
+	if len(rateLimited) > 0 {
+		return reply(approvalMessage(nil, rateLimited))
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+)
+
+// tag::authorization_policy[]
+// An AuthorizationPolicy decides who may use which commands and options in a
+// repository (see RepoConfig). The deny list is checked first, then the allow
+// list and then every rule that applies to the command. An empty policy allows
+// everything but asks maintainers to approve the builds of first-time and
+// external contributors.
+type AuthorizationPolicy struct {
+	// GitHub logins that may use any command and option
+	AllowUsers []string `yaml:"allow_users,omitempty"`
+	// GitHub logins that may not use /buildbot at all
+	DenyUsers []string            `yaml:"deny_users,omitempty"`
+	Rules     []AuthorizationRule `yaml:"rules,omitempty"`
+	// Author associations of commenters whose builds have to be approved by
+	// a maintainer. If not given, FIRST_TIMER, FIRST_TIME_CONTRIBUTOR and NONE
+	// need approval. An empty list turns approvals off.
+	ApprovalRequiredFor []string `yaml:"approval_required_for"`
+	// Who may approve builds. Only the associations, teams and users of the
+	// rule are used. If not given, OWNER, MEMBER and COLLABORATOR may.
+	Approvers *AuthorizationRule `yaml:"approvers,omitempty"`
+}
+
+// An AuthorizationRule restricts a command or an option to commenters with
+// one of the given author associations (OWNER, MEMBER, COLLABORATOR,
+// CONTRIBUTOR, NONE, ...), to members of one of the given teams or to one of
+// the given users.
+type AuthorizationRule struct {
+	// Subcommand the rule applies to (e.g. "cancel"). Empty means any.
+	Subcommand string `yaml:"subcommand,omitempty"`
+	// Option the rule applies to (e.g. "force"). Empty means the rule applies
+	// to every command of the Subcommand.
+	Option string `yaml:"option,omitempty"`
+	// Value of the Option for which the rule applies (e.g. "yes"). For list
+	// options it's enough if the list contains the value and for map options
+	// (e.g. prop) the value is the name of a key. Empty means the rule applies
+	// whenever the option isn't empty.
+	Value        string   `yaml:"value,omitempty"`
+	Associations []string `yaml:"associations,omitempty"`
+	// Teams as "org/team-slug"
+	Teams []string `yaml:"teams,omitempty"`
+	Users []string `yaml:"users,omitempty"`
+}
+
+// end::authorization_policy[]
+
+// Validate returns an error if a rule refers to an unknown subcommand, option
+// or to a value that the option doesn't accept.
+func (p AuthorizationPolicy) Validate() error {
+	for i, rule := range p.Rules {
+		if rule.Subcommand != "" && command.Subcommand(rule.Subcommand).Definition() == nil {
+			return fmt.Errorf("rule %d: unknown subcommand %q", i+1, rule.Subcommand)
+		}
+		if rule.Option == "" {
+			if rule.Value != "" {
+				return fmt.Errorf("rule %d: value %q given without an option", i+1, rule.Value)
+			}
+			continue
+		}
+		o := command.OptionByName(rule.Option)
+		if o == nil {
+			return fmt.Errorf("rule %d: unknown option %q", i+1, rule.Option)
+		}
+		if rule.Value == "" || o.Type == command.OptionTypeMap {
+			continue
+		}
+		if _, err := o.Parse(rule.Value); err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+var (
+	defaultApprovalRequiredFor = []string{"FIRST_TIMER", "FIRST_TIME_CONTRIBUTOR", "NONE"}
+	defaultApprovers           = AuthorizationRule{Associations: []string{"OWNER", "MEMBER", "COLLABORATOR"}}
+)
+
+// RequiresApproval returns true if the builds of the commenter have to be
+// approved by a maintainer before they run. Users on the allow list never
+// need approval.
+func (p AuthorizationPolicy) RequiresApproval(commenter Commenter) bool {
+	if containsFold(p.AllowUsers, commenter.Login) {
+		return false
+	}
+	requiredFor := p.ApprovalRequiredFor
+	if requiredFor == nil {
+		requiredFor = defaultApprovalRequiredFor
+	}
+	return containsFold(requiredFor, commenter.AuthorAssociation)
+}
+
+// approvers returns the rule that tells who may approve builds.
+func (p AuthorizationPolicy) approvers() AuthorizationRule {
+	if p.Approvers == nil {
+		return defaultApprovers
+	}
+	return *p.Approvers
+}
+
+// MayApprove returns true if the user may approve the builds of others. Users
+// on the allow list may, users on the deny list may not.
+func (p AuthorizationPolicy) MayApprove(user Commenter, isTeamMember isTeamMemberFunc) (bool, error) {
+	if containsFold(p.DenyUsers, user.Login) {
+		return false, nil
+	}
+	if containsFold(p.AllowUsers, user.Login) {
+		return true, nil
+	}
+	return p.approvers().matches(user, isTeamMember)
+}
+
+// A Commenter is the GitHub user whose command is authorized.
+type Commenter struct {
+	Login string
+	// AuthorAssociation of the user's comment (e.g. MEMBER)
+	AuthorAssociation string
+}
+
+// isTeamMemberFunc returns true if the user with the given login is a member
+// of the team with the given slug in the given organization.
+type isTeamMemberFunc func(org string, teamSlug string, login string) (bool, error)
+
+// Authorize returns the reasons why the commenter may not run the command. If
+// the returned list is empty, the command is authorized.
+func (p AuthorizationPolicy) Authorize(cmd *command.Command, commenter Commenter, isTeamMember isTeamMemberFunc) ([]string, error) {
+	if containsFold(p.DenyUsers, commenter.Login) {
+		return []string{fmt.Sprintf("@%s may not use <code>%s</code> in this repository", commenter.Login, command.BuildbotCommand)}, nil
+	}
+	if containsFold(p.AllowUsers, commenter.Login) {
+		return []string{}, nil
+	}
+	reasons := []string{}
+	for _, rule := range p.Rules {
+		if !rule.appliesTo(cmd) {
+			continue
+		}
+		ok, err := rule.matches(commenter, isTeamMember)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			reasons = append(reasons, fmt.Sprintf("<code>%s</code> may only be used by %s", rule.label(), rule.whoMay()))
+		}
+	}
+	return reasons, nil
+}
+
+// appliesTo returns true if the command uses the subcommand and option of
+// the rule.
+func (r AuthorizationRule) appliesTo(cmd *command.Command) bool {
+	if r.Subcommand != "" && !strings.EqualFold(r.Subcommand, string(cmd.Subcommand)) {
+		return false
+	}
+	if r.Option == "" {
+		return true
+	}
+	o := command.OptionByName(r.Option)
+	if o == nil {
+		return false
+	}
+	if r.Value == "" {
+		return !o.IsEmpty(*cmd)
+	}
+	switch v := o.Get(*cmd).(type) {
+	case map[string]string:
+		_, ok := v[strings.ToLower(r.Value)]
+		return ok
+	case []string:
+		return containsFold(v, r.Value)
+	}
+	value, err := o.Parse(r.Value)
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(value, o.Get(*cmd))
+}
+
+// matches returns true if the commenter is one of the users, has one of the
+// author associations or is a member of one of the teams of the rule.
+func (r AuthorizationRule) matches(commenter Commenter, isTeamMember isTeamMemberFunc) (bool, error) {
+	if containsFold(r.Users, commenter.Login) || containsFold(r.Associations, commenter.AuthorAssociation) {
+		return true, nil
+	}
+	for _, team := range r.Teams {
+		org, slug, ok := strings.Cut(team, "/")
+		if !ok {
+			return false, fmt.Errorf("team %q is not of the form org/team-slug", team)
+		}
+		member, err := isTeamMember(org, slug, commenter.Login)
+		if err != nil {
+			return false, err
+		}
+		if member {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// label returns what the rule restricts as it would be written in a command
+// (e.g. "force=yes" or "/buildbot cancel").
+func (r AuthorizationRule) label() string {
+	switch {
+	case r.Option == "" && r.Subcommand == "":
+		return command.BuildbotCommand
+	case r.Option == "":
+		return command.BuildbotCommand + " " + r.Subcommand
+	case r.Value == "":
+		return r.Option
+	}
+	if o := command.OptionByName(r.Option); o != nil && o.Type == command.OptionTypeMap {
+		return fmt.Sprintf("%s:%s", r.Option, r.Value)
+	}
+	return fmt.Sprintf("%s=%s", r.Option, r.Value)
+}
+
+// whoMay describes the users that match the rule (e.g. "OWNER, MEMBER or
+// members of @org/team").
+func (r AuthorizationRule) whoMay() string {
+	who := append([]string{}, r.Associations...)
+	for _, team := range r.Teams {
+		who = append(who, "members of @"+team)
+	}
+	for _, user := range r.Users {
+		who = append(who, "@"+user)
+	}
+	switch len(who) {
+	case 0:
+		return "nobody"
+	case 1:
+		return who[0]
+	}
+	return strings.Join(who[:len(who)-1], ", ") + " or " + who[len(who)-1]
+}
+
+// githubTeamMembership asks GitHub whether a user is an active member of a
+// team. The app needs read access to the organization's members for this.
+func githubTeamMembership(gh *github.Client) isTeamMemberFunc {
+	return func(org string, teamSlug string, login string) (bool, error) {
+		membership, resp, err := gh.Teams.GetTeamMembershipBySlug(context.Background(), org, teamSlug, login)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to get membership of %s in team %s/%s: %w", login, org, teamSlug, err)
+		}
+		return membership.GetState() == "active", nil
+	}
+}
+
+// githubAuthorAssociation tells how a user is associated with a repository
+// for events that don't say so (e.g. when a check run button is clicked). It
+// only tells apart OWNER, MEMBER (of the organization that owns the
+// repository), COLLABORATOR (with write access) and NONE. Repositories of a
+// user have no members, so only the collaborator permission counts for them.
+func githubAuthorAssociation(gh *github.Client, repo *github.Repository, login string) (string, error) {
+	repoOwner := repo.GetOwner().GetLogin()
+	repoName := repo.GetName()
+	if strings.EqualFold(login, repoOwner) {
+		return "OWNER", nil
+	}
+	if repo.GetOwner().GetType() != "User" {
+		member, _, err := gh.Organizations.IsMember(context.Background(), repoOwner, login)
+		if err != nil {
+			return "", fmt.Errorf("failed to check if %s is a member of %s: %w", login, repoOwner, err)
+		}
+		if member {
+			return "MEMBER", nil
+		}
+	}
+	permission, _, err := gh.Repositories.GetPermissionLevel(context.Background(), repoOwner, repoName, login)
+	if err != nil {
+		return "", fmt.Errorf("failed to get permission of %s for %s/%s: %w", login, repoOwner, repoName, err)
+	}
+	switch permission.GetPermission() {
+	case "admin", "maintain", "write":
+		return "COLLABORATOR", nil
+	}
+	return "NONE", nil
+}
+
+// unauthorizedMessage explains why a command was not run.
+func unauthorizedMessage(reasons []string) string {
+	return fmt.Sprintf("Sorry, but you are not allowed to run this command:\n\n* %s\n\nPlease ask a maintainer of this repository to run it for you.", strings.Join(reasons, "\n* "))
+}
+
+// containsFold returns true if s is an element of list when ignoring case.
+func containsFold(list []string, s string) bool {
+	for _, e := range list {
+		if strings.EqualFold(e, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+	"github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorizationPolicy_Authorize(t *testing.T) {
+	policy := AuthorizationPolicy{
+		AllowUsers: []string{"trusted"},
+		DenyUsers:  []string{"spammer"},
+		Rules: []AuthorizationRule{
+			{Option: command.CommandOptionForce, Value: "yes", Associations: []string{"OWNER", "MEMBER"}},
+			{Option: command.CommandOptionMandatory, Value: "no", Associations: []string{"MEMBER"}, Teams: []string{"llvm/release-managers"}},
+			{Option: command.CommandOptionBuilder, Value: "expensive", Users: []string{"janedoe"}},
+			{Subcommand: string(command.SubcommandCancel), Associations: []string{"OWNER", "MEMBER", "COLLABORATOR"}},
+		},
+	}
+	require.NoError(t, policy.Validate())
+	isTeamMember := func(org string, teamSlug string, login string) (bool, error) {
+		return org == "llvm" && teamSlug == "release-managers" && login == "releaser", nil
+	}
+
+	tests := []struct {
+		name      string
+		command   string
+		commenter Commenter
+		want      []string
+	}{
+		{"plain build", "/buildbot builder=foo", Commenter{"johndoe", "NONE"}, []string{}},
+		{"force by member", "/buildbot builder=foo force=yes", Commenter{"johndoe", "MEMBER"}, []string{}},
+		{"force by contributor", "/buildbot builder=foo force=yes", Commenter{"johndoe", "CONTRIBUTOR"}, []string{"<code>force=yes</code> may only be used by OWNER or MEMBER"}},
+		{"optional by team member", "/buildbot builder=foo mandatory=no", Commenter{"releaser", "CONTRIBUTOR"}, []string{}},
+		{
+			"optional and forced by contributor",
+			"/buildbot builder=foo mandatory=no force=yes",
+			Commenter{"johndoe", "CONTRIBUTOR"},
+			[]string{"<code>force=yes</code> may only be used by OWNER or MEMBER", "<code>mandatory=no</code> may only be used by MEMBER or members of @llvm/release-managers"},
+		},
+		{"builder by user", "/buildbot builder=foo builder=expensive", Commenter{"JaneDoe", "NONE"}, []string{}},
+		{"builder by other user", "/buildbot builder=foo builder=expensive", Commenter{"johndoe", "MEMBER"}, []string{"<code>builder=expensive</code> may only be used by @janedoe"}},
+		{"cancel by contributor", "/buildbot cancel", Commenter{"johndoe", "CONTRIBUTOR"}, []string{"<code>/buildbot cancel</code> may only be used by OWNER, MEMBER or COLLABORATOR"}},
+		{"allowed user", "/buildbot builder=expensive force=yes", Commenter{"trusted", "NONE"}, []string{}},
+		{"denied user", "/buildbot builder=foo", Commenter{"spammer", "OWNER"}, []string{"@spammer may not use <code>/buildbot</code> in this repository"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := command.FromString(tt.command)
+			require.NoError(t, err)
+			got, err := policy.Authorize(cmd, tt.commenter, isTeamMember)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("team membership error", func(t *testing.T) {
+		cmd, err := command.FromString("/buildbot mandatory=no")
+		require.NoError(t, err)
+		_, err = policy.Authorize(cmd, Commenter{"johndoe", "NONE"}, func(string, string, string) (bool, error) {
+			return false, fmt.Errorf("forbidden")
+		})
+		require.ErrorContains(t, err, "forbidden")
+	})
+}
+
+func TestAuthorizationPolicy_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    AuthorizationRule
+		wantErr string
+	}{
+		{"valid", AuthorizationRule{Option: command.CommandOptionForce, Value: "yes"}, ""},
+		{"map option", AuthorizationRule{Option: command.CommandOptionProperty, Value: "test_filter"}, ""},
+		{"unknown subcommand", AuthorizationRule{Subcommand: "deploy"}, `rule 1: unknown subcommand "deploy"`},
+		{"unknown option", AuthorizationRule{Option: "forse"}, `rule 1: unknown option "forse"`},
+		{"invalid value", AuthorizationRule{Option: command.CommandOptionForce, Value: "maybe"}, `rule 1: invalid boolean value "maybe" for option "force"`},
+		{"value without option", AuthorizationRule{Value: "yes"}, `rule 1: value "yes" given without an option`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := AuthorizationPolicy{Rules: []AuthorizationRule{tt.rule}}.Validate()
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestAuthorizationPolicy_Approval(t *testing.T) {
+	isTeamMember := func(org string, teamSlug string, login string) (bool, error) {
+		return org == "llvm" && teamSlug == "reviewers" && login == "reviewer", nil
+	}
+	tests := []struct {
+		name             string
+		policy           AuthorizationPolicy
+		user             Commenter
+		requiresApproval bool
+		mayApprove       bool
+	}{
+		{"first-time contributor", AuthorizationPolicy{}, Commenter{"newbie", "FIRST_TIME_CONTRIBUTOR"}, true, false},
+		{"no association", AuthorizationPolicy{}, Commenter{"newbie", "NONE"}, true, false},
+		{"contributor", AuthorizationPolicy{}, Commenter{"johndoe", "CONTRIBUTOR"}, false, false},
+		{"collaborator", AuthorizationPolicy{}, Commenter{"johndoe", "COLLABORATOR"}, false, true},
+		{"allowed user", AuthorizationPolicy{AllowUsers: []string{"newbie"}}, Commenter{"newbie", "NONE"}, false, true},
+		{"denied user", AuthorizationPolicy{DenyUsers: []string{"johndoe"}}, Commenter{"johndoe", "OWNER"}, false, false},
+		{"approvals turned off", AuthorizationPolicy{ApprovalRequiredFor: []string{}}, Commenter{"newbie", "NONE"}, false, false},
+		{"custom associations", AuthorizationPolicy{ApprovalRequiredFor: []string{"CONTRIBUTOR"}}, Commenter{"johndoe", "CONTRIBUTOR"}, true, false},
+		{"team approver", AuthorizationPolicy{Approvers: &AuthorizationRule{Teams: []string{"llvm/reviewers"}}}, Commenter{"reviewer", "NONE"}, true, true},
+		{"owner without being an approver", AuthorizationPolicy{Approvers: &AuthorizationRule{Teams: []string{"llvm/reviewers"}}}, Commenter{"janedoe", "OWNER"}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.requiresApproval, tt.policy.RequiresApproval(tt.user))
+			mayApprove, err := tt.policy.MayApprove(tt.user, isTeamMember)
+			require.NoError(t, err)
+			require.Equal(t, tt.mayApprove, mayApprove)
+		})
+	}
+}
+
+func TestGithubAuthorAssociation(t *testing.T) {
+	tests := []struct {
+		name       string
+		ownerType  string
+		login      string
+		isMember   bool
+		permission string
+		want       string
+	}{
+		{"owner", "User", "janedoe", false, "admin", "OWNER"},
+		{"organization member", "Organization", "johndoe", true, "read", "MEMBER"},
+		{"organization collaborator", "Organization", "johndoe", false, "write", "COLLABORATOR"},
+		{"organization outsider", "Organization", "johndoe", false, "read", "NONE"},
+		{"user's collaborator", "User", "johndoe", false, "write", "COLLABORATOR"},
+		{"user's outsider", "User", "johndoe", false, "read", "NONE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh := github.NewClient(mock.NewMockedHTTPClient(
+				mock.WithRequestMatchHandler(
+					mock.GetOrgsMembersByOrgByUsername,
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						if tt.ownerType == "User" {
+							t.Errorf("asked for members of a user")
+						}
+						if tt.isMember {
+							w.WriteHeader(http.StatusNoContent)
+							return
+						}
+						w.WriteHeader(http.StatusNotFound)
+					}),
+				),
+				mock.WithRequestMatch(
+					mock.GetReposCollaboratorsPermissionByOwnerByRepoByUsername,
+					github.RepositoryPermissionLevel{Permission: github.String(tt.permission)},
+				),
+			))
+			repo := &github.Repository{
+				Owner: &github.User{Login: github.String("janedoe"), Type: github.String(tt.ownerType)},
+				Name:  github.String("examplerepo"),
+			}
+			got, err := githubAuthorAssociation(gh, repo, tt.login)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
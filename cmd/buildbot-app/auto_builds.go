@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/cbrgm/githubevents/githubevents"
+	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+)
+
+// tag::auto_builds[]
+// An AutoBuildRule starts a build without a /buildbot comment when a pull
+// request that matches all of the rule's conditions is opened, pushed to,
+// reopened or marked as ready for review. Conditions that aren't given match
+// every pull request.
+type AutoBuildRule struct {
+	// Patterns of the base branch (e.g. main or release/*, see path.Match)
+	Branches []string `yaml:"branches"`
+	// The pull request needs at least one of these labels
+	Labels []string `yaml:"labels"`
+	// Draft pull requests are only built if this is true
+	Drafts bool `yaml:"drafts"`
+	// Patterns of files of which the pull request has to change at least one
+	// (e.g. *.go or docs/**, see matchPath)
+	Paths []string `yaml:"paths"`
+	// The options of the build (e.g. "preset=full priority=low")
+	Command BuildOptions `yaml:"command"`
+}
+
+// BuildOptions are the options of a build that the app starts on its own, as
+// in a /buildbot comment (e.g. "preset=full"). If they are empty, the default
+// builders are built.
+type BuildOptions string
+
+// end::auto_builds[]
+
+// commandLine returns the rule's command as if it was written in a comment.
+func (r AutoBuildRule) commandLine() string {
+	return r.Command.commandLine()
+}
+
+// commandLine returns the options as if they were written in a comment.
+func (o BuildOptions) commandLine() string {
+	return strings.TrimSpace(command.BuildbotCommand + " " + string(o))
+}
+
+// validate returns an error if a pattern or the command of the rule is
+// invalid or if the rule wouldn't build anything.
+func (r AutoBuildRule) validate(c RepoConfig) error {
+	for _, pattern := range append(append([]string{}, r.Branches...), r.Paths...) {
+		if _, err := path.Match(strings.TrimSuffix(pattern, "/**"), ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return c.validateBuildCommand(r.commandLine())
+}
+
+// validateBuildCommand returns an error if the command line of a build that
+// starts without a /buildbot comment is invalid or wouldn't build anything.
+func (c RepoConfig) validateBuildCommand(commandLine string) error {
+	cmd, err := command.FromStringWithPresets(c.ExpandAliases(commandLine), c.Presets)
+	if err != nil {
+		return fmt.Errorf("invalid command: %w", err)
+	}
+	if cmd.Subcommand != command.SubcommandBuild {
+		return fmt.Errorf("only builds can be started automatically, not %s", cmd.Subcommand)
+	}
+	if len(cmd.BuilderNames) == 0 && len(cmd.Selectors()) == 0 && len(c.DefaultBuilders) == 0 {
+		return fmt.Errorf("the command has no builders and there are no default builders")
+	}
+	if disallowed := c.DisallowedBuilders(cmd.BuilderNames); len(disallowed) > 0 {
+		return fmt.Errorf("builders are not allowed: %s", strings.Join(disallowed, ", "))
+	}
+	return nil
+}
+
+// configuredBuildCommand returns the command of a build with the given
+// options from the repository configuration that starts without a pull
+// request (e.g. of a merge group). Without builders and selectors, the
+// default builders are built.
+func configuredBuildCommand(srv Server, config *RepoConfig, options BuildOptions) (*command.Command, error) {
+	cmd, err := command.FromStringWithPresets(config.ExpandAliases(options.commandLine()), config.Presets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse command: %w", err)
+	}
+	if len(cmd.BuilderNames) == 0 && len(cmd.Selectors()) == 0 {
+		cmd.BuilderNames = append([]string{}, config.DefaultBuilders...)
+		sort.Strings(cmd.BuilderNames)
+	}
+	if cmd.IsMatrix() {
+		return cmd, nil
+	}
+	inventory, err := srv.GetBuilderInventory()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get builder inventory: %w", err)
+	}
+	if err := cmd.ResolveBuilderNames(config.FilterInventory(inventory)); err != nil {
+		return nil, fmt.Errorf("failed to resolve builder names: %w", err)
+	}
+	return cmd, nil
+}
+
+// matches returns true if the pull request meets all conditions of the rule.
+// changedFiles are the files that the pull request changes.
+func (r AutoBuildRule) matches(pr *github.PullRequest, changedFiles []string) bool {
+	if pr.GetDraft() && !r.Drafts {
+		return false
+	}
+	if len(r.Branches) > 0 && !matchesAny(r.Branches, pr.GetBase().GetRef(), path.Match) {
+		return false
+	}
+	if len(r.Labels) > 0 {
+		found := false
+		for _, label := range pr.Labels {
+			if containsFold(r.Labels, label.GetName()) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.Paths) > 0 {
+		found := false
+		for _, file := range changedFiles {
+			if matchesAny(r.Paths, file, matchPath) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// matchPath is like path.Match but a pattern that ends in /** matches every
+// file below the directory and a pattern without a slash matches the base
+// name of the file (e.g. *.go matches cmd/main.go).
+func matchPath(pattern string, name string) (bool, error) {
+	if strings.HasSuffix(pattern, "/**") {
+		return strings.HasPrefix(name, strings.TrimSuffix(pattern, "**")), nil
+	}
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(name)
+	}
+	return path.Match(pattern, name)
+}
+
+// matchesAny returns true if one of the patterns matches the name.
+func matchesAny(patterns []string, name string, match func(pattern string, name string) (bool, error)) bool {
+	for _, pattern := range patterns {
+		if ok, err := match(pattern, name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// autoBuildActions describe the pull request actions that start automatic
+// builds.
+var autoBuildActions = map[string]string{
+	"opened":           "opened",
+	"synchronize":      "pushed to",
+	"reopened":         "reopened",
+	"ready_for_review": "marked as ready for review",
+}
+
+// OnPullRequestEventAutoBuild starts the builds of the repository's
+// AutoBuilds rules that match a pull request that was opened, pushed to,
+// reopened or marked as ready for review. The builds go through the same
+// steps as the ones of a /buildbot comment of the user who triggered the
+// event, so authorization, approval and rate limits apply to them as well.
+func OnPullRequestEventAutoBuild(srv Server) githubevents.PullRequestEventHandleFunc {
+	return func(deliveryID string, eventName string, event *github.PullRequestEvent) error {
+		if event == nil || event.PullRequest == nil {
+			return nil
+		}
+		action, ok := autoBuildActions[event.GetAction()]
+		if !ok {
+			return nil
+		}
+		appInstallationID := event.GetInstallation().GetID()
+		repoOwner := event.GetRepo().GetOwner().GetLogin()
+		repoName := event.GetRepo().GetName()
+		pr := event.GetPullRequest()
+
+		// An invalid configuration is reported on the next /buildbot comment.
+		// Reporting it on every push would flood the pull request.
+		config, err := srv.GetRepoConfig(appInstallationID, repoOwner, repoName)
+		var configErr *RepoConfigError
+		if errors.As(err, &configErr) {
+			log.Printf("no automatic builds for %s/%s#%d: %v", repoOwner, repoName, pr.GetNumber(), configErr)
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to get repository configuration: %w", err)
+		}
+		if len(config.AutoBuilds) == 0 {
+			return nil
+		}
+
+		gh, err := srv.NewGithubClient(appInstallationID)
+		if err != nil {
+			return fmt.Errorf("error creating github client: %w", err)
+		}
+		changedFiles := []string{}
+		if config.autoBuildsNeedChangedFiles() {
+			changedFiles, err = getChangedFiles(gh, repoOwner, repoName, pr.GetNumber())
+			if err != nil {
+				return err
+			}
+		}
+		commandLines := []command.CommandLine{}
+		seen := map[string]bool{}
+		for i, rule := range config.AutoBuilds {
+			if !rule.matches(pr, changedFiles) || seen[rule.commandLine()] {
+				continue
+			}
+			seen[rule.commandLine()] = true
+			commandLines = append(commandLines, command.CommandLine{Number: i + 1, Text: rule.commandLine()})
+		}
+		if len(commandLines) == 0 {
+			log.Printf("no automatic build rule matches %s/%s#%d", repoOwner, repoName, pr.GetNumber())
+			return nil
+		}
+
+		r, err := newPullRequestEventRequest(srv, gh, config, event, command.TriggerAutomatic)
+		if err != nil {
+			return err
+		}
+		r.thankYouComment = fmt.Sprintf(
+			`@%s %s this pull request, so the <a href="%s">buildbot app</a> builds it automatically. `,
+			r.commenter.Login,
+			action,
+			config.docsURL(),
+		)
+		errs := []string{}
+		for _, commandLine := range commandLines {
+			if err := r.handleCommandLine(commandLine); err != nil {
+				log.Printf("failed to run automatic build of rule %d: %v", commandLine.Number, err)
+				errs = append(errs, fmt.Sprintf("rule %d: %v", commandLine.Number, err))
+			}
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("failed to run %d of %d automatic build(s): %s", len(errs), len(commandLines), strings.Join(errs, "; "))
+		}
+		return nil
+	}
+}
+
+// newPullRequestEventRequest returns a request for commands that the sender
+// of the pull request event started without a /buildbot comment. The sender
+// takes the place of the commenter, so authorization, approval and rate
+// limits apply to them.
+func newPullRequestEventRequest(srv Server, gh *github.Client, config *RepoConfig, event *github.PullRequestEvent, trigger string) (*commentRequest, error) {
+	repoOwner := event.GetRepo().GetOwner().GetLogin()
+	repoName := event.GetRepo().GetName()
+	pr := event.GetPullRequest()
+	login := event.GetSender().GetLogin()
+	association := pr.GetAuthorAssociation()
+	if !strings.EqualFold(login, pr.GetUser().GetLogin()) {
+		var err error
+		association, err = githubAuthorAssociation(gh, event.GetRepo(), login)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &commentRequest{
+		srv:               srv,
+		gh:                gh,
+		appInstallationID: event.GetInstallation().GetID(),
+		repoOwner:         repoOwner,
+		repoName:          repoName,
+		prNumber:          pr.GetNumber(),
+		pr:                pr,
+		commenter:         Commenter{Login: login, AuthorAssociation: association},
+		trigger:           trigger,
+		config:            config,
+	}, nil
+}
+
+// autoBuildsNeedChangedFiles returns true if a rule depends on the files that
+// a pull request changes.
+func (c RepoConfig) autoBuildsNeedChangedFiles() bool {
+	for _, rule := range c.AutoBuilds {
+		if len(rule.Paths) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// getChangedFiles returns the names of the files that the pull request
+// changes.
+func getChangedFiles(gh *github.Client, repoOwner string, repoName string, prNumber int) ([]string, error) {
+	pagesRemaining := true
+	names := []string{}
+	listOpts := &github.ListOptions{
+		Page:    1,
+		PerPage: 100,
+	}
+	for pagesRemaining {
+		files, resp, err := gh.PullRequests.ListFiles(context.Background(), repoOwner, repoName, prNumber, listOpts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list changed files: %w", err)
+		}
+		if resp.NextPage == 0 {
+			pagesRemaining = false
+		} else {
+			listOpts.Page = resp.NextPage
+		}
+		for _, file := range files {
+			names = append(names, file.GetFilename())
+		}
+	}
+	return names, nil
+}
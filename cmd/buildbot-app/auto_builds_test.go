@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "cmd/buildbot-app/main.go", true},
+		{"*.go", "README.adoc", false},
+		{"docs/**", "docs/media/screenshot.png", true},
+		{"docs/**", "docs.go", false},
+		{"cmd/*/main.go", "cmd/buildbot-app/main.go", true},
+		{"cmd/*.go", "cmd/buildbot-app/main.go", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.name, func(t *testing.T) {
+			got, err := matchPath(tt.pattern, tt.name)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAutoBuildRule_matches(t *testing.T) {
+	pr := &github.PullRequest{
+		Base:   &github.PullRequestBranch{Ref: github.String("release/17.x")},
+		Labels: []*github.Label{{Name: github.String("Backport")}},
+	}
+	draft := &github.PullRequest{
+		Base:  &github.PullRequestBranch{Ref: github.String("main")},
+		Draft: github.Bool(true),
+	}
+	changedFiles := []string{"llvm/lib/IR/Core.cpp", "llvm/docs/index.rst"}
+	tests := []struct {
+		name string
+		rule AutoBuildRule
+		pr   *github.PullRequest
+		want bool
+	}{
+		{"no conditions", AutoBuildRule{}, pr, true},
+		{"branch", AutoBuildRule{Branches: []string{"main", "release/*"}}, pr, true},
+		{"other branch", AutoBuildRule{Branches: []string{"main"}}, pr, false},
+		{"label", AutoBuildRule{Labels: []string{"backport"}}, pr, true},
+		{"missing label", AutoBuildRule{Labels: []string{"ci"}}, pr, false},
+		{"path", AutoBuildRule{Paths: []string{"*.cpp"}}, pr, true},
+		{"other path", AutoBuildRule{Paths: []string{"clang/**"}}, pr, false},
+		{"all conditions", AutoBuildRule{Branches: []string{"release/*"}, Labels: []string{"backport"}, Paths: []string{"llvm/**"}}, pr, true},
+		{"draft", AutoBuildRule{}, draft, false},
+		{"drafts", AutoBuildRule{Drafts: true}, draft, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.rule.matches(tt.pr, changedFiles))
+		})
+	}
+}
+
+func TestOnPullRequestEventAutoBuild(t *testing.T) {
+	prEventOK := func(action string, draft bool) *github.PullRequestEvent {
+		pr := prOK()
+		pr.Mergeable = nil
+		pr.Number = github.Int(123)
+		pr.Draft = github.Bool(draft)
+		pr.User = &github.User{Login: github.String("johndoe")}
+		pr.AuthorAssociation = github.String("CONTRIBUTOR")
+		pr.Base.Ref = github.String("main")
+		pr.Base.SHA = github.String("1ea3fd4f1b3f2b58a7a2f2c2a25d1ecf0c8ff40b")
+		pr.Head.Ref = github.String("feature")
+		return &github.PullRequestEvent{
+			Action:       github.String(action),
+			PullRequest:  &pr,
+			Installation: &github.Installation{ID: github.Int64(1234)},
+			Repo: &github.Repository{
+				Owner: &github.User{Login: github.String("janedoe")},
+				Name:  github.String("examplerepo"),
+			},
+			Sender: &github.User{Login: github.String("johndoe")},
+		}
+	}
+	config := &RepoConfig{
+		DefaultBuilders: []string{"linux"},
+		Authorization:   AuthorizationPolicy{ApprovalRequiredFor: []string{}},
+		AutoBuilds: []AutoBuildRule{
+			{Branches: []string{"main"}},
+			{Branches: []string{"main"}, Paths: []string{"docs/**"}, Command: "builder=docs priority=low"},
+			{Branches: []string{"release/*"}, Command: "builder=macos"},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		event         *github.PullRequestEvent
+		config        *RepoConfig
+		wantCheckRuns []string
+	}{
+		{
+			"opened",
+			prEventOK("opened", false),
+			config,
+			[]string{
+				"[automatic] /buildbot mandatory=true force=false builder=[linux]",
+				"[automatic] /buildbot mandatory=true force=false builder=[docs]",
+			},
+		},
+		{"draft", prEventOK("synchronize", true), config, []string{}},
+		{"other action", prEventOK("edited", false), config, []string{}},
+		{"no rules", prEventOK("opened", false), &RepoConfig{DefaultBuilders: []string{"linux"}}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkRunNames := []string{}
+			srv := NewMockServer(
+				mock.WithRequestMatch(
+					mock.GetReposPullsFilesByOwnerByRepoByPullNumber,
+					[]github.CommitFile{{Filename: github.String("docs/README.in.adoc")}},
+				),
+				mock.WithRequestMatchHandler(
+					mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						w.Write(mock.MustMarshal(github.ListCheckRunsResults{Total: github.Int(0)}))
+					}),
+				),
+				mock.WithRequestMatchHandler(
+					mock.PostReposIssuesCommentsByOwnerByRepoByIssueNumber,
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						comment := github.IssueComment{}
+						require.NoError(t, json.NewDecoder(r.Body).Decode(&comment))
+						require.Contains(t, comment.GetBody(), "@johndoe opened this pull request, so the")
+						w.Write(mock.MustMarshal(github.IssueComment{ID: github.Int64(1)}))
+					}),
+				),
+				mock.WithRequestMatchHandler(
+					mock.PostReposCheckRunsByOwnerByRepo,
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						opts := github.CreateCheckRunOptions{}
+						require.NoError(t, json.NewDecoder(r.Body).Decode(&opts))
+						checkRunNames = append(checkRunNames, opts.Name)
+						w.Write(mock.MustMarshal(github.CheckRun{ID: github.Int64(int64(len(checkRunNames)))}))
+					}),
+				),
+			)
+			srv.repoConfig = tt.config
+			err := OnPullRequestEventAutoBuild(srv)("1234", "pull_request", tt.event)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantCheckRuns, checkRunNames)
+			require.Len(t, *srv.tryBotRuns, len(tt.wantCheckRuns))
+			for _, run := range *srv.tryBotRuns {
+				require.Contains(t, run, "--property=command_trigger=automatic")
+			}
+		})
+	}
+}
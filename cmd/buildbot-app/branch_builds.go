@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/cbrgm/githubevents/githubevents"
+	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+)
+
+// tag::branch_builds[]
+// A BranchBuildRule builds every commit that is pushed to a branch that
+// matches one of the rule's patterns, for example to build the protected
+// branches after pull requests were merged into them.
+type BranchBuildRule struct {
+	// Patterns of the branch (e.g. main or release/*, see path.Match)
+	Branches []string `yaml:"branches"`
+	// The options of the build (e.g. "preset=full")
+	Command BuildOptions `yaml:"command"`
+}
+
+// end::branch_builds[]
+
+// validate returns an error if the rule has no or invalid patterns or if its
+// command is invalid or wouldn't build anything.
+func (r BranchBuildRule) validate(c RepoConfig) error {
+	if len(r.Branches) == 0 {
+		return fmt.Errorf("the rule has no branches")
+	}
+	for _, pattern := range r.Branches {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return c.validateBuildCommand(r.Command.commandLine())
+}
+
+// branchHeadsPrefix starts the refs of branches in push events.
+const branchHeadsPrefix = "refs/heads/"
+
+// OnPushEventBranchBuild starts the builds of the repository's BranchBuilds
+// rules that match the branch that was pushed to. Only branches are built,
+// pushes of tags and deleted branches are ignored.
+//
+// Whoever may push to a branch may also build it, so the authorization
+// policy, approvals and rate limits don't apply.
+func OnPushEventBranchBuild(srv Server) githubevents.PushEventHandleFunc {
+	return func(deliveryID string, eventName string, event *github.PushEvent) error {
+		if event == nil || event.GetDeleted() || !strings.HasPrefix(event.GetRef(), branchHeadsPrefix) {
+			return nil
+		}
+		appInstallationID := event.GetInstallation().GetID()
+		repoOwner := event.GetRepo().GetOwner().GetLogin()
+		repoName := event.GetRepo().GetName()
+		branch := strings.TrimPrefix(event.GetRef(), branchHeadsPrefix)
+
+		// Nobody would see an error on a pull request, so it is only logged.
+		config, err := srv.GetRepoConfig(appInstallationID, repoOwner, repoName)
+		var configErr *RepoConfigError
+		if errors.As(err, &configErr) {
+			log.Printf("no branch builds for %s/%s@%s: %v", repoOwner, repoName, branch, configErr)
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to get repository configuration: %w", err)
+		}
+		commandLines := []command.CommandLine{}
+		seen := map[BuildOptions]bool{}
+		for i, rule := range config.BranchBuilds {
+			if !matchesAny(rule.Branches, branch, path.Match) || seen[rule.Command] {
+				continue
+			}
+			seen[rule.Command] = true
+			commandLines = append(commandLines, command.CommandLine{Number: i + 1, Text: string(rule.Command)})
+		}
+		if len(commandLines) == 0 {
+			return nil
+		}
+
+		gh, err := srv.NewGithubClient(appInstallationID)
+		if err != nil {
+			return fmt.Errorf("error creating github client: %w", err)
+		}
+		target := pushBuildTarget(event, branch)
+		errs := []string{}
+		for _, commandLine := range commandLines {
+			cmd, err := configuredBuildCommand(srv, config, BuildOptions(commandLine.Text))
+			if err == nil {
+				cmd.CommentAuthor = event.GetSender().GetLogin()
+				cmd.Trigger = command.TriggerPush
+				log.Printf("building %s of %s/%s@%s", event.GetAfter(), repoOwner, repoName, branch)
+				err = runBuild(srv, gh, appInstallationID, target, cmd, "")
+			}
+			if err != nil {
+				log.Printf("failed to run branch build of rule %d: %v", commandLine.Number, err)
+				errs = append(errs, fmt.Sprintf("rule %d: %v", commandLine.Number, err))
+			}
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("failed to run %d of %d branch build(s): %s", len(errs), len(commandLines), strings.Join(errs, "; "))
+		}
+		return nil
+	}
+}
+
+// pushBuildTarget returns the target of a build of the commit that was pushed
+// to the branch. The base is what the branch pointed to before the push.
+func pushBuildTarget(event *github.PushEvent, branch string) *BuildTarget {
+	return &BuildTarget{
+		BaseRepoName:  event.GetRepo().GetName(),
+		BaseRepoOwner: event.GetRepo().GetOwner().GetLogin(),
+		BaseRef:       branch,
+		BaseSHA:       event.GetBefore(),
+		HeadRef:       branch,
+		HeadSHA:       event.GetAfter(),
+	}
+}
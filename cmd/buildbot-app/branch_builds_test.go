@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnPushEventBranchBuild(t *testing.T) {
+	pushEventOK := func(ref string) *github.PushEvent {
+		return &github.PushEvent{
+			Ref:          github.String(ref),
+			Before:       github.String("1ea3fd4f1b3f2b58a7a2f2c2a25d1ecf0c8ff40b"),
+			After:        github.String("ec26c3e57ca3a959ca5aad62de7213c562f8c821"),
+			Installation: &github.Installation{ID: github.Int64(1234)},
+			Repo: &github.PushEventRepository{
+				Owner: &github.User{Login: github.String("janedoe")},
+				Name:  github.String("examplerepo"),
+			},
+			Sender: &github.User{Login: github.String("johndoe")},
+		}
+	}
+	deleted := pushEventOK("refs/heads/main")
+	deleted.Deleted = github.Bool(true)
+	config := &RepoConfig{
+		DefaultBuilders: []string{"linux"},
+		BranchBuilds: []BranchBuildRule{
+			{Branches: []string{"main", "release/*"}},
+			{Branches: []string{"release/*"}, Command: "builder=docs"},
+			{Branches: []string{"main"}},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		event         *github.PushEvent
+		wantCheckRuns []string
+	}{
+		{"main", pushEventOK("refs/heads/main"), []string{"[push] /buildbot mandatory=true force=false builder=[linux]"}},
+		{"release branch", pushEventOK("refs/heads/release/17.x"), []string{
+			"[push] /buildbot mandatory=true force=false builder=[linux]",
+			"[push] /buildbot mandatory=true force=false builder=[docs]",
+		}},
+		{"other branch", pushEventOK("refs/heads/feature"), []string{}},
+		{"tag", pushEventOK("refs/tags/main"), []string{}},
+		{"deleted branch", deleted, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkRunNames := []string{}
+			comments := []string{}
+			srv := NewMockServer(
+				mock.WithRequestMatch(
+					mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+					github.ListCheckRunsResults{Total: github.Int(0)},
+					github.ListCheckRunsResults{Total: github.Int(0)},
+				),
+				recordComments(t, &comments),
+				mock.WithRequestMatchHandler(
+					mock.PostReposCheckRunsByOwnerByRepo,
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						opts := github.CreateCheckRunOptions{}
+						require.NoError(t, json.NewDecoder(r.Body).Decode(&opts))
+						require.Equal(t, "ec26c3e57ca3a959ca5aad62de7213c562f8c821", opts.HeadSHA)
+						checkRunNames = append(checkRunNames, opts.Name)
+						w.Write(mock.MustMarshal(github.CheckRun{ID: github.Int64(int64(len(checkRunNames)))}))
+					}),
+				),
+			)
+			srv.repoConfig = config
+			err := OnPushEventBranchBuild(srv)("1234", "push", tt.event)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantCheckRuns, checkRunNames)
+			require.Len(t, *srv.tryBotRuns, len(tt.wantCheckRuns))
+			for _, run := range *srv.tryBotRuns {
+				require.Contains(t, run, "--property=command_trigger=push")
+				require.Contains(t, run, "--property=github_pull_request_number=0")
+				require.Contains(t, run, "--property=github_pull_request_base_sha=1ea3fd4f1b3f2b58a7a2f2c2a25d1ecf0c8ff40b")
+				require.Contains(t, run, "--property=github_pull_request_head_ref="+tt.event.GetRef()[len(branchHeadsPrefix):])
+			}
+			// There is no pull request to comment on
+			require.Empty(t, comments)
+			// Nor are builds without a pull request tracked for cancellation
+			require.Empty(t, srv.prBuilds.TakeIf("janedoe", "examplerepo", 0, func(run inFlightCheckRun) bool { return true }))
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+)
+
+const (
+	// buildMatrixBeginMarker starts the matrix section of a build log comment.
+	// It is followed by the JSON encoded BuildMatrix and "-->".
+	buildMatrixBeginMarker = "<!-- buildbot-matrix: "
+	// buildMatrixEndMarker ends the matrix section of a build log comment.
+	buildMatrixEndMarker = "<!-- /buildbot-matrix -->"
+
+	// buildMatrixColumnAttribute is the attribute whose values are the
+	// columns of the matrix. All other attributes make up the rows.
+	buildMatrixColumnAttribute = command.CommandOptionArch
+)
+
+// tag::build_matrix[]
+// BuildMatrix is the table that we show in the build log comment of a command
+// like "/buildbot os=linux,macos arch=x86_64,aarch64". There is one cell per
+// combination of attribute values and each cell is updated as Buildbot
+// reports the state of the build for that combination. The matrix itself is
+// stored as JSON in an HTML comment next to the table so that we can read it
+// back from the build log comment.
+type BuildMatrix struct {
+	// Attributes that span the matrix (e.g. os and arch)
+	Attributes []string `json:"attributes"`
+	// Row labels (e.g. "os=linux")
+	Rows []string `json:"rows"`
+	// Column labels (e.g. "arch=x86_64") or a single empty label
+	Columns []string `json:"columns"`
+	// Cells maps a cell key (see cellKey) to the text that we show in it
+	Cells map[string]string `json:"cells"`
+}
+
+// end::build_matrix[]
+
+// NewBuildMatrix returns a matrix with one cell for each of the given
+// commands (see command.Command.Matrix()). Every cell shows the given text.
+func NewBuildMatrix(cells []*command.Command, text string) *BuildMatrix {
+	m := &BuildMatrix{
+		Attributes: []string{},
+		Rows:       []string{},
+		Columns:    []string{},
+		Cells:      map[string]string{},
+	}
+	for _, cell := range cells {
+		for attr := range cell.Selectors() {
+			if !containsString(m.Attributes, attr) {
+				m.Attributes = append(m.Attributes, attr)
+			}
+		}
+	}
+	sort.Strings(m.Attributes)
+	for _, cell := range cells {
+		row, column := m.position(cell.Selectors())
+		if !containsString(m.Rows, row) {
+			m.Rows = append(m.Rows, row)
+		}
+		if !containsString(m.Columns, column) {
+			m.Columns = append(m.Columns, column)
+		}
+		m.Cells[cellKey(row, column)] = text
+	}
+	return m
+}
+
+// position returns the row and column label of the cell for the given
+// attribute values.
+func (m BuildMatrix) position(selectors map[string]string) (string, string) {
+	row := []string{}
+	column := ""
+	for _, attr := range command.SelectorAttributes {
+		if !containsString(m.Attributes, attr) {
+			continue
+		}
+		label := fmt.Sprintf("%s=%s", attr, selectors[attr])
+		if attr == buildMatrixColumnAttribute {
+			column = label
+			continue
+		}
+		row = append(row, label)
+	}
+	return strings.Join(row, " "), column
+}
+
+// cellKey returns the key of a cell in BuildMatrix.Cells.
+func cellKey(row string, column string) string {
+	return row + "|" + column
+}
+
+// Set changes the text of the cell for the given attribute values. It returns
+// false if there is no such cell.
+func (m *BuildMatrix) Set(selectors map[string]string, text string) bool {
+	key := cellKey(m.position(selectors))
+	if _, ok := m.Cells[key]; !ok {
+		return false
+	}
+	m.Cells[key] = text
+	return true
+}
+
+// Markdown returns the matrix as a markdown table including the markers and
+// JSON that are needed to read it back with BuildMatrixFromComment().
+func (m BuildMatrix) Markdown() (string, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal build matrix: %w", err)
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s%s -->\n\n", buildMatrixBeginMarker, data)
+	sb.WriteString("| |")
+	for _, column := range m.Columns {
+		if column == "" {
+			column = "Build"
+		}
+		fmt.Fprintf(&sb, " `%s` |", column)
+	}
+	sb.WriteString("\n|---|")
+	sb.WriteString(strings.Repeat("---|", len(m.Columns)))
+	sb.WriteString("\n")
+	for _, row := range m.Rows {
+		fmt.Fprintf(&sb, "| `%s` |", row)
+		for _, column := range m.Columns {
+			text, ok := m.Cells[cellKey(row, column)]
+			if !ok {
+				text = "-"
+			}
+			fmt.Fprintf(&sb, " %s |", text)
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("\n" + buildMatrixEndMarker)
+	return sb.String(), nil
+}
+
+// BuildMatrixFromComment reads the matrix from a build log comment. If the
+// comment has no matrix, nil is returned.
+func BuildMatrixFromComment(body string) (*BuildMatrix, error) {
+	_, after, found := strings.Cut(body, buildMatrixBeginMarker)
+	if !found {
+		return nil, nil
+	}
+	data, _, found := strings.Cut(after, " -->")
+	if !found {
+		return nil, fmt.Errorf("build matrix in comment is not terminated")
+	}
+	m := &BuildMatrix{}
+	if err := json.Unmarshal([]byte(data), m); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal build matrix from comment: %w", err)
+	}
+	return m, nil
+}
+
+// ReplaceIn replaces the matrix section of the given build log comment with
+// the current state of the matrix.
+func (m BuildMatrix) ReplaceIn(body string) (string, error) {
+	begin := strings.Index(body, buildMatrixBeginMarker)
+	end := strings.Index(body, buildMatrixEndMarker)
+	if begin < 0 || end < begin {
+		return "", fmt.Errorf("build log comment has no build matrix")
+	}
+	markdown, err := m.Markdown()
+	if err != nil {
+		return "", err
+	}
+	return body[:begin] + markdown + body[end+len(buildMatrixEndMarker):], nil
+}
+
+// UpdateBuildMatrixInComment sets the text of the cell for the given
+// attribute values in the build matrix of a build log comment and returns the
+// new comment body. A comment without a build matrix is returned unchanged.
+func UpdateBuildMatrixInComment(body string, selectors map[string]string, text string) (string, error) {
+	matrix, err := BuildMatrixFromComment(body)
+	if err != nil || matrix == nil {
+		return body, err
+	}
+	if !matrix.Set(selectors, text) {
+		return body, nil
+	}
+	return matrix.ReplaceIn(body)
+}
+
+// containsString returns true if s is an element of list.
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
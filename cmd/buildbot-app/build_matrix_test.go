@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildMatrix(t *testing.T) {
+	cmd, err := command.FromString("/buildbot os=linux,macos arch=x86_64,aarch64")
+	require.NoError(t, err)
+	matrix := NewBuildMatrix(cmd.Matrix(), "requested")
+	require.Equal(t, []string{"arch", "os"}, matrix.Attributes)
+	require.Equal(t, []string{"os=linux", "os=macos"}, matrix.Rows)
+	require.Equal(t, []string{"arch=x86_64", "arch=aarch64"}, matrix.Columns)
+
+	table, err := matrix.Markdown()
+	require.NoError(t, err)
+	require.Contains(t, table, "| | `arch=x86_64` | `arch=aarch64` |\n|---|---|---|\n")
+	require.Contains(t, table, "| `os=linux` | requested | requested |\n")
+	require.Contains(t, table, "| `os=macos` | requested | requested |\n")
+
+	t.Run("update cell in comment", func(t *testing.T) {
+		body := "Thank you!\n\n" + table + "\n<br/>some log line"
+		body, err := UpdateBuildMatrixInComment(body, map[string]string{"os": "macos", "arch": "aarch64"}, "[build successful](http://buildbot/1)")
+		require.NoError(t, err)
+		require.Contains(t, body, "| `os=macos` | requested | [build successful](http://buildbot/1) |\n")
+		require.Contains(t, body, "| `os=linux` | requested | requested |\n")
+		require.Contains(t, body, "Thank you!\n\n")
+		require.Contains(t, body, "\n<br/>some log line")
+
+		// The updated matrix can be read back
+		matrix, err := BuildMatrixFromComment(body)
+		require.NoError(t, err)
+		require.Equal(t, "[build successful](http://buildbot/1)", matrix.Cells[cellKey("os=macos", "arch=aarch64")])
+	})
+	t.Run("unknown cell", func(t *testing.T) {
+		body, err := UpdateBuildMatrixInComment(table, map[string]string{"os": "windows", "arch": "aarch64"}, "foo")
+		require.NoError(t, err)
+		require.Equal(t, table, body)
+	})
+	t.Run("comment without matrix", func(t *testing.T) {
+		body, err := UpdateBuildMatrixInComment("no matrix here", map[string]string{"os": "linux"}, "foo")
+		require.NoError(t, err)
+		require.Equal(t, "no matrix here", body)
+	})
+	t.Run("without column attribute", func(t *testing.T) {
+		cmd, err := command.FromString("/buildbot os=linux,macos")
+		require.NoError(t, err)
+		table, err := NewBuildMatrix(cmd.Matrix(), "requested").Markdown()
+		require.NoError(t, err)
+		require.Contains(t, table, "| | `Build` |\n|---|---|\n| `os=linux` | requested |\n| `os=macos` | requested |\n")
+	})
+}
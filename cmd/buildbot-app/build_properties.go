@@ -0,0 +1,29 @@
+package main
+
+import "fmt"
+
+// BuildProperties are the try-bot properties that tie a Buildbot build to the
+// GitHub objects that the app created for it.
+type BuildProperties struct {
+	CheckRunID int64 `json:"github_check_run_id" binding:"required"`
+	// TODO(kwk): AppInstallationID is really just a hack to be able to create
+	// a github client for the specific application installation.
+	AppInstallationID int64 `json:"github_app_installation_id" binding:"required"`
+	BuildLogCommentID int64 `json:"github_build_log_comment_id" binding:"required"`
+}
+
+// ToTryBotPropertyArray returns a string list that you can pass as properties
+// to AppServer's RunTryBot(). We use the json field tag to name the properties.
+func (p BuildProperties) ToTryBotPropertyArray() []string {
+	return structToTryBotPropertyArray(p)
+}
+
+// BuildPropertiesFromProperties creates BuildProperties from the build
+// properties that Buildbot reports back.
+func BuildPropertiesFromProperties(properties map[string]string) (*BuildProperties, error) {
+	p := &BuildProperties{}
+	if err := structFromProperties(properties, p); err != nil {
+		return nil, fmt.Errorf("failed to read build properties: %w", err)
+	}
+	return p, nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+)
+
+// BuildRequest bundles everything that is needed to forward a build command
+// for a build target (e.g. a pull request) to Buildbot.
+type BuildRequest struct {
+	Server            Server
+	Github            *github.Client
+	AppInstallationID int64
+	Target            *BuildTarget
+	Command           *command.Command
+	// ThankYouComment is put in front of every comment that we write in
+	// response to this request.
+	ThankYouComment string
+	// BuildLogCommentID is the ID of an existing build log comment that the
+	// build shall log to. If zero, a new build log comment is created.
+	BuildLogCommentID int64
+	// Reporter shows the build on GitHub. If nil, the repository
+	// configuration chooses it (see newReporter).
+	Reporter Reporter
+}
+
+// tag::build_log_comment[]
+// buildLogCommentIntro explains what the build log comment is for.
+const buildLogCommentIntro = `<sub>This very comment will be used to continously log build state changes for your request. We decided to do this in addition to using Github's Check Runs below so you can inspect previous check runs better.</sub>`
+
+// end::build_log_comment[]
+
+// Run creates a build log comment for the request, reports the build (e.g.
+// with a check run) and then runs "buildbot try". If the same build was
+// already requested for the target's head SHA and the command doesn't force a
+// new build, a comment is written instead and nothing is built.
+func (r BuildRequest) Run() error {
+	gh := r.Github
+	target := r.Target
+	cmd := r.Command
+	repoOwner := target.BaseRepoOwner
+	repoName := target.BaseRepoName
+
+	config, err := r.Server.GetRepoConfig(r.AppInstallationID, repoOwner, repoName)
+	if err != nil {
+		return fmt.Errorf("failed to get repository configuration: %w", err)
+	}
+	if r.Reporter == nil {
+		r.Reporter, err = newReporter(r.Server, r.AppInstallationID, config)
+		if err != nil {
+			return err
+		}
+	}
+	if !r.Reporter.TracksBuilds() {
+		log.Printf("builds of %s/%s aren't tracked: %q isn't checked for duplicates and can't be cancelled, retried or timed out", repoOwner, repoName, cmd.ToGithubCheckNameString())
+	}
+
+	// If there already is a check run for the same HEAD and the force
+	// option is no, then say: Sorry, no can't do.
+	// ----
+	existingCheckRun, err := r.findExistingCheckRun()
+	if err != nil {
+		return err
+	}
+
+	if cmd.DryRun {
+		return r.replyWithDryRun(existingCheckRun)
+	}
+
+	// The requested check has already been run for the given PR. Let's see if
+	// a build is forced this time.
+	if existingCheckRun != nil && !cmd.Force && !target.IsPullRequest() {
+		log.Printf("not building %q again for %s: %s", existingCheckRun.GetName(), target.HeadSHA, existingCheckRun.GetHTMLURL())
+		return nil
+	}
+	if existingCheckRun != nil && !cmd.Force {
+		msg := fmt.Sprintf(r.ThankYouComment+`
+The same build request exists for this pull request's SHA (%s) <a href="%s">here</a>.
+Consider specifying the <code>%s=true</code> option to enforce a new build.
+			`, target.HeadSHA, *existingCheckRun.HTMLURL, command.CommandOptionForce)
+		_, _, err := gh.Issues.CreateComment(context.Background(), repoOwner, repoName, target.Number, &github.IssueComment{
+			Body: github.String(msg),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create comment: %w", err)
+		}
+		// We return here because the force option was false
+		return nil
+	}
+
+	// ----
+
+	buildLogCommentID := r.BuildLogCommentID
+	// Builds that aren't for a pull request (e.g. of a merge group) have
+	// nowhere to write a comment.
+	if buildLogCommentID == 0 && target.IsPullRequest() && config.writesBuildLogComment(cmd) {
+		// tag::build_log_comment[]
+		newComment, _, err := gh.Issues.CreateComment(context.Background(), repoOwner, repoName, target.Number, &github.IssueComment{
+			Body: github.String(r.ThankYouComment + buildLogIntro(r.Reporter)),
+		})
+		// end::build_log_comment[]
+		if err != nil {
+			return fmt.Errorf("failed to create build-log comment: %w", err)
+		}
+		if newComment != nil {
+			buildLogCommentID = newComment.GetID()
+		}
+	}
+
+	// IDEA: We could set up one try-builder for all jobs and have that
+	// try-builder trigger other jobs depending on the given properties. The
+	// try builder would need to have a Trigger build step.
+	// (http://docs.buildbot.net/current/manual/configuration/steps/trigger.html)
+	//
+	// Or we could have one try-builder fast workers and one try-builder for
+	// slowers non-mandatory workers.
+
+	//---------------------------------------------------------------------
+	// Lets report the try bot run (e.g. with a check run)
+	// NOTE: It is important to first create the check so that we can pass
+	//       its ID to buildbot. This way whenever buildbot tells us
+	//       anything about a build we know how to reflect this in the
+	//       check run on github. Commit statuses only need the builder.
+	//---------------------------------------------------------------------
+	checkRunID, err := r.Reporter.BuildQueued(r, buildLogCommentID)
+	if err != nil {
+		return err
+	}
+
+	// To simulate latency
+	// log.Printf("Sleep for 10 seconds before sending request to buildbot")
+	// time.Sleep(10 * time.Second)
+
+	// Make a buildbot try call with an empty diff (!!!)
+	props := r.tryBotProperties(checkRunID, buildLogCommentID)
+	combinedOutput, err := r.Server.RunTryBot(cmd.CommentAuthor, repoOwner, repoName, props...)
+	if err != nil {
+		return fmt.Errorf("failed to run trybot: %s: %w", combinedOutput, err)
+	}
+	log.Printf("trybot command executed: %s", combinedOutput)
+
+	// The build is on its way, which the reaction on the comment shows. The
+	// build itself doesn't depend on it, so we only log a failure.
+	if config.reactsOn(cmd) {
+		if err := setCommentReaction(gh, repoOwner, repoName, cmd.CommentID, ReactionAccepted); err != nil {
+			log.Println(err)
+		}
+	}
+
+	// Only builds with a check run can be cancelled when they time out.
+	if cmd.Timeout > 0 && checkRunID != 0 {
+		r.Server.WatchBuildTimeout(r.AppInstallationID, repoOwner, repoName, checkRunID, cmd.Timeout)
+	}
+
+	return nil
+}
+
+// buildLogIntro returns the start of a new build log comment. Builds of a
+// Reporter that doesn't track them also get a note about what that means.
+func buildLogIntro(reporter Reporter) string {
+	if reporter.TracksBuilds() {
+		return buildLogCommentIntro
+	}
+	return buildLogCommentIntro + "\n\n" + untrackedBuildsNote
+}
+
+// appendToBuildLogComment adds a line about the check run with the given name
+// to a build log comment.
+func appendToBuildLogComment(gh *github.Client, repoOwner string, repoName string, buildLogCommentID int64, checkRunName string, msg string) error {
+	buildLogComment, _, err := gh.Issues.GetComment(context.Background(), repoOwner, repoName, buildLogCommentID)
+	if err != nil {
+		return fmt.Errorf("failed to get build log comment: %w", err)
+	}
+	body := fmt.Sprintf(`%s<br/><strong>%s</strong> <i>[%s]</i> %s`, buildLogComment.GetBody(), time.Now().Format(time.RFC1123Z), checkRunName, msg)
+	_, _, err = gh.Issues.EditComment(context.Background(), repoOwner, repoName, buildLogCommentID, &github.IssueComment{
+		Body: github.String(body),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to edit build log comment: %w", err)
+	}
+	return nil
+}
+
+// checkRunSummary returns the initial summary of the request's check run.
+func (r BuildRequest) checkRunSummary() string {
+	summary := WrapMsgWithTimePrefix("We're about to forward your request to buildbot.", time.Now())
+	if r.Command.Timeout > 0 {
+		summary += fmt.Sprintf("\n\nThe build will be cancelled if it hasn't completed after %s.", r.Command.Timeout)
+	}
+	if properties := customPropertiesSummary(r.Command.Properties); properties != "" {
+		summary += "\n\n" + properties
+	}
+	return summary
+}
+
+// findExistingCheckRun returns the check run for the target's head SHA
+// that has the same name as the command's check run or nil if there is none.
+// Check runs that require action (e.g. because the build was not authorized)
+// and check runs of builds that needed approval don't count.
+func (r BuildRequest) findExistingCheckRun() (*github.CheckRun, error) {
+	checkRuns, err := r.Reporter.ExistingBuilds(r.Github, r.AppInstallationID, r.Target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all check runs for build target: %w", err)
+	}
+	currentCheckRunName := r.Command.ToGithubCheckNameString()
+	for _, checkRun := range checkRuns {
+		if checkRun.GetName() != currentCheckRunName || checkRun.GetConclusion() == string(CheckRunConclusionActionRequired) {
+			continue
+		}
+		if meta, err := CheckRunMetaFromCheckRun(checkRun); err == nil && meta.Approval != nil {
+			continue
+		}
+		return checkRun, nil
+	}
+	return nil, nil
+}
+
+// tryBotProperties returns the properties that are passed to "buildbot try"
+// for the given check run and build log comment.
+func (r BuildRequest) tryBotProperties(checkRunID int64, buildLogCommentID int64) []string {
+	props := r.Target.ToTryBotPropertyArray()
+	props = append(props, BuildProperties{
+		CheckRunID:        checkRunID,
+		AppInstallationID: r.AppInstallationID,
+		BuildLogCommentID: buildLogCommentID,
+	}.ToTryBotPropertyArray()...)
+	return append(props, r.Command.ToTryBotPropertyArray()...)
+}
+
+// replyWithDryRun writes a comment that explains what the request would do
+// without building anything or creating a check run.
+func (r BuildRequest) replyWithDryRun(existingCheckRun *github.CheckRun) error {
+	target := r.Target
+	_, _, err := r.Github.Issues.CreateComment(context.Background(), target.BaseRepoOwner, target.BaseRepoName, target.Number, &github.IssueComment{
+		Body: github.String(r.ThankYouComment + "This is a dry run, so nothing was built and no check run was created.\n\n" + r.dryRunPreview(existingCheckRun)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write dry-run comment: %w", err)
+	}
+	return nil
+}
+
+// tag::dry_run[]
+// dryRunPreview describes the check run that the request would create, the
+// builders it would run on, whether an existing check run would block it and
+// the exact properties that would be sent to Buildbot. The IDs of the check
+// run and the build log comment are only known for real builds, so they are 0.
+func (r BuildRequest) dryRunPreview(existingCheckRun *github.CheckRun) string {
+	cmd := r.Command
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "**Check run:** <code>%s</code>\n\n", cmd.ToGithubCheckNameString())
+	if len(cmd.BuilderNames) == 0 {
+		sb.WriteString("**Builders:** _none_\n\n")
+	} else {
+		fmt.Fprintf(&sb, "**Builders:** `%s`\n\n", strings.Join(cmd.BuilderNames, "`, `"))
+	}
+	headSHA := r.Target.HeadSHA
+	switch {
+	case existingCheckRun == nil:
+		fmt.Fprintf(&sb, "**Duplicate:** No check run for this pull request's SHA (%s) blocks this build.\n\n", headSHA)
+	case cmd.Force:
+		fmt.Fprintf(&sb, "**Duplicate:** The same build exists for this pull request's SHA (%s) <a href=\"%s\">here</a> but it would be built again because of <code>%s=true</code>.\n\n", headSHA, existingCheckRun.GetHTMLURL(), command.CommandOptionForce)
+	default:
+		fmt.Fprintf(&sb, "**Duplicate:** The same build exists for this pull request's SHA (%s) <a href=\"%s\">here</a> and blocks this build. Specify <code>%s=true</code> to enforce a new build.\n\n", headSHA, existingCheckRun.GetHTMLURL(), command.CommandOptionForce)
+	}
+	fmt.Fprintf(&sb, "**Properties:**\n\n```\n%s\n```\n", strings.Join(r.tryBotProperties(0, 0), "\n"))
+	return sb.String()
+}
+
+// end::dry_run[]
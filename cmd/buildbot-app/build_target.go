@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/google/go-github/v50/github"
+)
+
+// BuildTarget contains all the information we need to identify what is built.
+// This is also what we sent to Buildbot to identify it. Most builds are for a
+// pull request but builds of merge groups and of pushes to a branch have no
+// pull request. Their Number is 0 and HeadRef and HeadSHA are the branch and
+// revision that are built. The property names stay the same for all builds so
+// that Buildbot doesn't need to tell them apart.
+type BuildTarget struct {
+	Number        int    `json:"github_pull_request_number" binding:"required"`
+	BaseRepoName  string `json:"github_pull_request_repo_name" binding:"required"`
+	BaseRepoOwner string `json:"github_pull_request_repo_owner" binding:"required"`
+	BaseRef       string `json:"github_pull_request_base_ref" binding:"required"`
+	BaseSHA       string `json:"github_pull_request_base_sha" binding:"required"`
+	HeadRef       string `json:"github_pull_request_head_ref" binding:"required"`
+	HeadSHA       string `json:"github_pull_request_head_sha" binding:"required"`
+	// GithubTriggerCommentHTMLURL string `json:"github_trigger_comment_html_url"`
+}
+
+// IsPullRequest returns true if the target is a pull request that comments
+// can be written to.
+func (t *BuildTarget) IsPullRequest() bool {
+	return t.Number != 0
+}
+
+// ToTryBotPropertyArray returns a string list that you can pass as properties
+// to AppServer's RunTryBot(). We use the json field tag to name the properties.
+func (t *BuildTarget) ToTryBotPropertyArray() []string {
+	return structToTryBotPropertyArray(*t)
+}
+
+// BuildTargetFromProperties creates a BuildTarget from the build properties
+// that Buildbot reports back for a build that was started with
+// ToTryBotPropertyArray().
+func BuildTargetFromProperties(properties map[string]string) (*BuildTarget, error) {
+	t := &BuildTarget{}
+	if err := structFromProperties(properties, t); err != nil {
+		return nil, fmt.Errorf("failed to read build target from properties: %w", err)
+	}
+	return t, nil
+}
+
+// structToTryBotPropertyArray returns a "--property=name=value" argument for
+// every field of the given struct. The json field tag is used as the property
+// name.
+func structToTryBotPropertyArray(s interface{}) []string {
+	v := reflect.ValueOf(s)
+	typeOfS := v.Type()
+
+	numFields := v.NumField()
+	properties := make([]string, numFields)
+	for i := 0; i < numFields; i++ {
+		properties[i] = fmt.Sprintf("--property=%s=%v", typeOfS.Field(i).Tag.Get("json"), v.Field(i).Interface())
+	}
+	return properties
+}
+
+// structFromProperties is the reverse of structToTryBotPropertyArray. It sets
+// every field of the struct that ptr points to from the property named after
+// the field's json tag. Only string and integer fields are supported and all
+// properties must be present.
+func structFromProperties(properties map[string]string, ptr interface{}) error {
+	v := reflect.ValueOf(ptr).Elem()
+	typeOfS := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		name := typeOfS.Field(i).Tag.Get("json")
+		value, ok := properties[name]
+		if !ok {
+			return fmt.Errorf("missing property %s", name)
+		}
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Int, reflect.Int64:
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("failed to parse property %s as integer: %w", name, err)
+			}
+			field.SetInt(n)
+		default:
+			return fmt.Errorf("unsupported type %s of property %s", field.Kind(), name)
+		}
+	}
+	return nil
+}
+
+// NewBuildTarget returns the target of a build of the given pull request.
+func NewBuildTarget(pr *github.PullRequest) *BuildTarget {
+	if pr == nil {
+		return nil
+	}
+	return &BuildTarget{
+		Number:        pr.GetNumber(),
+		BaseRepoName:  pr.GetBase().GetRepo().GetName(),
+		BaseRepoOwner: pr.GetBase().GetRepo().GetOwner().GetLogin(),
+		BaseRef:       pr.GetBase().GetRef(),
+		BaseSHA:       pr.GetBase().GetSHA(),
+		HeadRef:       pr.GetHead().GetRef(),
+		HeadSHA:       pr.GetHead().GetSHA(),
+	}
+}
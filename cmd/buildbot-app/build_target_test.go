@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/buildbot_http_status_push"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildTarget_ToTryBotPropertyArray(t *testing.T) {
+	type fields struct {
+		Number        int
+		BaseRepoName  string
+		BaseRepoOwner string
+		BaseRef       string
+		BaseSHA       string
+		HeadRef       string
+		HeadSHA       string
+		// GithubTriggerCommentHTMLURL    string
+	}
+	tests := []struct {
+		name   string
+		fields fields
+		want   []string
+	}{
+		{
+			name: "ok_all_fields_set",
+			fields: fields{
+				3,
+				"base.name",
+				"base.owner",
+				"base.ref",
+				"base.sha",
+				"head.ref",
+				"head.sha",
+				// "",
+			},
+			want: []string{
+				"--property=github_pull_request_number=3",
+				"--property=github_pull_request_repo_name=base.name",
+				"--property=github_pull_request_repo_owner=base.owner",
+				"--property=github_pull_request_base_ref=base.ref",
+				"--property=github_pull_request_base_sha=base.sha",
+				"--property=github_pull_request_head_ref=head.ref",
+				"--property=github_pull_request_head_sha=head.sha",
+				// "--property=github_trigger_comment_html_url=",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := &BuildTarget{
+				Number:        tt.fields.Number,
+				BaseRepoName:  tt.fields.BaseRepoName,
+				BaseRepoOwner: tt.fields.BaseRepoOwner,
+				BaseRef:       tt.fields.BaseRef,
+				BaseSHA:       tt.fields.BaseSHA,
+				HeadRef:       tt.fields.HeadRef,
+				HeadSHA:       tt.fields.HeadSHA,
+			}
+			if got := pr.ToTryBotPropertyArray(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("BuildTarget.ToTryBotPropertyArray() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildTargetFromProperties(t *testing.T) {
+	want := &BuildTarget{
+		Number:        3,
+		BaseRepoName:  "base.name",
+		BaseRepoOwner: "base.owner",
+		BaseRef:       "base.ref",
+		BaseSHA:       "base.sha",
+		HeadRef:       "head.ref",
+		HeadSHA:       "head.sha",
+	}
+	propertiesOK := func() map[string]string {
+		return map[string]string{
+			"github_pull_request_number":     "3",
+			"github_pull_request_repo_name":  "base.name",
+			"github_pull_request_repo_owner": "base.owner",
+			"github_pull_request_base_ref":   "base.ref",
+			"github_pull_request_base_sha":   "base.sha",
+			"github_pull_request_head_ref":   "head.ref",
+			"github_pull_request_head_sha":   "head.sha",
+		}
+	}
+	t.Run("ok", func(t *testing.T) {
+		got, err := BuildTargetFromProperties(propertiesOK())
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	})
+	t.Run("missing property", func(t *testing.T) {
+		properties := propertiesOK()
+		delete(properties, "github_pull_request_head_sha")
+		_, err := BuildTargetFromProperties(properties)
+		require.ErrorContains(t, err, "missing property github_pull_request_head_sha")
+	})
+	t.Run("invalid number", func(t *testing.T) {
+		properties := propertiesOK()
+		properties["github_pull_request_number"] = "three"
+		_, err := BuildTargetFromProperties(properties)
+		require.ErrorContains(t, err, "failed to parse property github_pull_request_number")
+	})
+}
+
+func TestBuildPropertiesFromProperties(t *testing.T) {
+	want := &BuildProperties{
+		CheckRunID:        1,
+		AppInstallationID: 2,
+		BuildLogCommentID: 3,
+	}
+	got, err := BuildPropertiesFromProperties(map[string]string{
+		"github_check_run_id":         "1",
+		"github_app_installation_id":  "2",
+		"github_build_log_comment_id": "3",
+	})
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+}
+
+// TestStatusPushProperties decodes properties the way Buildbot reports them
+// and makes sure that all objects that were sent can be rebuilt from them.
+func TestStatusPushProperties(t *testing.T) {
+	pr := BuildTarget{Number: 3, BaseRepoName: "examplerepo", BaseRepoOwner: "janedoe", BaseRef: "main", BaseSHA: "abc", HeadRef: "feature", HeadSHA: "def"}
+	buildProperties := BuildProperties{CheckRunID: 1234567890123, AppInstallationID: 2, BuildLogCommentID: 3}
+	cmd, err := command.FromString("/buildbot mandatory=no builder=foo builder=bar")
+	require.NoError(t, err)
+	cmd.CommentAuthor = "johndoe"
+
+	args := append(pr.ToTryBotPropertyArray(), buildProperties.ToTryBotPropertyArray()...)
+	args = append(args, cmd.ToTryBotPropertyArray()...)
+	properties := buildbot_http_status_push.Properties{}
+	for _, arg := range args {
+		name, value, found := strings.Cut(strings.TrimPrefix(arg, "--property="), "=")
+		require.True(t, found, "invalid property argument: %s", arg)
+		properties[name] = buildbot_http_status_push.Property{Value: mustMarshal(t, value), Source: "Try"}
+	}
+	// Buildbot itself reports some properties as numbers
+	properties["github_check_run_id"] = buildbot_http_status_push.Property{Value: json.RawMessage("1234567890123"), Source: "Try"}
+	data, err := json.Marshal(properties)
+	require.NoError(t, err)
+
+	decoded := buildbot_http_status_push.Properties{}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	values := decoded.Strings()
+
+	gotPR, err := BuildTargetFromProperties(values)
+	require.NoError(t, err)
+	require.Equal(t, pr, *gotPR)
+	gotBuildProperties, err := BuildPropertiesFromProperties(values)
+	require.NoError(t, err)
+	require.Equal(t, buildProperties, *gotBuildProperties)
+	gotCmd, err := command.FromProperties(values)
+	require.NoError(t, err)
+	require.Equal(t, cmd, gotCmd)
+}
+
+func mustMarshal(t *testing.T, v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return data
+}
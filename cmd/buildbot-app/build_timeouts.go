@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// buildTimeouts keeps one timer per check run that fires when the check run's
+// build didn't complete in time (see the timeout option). The timer of a check
+// run is stopped when Buildbot reports its build as complete.
+type buildTimeouts struct {
+	mu sync.Mutex
+	// check run ID -> timer
+	timers map[int64]*time.Timer
+}
+
+func newBuildTimeouts() *buildTimeouts {
+	return &buildTimeouts{
+		timers: map[int64]*time.Timer{},
+	}
+}
+
+// Start calls f after the given duration unless Stop is called for the check
+// run before. Starting a timer for a check run replaces its previous timer.
+func (t *buildTimeouts) Start(checkRunID int64, timeout time.Duration, f func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if timer, ok := t.timers[checkRunID]; ok {
+		timer.Stop()
+	}
+	t.timers[checkRunID] = time.AfterFunc(timeout, func() {
+		t.mu.Lock()
+		delete(t.timers, checkRunID)
+		t.mu.Unlock()
+		f()
+	})
+}
+
+// Stop stops the timer of the given check run. It returns false if there was
+// no timer or if it already fired.
+func (t *buildTimeouts) Stop(checkRunID int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timer, ok := t.timers[checkRunID]
+	if !ok {
+		return false
+	}
+	delete(t.timers, checkRunID)
+	return timer.Stop()
+}
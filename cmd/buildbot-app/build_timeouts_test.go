@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildTimeouts(t *testing.T) {
+	timeouts := newBuildTimeouts()
+
+	fired := make(chan int64, 2)
+	timeouts.Start(1, time.Millisecond, func() { fired <- 1 })
+	timeouts.Start(2, time.Hour, func() { fired <- 2 })
+
+	select {
+	case id := <-fired:
+		if id != 1 {
+			t.Errorf("timer of check run %d fired, want 1", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timer of check run 1 didn't fire")
+	}
+	if timeouts.Stop(1) {
+		t.Errorf("buildTimeouts.Stop(1) = true for a timer that already fired")
+	}
+	if !timeouts.Stop(2) {
+		t.Errorf("buildTimeouts.Stop(2) = false, want true")
+	}
+	if timeouts.Stop(3) {
+		t.Errorf("buildTimeouts.Stop(3) = true for an unknown check run")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// buildTracker remembers which Buildbot builds currently run for a check run
+// so that they can be stopped later on. It is fed by the HTTP status pushes
+// that Buildbot sends to the app.
+//
+// NOTE: Builds that haven't been reported by Buildbot yet are not known to the
+// tracker and neither are builds that were started before the app was
+// (re)started.
+type buildTracker struct {
+	mu sync.Mutex
+	// check run ID -> set of Buildbot build IDs
+	builds map[int64]map[int]bool
+}
+
+func newBuildTracker() *buildTracker {
+	return &buildTracker{
+		builds: map[int64]map[int]bool{},
+	}
+}
+
+// Add records a running build for the given check run.
+func (t *buildTracker) Add(checkRunID int64, buildID int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.builds[checkRunID]; !ok {
+		t.builds[checkRunID] = map[int]bool{}
+	}
+	t.builds[checkRunID][buildID] = true
+}
+
+// Remove forgets about a build, usually because it is complete.
+func (t *buildTracker) Remove(checkRunID int64, buildID int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.builds[checkRunID], buildID)
+	if len(t.builds[checkRunID]) == 0 {
+		delete(t.builds, checkRunID)
+	}
+}
+
+// Builds returns the sorted IDs of all running builds of the given check run.
+func (t *buildTracker) Builds(checkRunID int64) []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ids := make([]int, 0, len(t.builds[checkRunID]))
+	for id := range t.builds[checkRunID] {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
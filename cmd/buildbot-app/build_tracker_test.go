@@ -0,0 +1,30 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildTracker(t *testing.T) {
+	tracker := newBuildTracker()
+	tracker.Add(1, 20)
+	tracker.Add(1, 10)
+	tracker.Add(2, 30)
+	tracker.Add(1, 10)
+
+	if got, want := tracker.Builds(1), []int{10, 20}; !reflect.DeepEqual(got, want) {
+		t.Errorf("buildTracker.Builds(1) = %v, want %v", got, want)
+	}
+	tracker.Remove(1, 10)
+	if got, want := tracker.Builds(1), []int{20}; !reflect.DeepEqual(got, want) {
+		t.Errorf("buildTracker.Builds(1) = %v, want %v", got, want)
+	}
+	tracker.Remove(1, 20)
+	tracker.Remove(3, 20)
+	if got, want := tracker.Builds(1), []int{}; !reflect.DeepEqual(got, want) {
+		t.Errorf("buildTracker.Builds(1) = %v, want %v", got, want)
+	}
+	if got, want := tracker.Builds(2), []int{30}; !reflect.DeepEqual(got, want) {
+		t.Errorf("buildTracker.Builds(2) = %v, want %v", got, want)
+	}
+}
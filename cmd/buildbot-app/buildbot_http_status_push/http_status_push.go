@@ -1,5 +1,10 @@
 package buildbot_http_status_push
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 // These data structures have been generated from an example response using:
 // https://mholt.github.io/json-to-go/
 //
@@ -10,37 +15,63 @@ package buildbot_http_status_push
 
 type any interface{}
 
-// TODO(kwk): This can change per request, so implement our own parsing logic
-// that can handle missing properties.
-type Properties struct {
-	GithubPullRequestNumber    []string `json:"github_pull_request_number,omitempty"`
-	GithubPullRequestRepoName  []string `json:"github_pull_request_repo_name,omitempty"`
-	GithubPullRequestRepoOwner []string `json:"github_pull_request_repo_owner,omitempty"`
-	GithubPullRequestBaseRef   []string `json:"github_pull_request_base_ref,omitempty"`
-	GithubPullRequestBaseSha   []string `json:"github_pull_request_base_sha,omitempty"`
-	GithubPullRequestHeadRef   []string `json:"github_pull_request_head_ref,omitempty"`
-	GithubPullRequestHeadSha   []string `json:"github_pull_request_head_sha,omitempty"`
-	GithubCheckRunID           []string `json:"github_check_run_id,omitempty"`
-	GithubAppInstallationID    []string `json:"github_app_installation_id,omitempty"`
-	GithubBuildLogCommentID    []string `json:"github_build_log_comment_id,omitempty"`
-	CommandIsMandatory         bool     `json:"command_is_mandatory,omitempty"`
-	CommandForce               bool     `json:"command_force,omitempty"`
-	CommandBuilderNames        []string `json:"command_builder_names,omitempty"`
-	Scheduler                  []string `json:"scheduler,omitempty"`
-	Buildername                []string `json:"buildername,omitempty"`
-	Os                         []string `json:"os,omitempty"`
-	Arch                       []string `json:"arch,omitempty"`
-	OsDistro                   []string `json:"os-distro,omitempty"`
-	OsVer                      []string `json:"os-ver,omitempty"`
-	Workername                 []string `json:"workername,omitempty"`
-	Buildnumber                []any    `json:"buildnumber,omitempty"`
-	Branch                     []any    `json:"branch,omitempty"`
-	Revision                   []any    `json:"revision,omitempty"`
-	Repository                 []string `json:"repository,omitempty"`
-	Codebase                   []string `json:"codebase,omitempty"`
-	Project                    []string `json:"project,omitempty"`
-	Builddir                   []string `json:"builddir,omitempty"`
-	Owner                      []any    `json:"owner,omitempty"`
+// Properties maps the name of a build property to its value. Which properties
+// are present changes per build, so we don't decode them into a struct. Use
+// Strings() to get the values of all properties.
+type Properties map[string]Property
+
+// Property is a build property as Buildbot reports it: a [value, source]
+// pair like ["123", "Try"].
+type Property struct {
+	// Value holds the JSON representation of the value (e.g. "foo", 123 or
+	// true).
+	Value  json.RawMessage
+	Source string
+}
+
+// UnmarshalJSON decodes a [value, source] pair.
+func (p *Property) UnmarshalJSON(data []byte) error {
+	pair := []json.RawMessage{}
+	if err := json.Unmarshal(data, &pair); err != nil {
+		return fmt.Errorf("failed to decode property: %w", err)
+	}
+	if len(pair) != 2 {
+		return fmt.Errorf("expected property to be a [value, source] pair but got %s", data)
+	}
+	p.Value = pair[0]
+	return json.Unmarshal(pair[1], &p.Source)
+}
+
+// MarshalJSON encodes the property as a [value, source] pair.
+func (p Property) MarshalJSON() ([]byte, error) {
+	value := p.Value
+	if len(value) == 0 {
+		value = json.RawMessage("null")
+	}
+	return json.Marshal([]interface{}{value, p.Source})
+}
+
+// String returns the property's value as a string. Strings are returned
+// without quotes and null becomes an empty string. All other values are
+// returned in their JSON representation.
+func (p Property) String() string {
+	s := ""
+	if err := json.Unmarshal(p.Value, &s); err == nil {
+		return s
+	}
+	if string(p.Value) == "null" {
+		return ""
+	}
+	return string(p.Value)
+}
+
+// Strings returns the values of all properties as strings.
+func (p Properties) Strings() map[string]string {
+	m := make(map[string]string, len(p))
+	for name, property := range p {
+		m[name] = property.String()
+	}
+	return m
 }
 
 type Buildrequest struct {
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// buildbotControl sends a control request to the Buildbot REST API. Control
+// requests are JSON-RPC 2.0 calls on an endpoint (see
+// https://docs.buildbot.net/latest/developer/rest.html#controlling).
+func (srv *AppServer) buildbotControl(endpoint string, method string, params interface{}) error {
+	if srv.buildbotWWWURL == "" {
+		return fmt.Errorf("BUILDBOT_WWW_URL is not set")
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"id":      1,
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal control request: %w", err)
+	}
+	url := fmt.Sprintf("%s/api/v2/%s", strings.TrimRight(srv.buildbotWWWURL, "/"), endpoint)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create control request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if srv.buildbotWWWUser != "" {
+		req.SetBasicAuth(srv.buildbotWWWUser, srv.buildbotWWWPassword)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send control request to %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("control request %s on %s failed with status %s: %s", method, url, resp.Status, respBody)
+	}
+	return nil
+}
+
+// stopBuildbotBuild stops the Buildbot build with the given ID.
+func (srv *AppServer) stopBuildbotBuild(buildID int, reason string) error {
+	return srv.buildbotControl(fmt.Sprintf("builds/%d", buildID), "stop", map[string]string{"reason": reason})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppServer_StopBuildbotBuild(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		var gotPath string
+		var gotBody map[string]interface{}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotPath = r.URL.Path
+			user, password, ok := r.BasicAuth()
+			require.True(t, ok)
+			require.Equal(t, "user", user)
+			require.Equal(t, "secret", password)
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&gotBody))
+			w.Write([]byte(`{"id": 1, "jsonrpc": "2.0", "result": null}`))
+		}))
+		defer ts.Close()
+
+		srv := &AppServer{buildbotWWWURL: ts.URL + "/", buildbotWWWUser: "user", buildbotWWWPassword: "secret"}
+		err := srv.stopBuildbotBuild(42, "Cancelled by @johndoe")
+		require.NoError(t, err)
+		require.Equal(t, "/api/v2/builds/42", gotPath)
+		require.Equal(t, "stop", gotBody["method"])
+		require.Equal(t, map[string]interface{}{"reason": "Cancelled by @johndoe"}, gotBody["params"])
+	})
+	t.Run("error status", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+		}))
+		defer ts.Close()
+
+		srv := &AppServer{buildbotWWWURL: ts.URL}
+		err := srv.stopBuildbotBuild(42, "reason")
+		require.ErrorContains(t, err, "403 Forbidden")
+	})
+	t.Run("no url", func(t *testing.T) {
+		srv := &AppServer{}
+		err := srv.stopBuildbotBuild(42, "reason")
+		require.ErrorContains(t, err, "BUILDBOT_WWW_URL is not set")
+	})
+}
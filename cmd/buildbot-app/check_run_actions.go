@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+)
+
+// checkRunActionRequest bundles everything that is needed to handle a click on
+// one of the buttons of CheckRunActions.
+type checkRunActionRequest struct {
+	srv               Server
+	gh                *github.Client
+	appInstallationID int64
+	repoOwner         string
+	repoName          string
+	checkRun          *github.CheckRun
+	meta              *CheckRunMeta
+	// The user who clicked the button
+	sender Commenter
+	config *RepoConfig
+}
+
+// newCheckRunActionRequest returns nil if the check run wasn't created by us
+// for a build.
+func newCheckRunActionRequest(srv Server, event *github.CheckRunEvent) (*checkRunActionRequest, error) {
+	checkRun := event.GetCheckRun()
+	if _, ok := buildCheckRunMeta(checkRun); !ok {
+		log.Printf("ignoring check run %d: not a build of ours", checkRun.GetID())
+		return nil, nil
+	}
+	r, err := newSenderRequest(srv, event.GetInstallation().GetID(), event.GetRepo(), event.GetSender())
+	if err != nil {
+		return nil, err
+	}
+	r.forCheckRun(checkRun)
+	return r, nil
+}
+
+// newSenderRequest returns a request of the sender in the repository that
+// isn't bound to a check run yet (see forCheckRun).
+func newSenderRequest(srv Server, appInstallationID int64, repo *github.Repository, sender *github.User) (*checkRunActionRequest, error) {
+	gh, err := srv.NewGithubClient(appInstallationID)
+	if err != nil {
+		return nil, fmt.Errorf("error creating github client: %w", err)
+	}
+	repoOwner := repo.GetOwner().GetLogin()
+	repoName := repo.GetName()
+	login := sender.GetLogin()
+	association, err := githubAuthorAssociation(gh, repo, login)
+	if err != nil {
+		return nil, err
+	}
+	config, err := srv.GetRepoConfig(appInstallationID, repoOwner, repoName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repository configuration: %w", err)
+	}
+	return &checkRunActionRequest{
+		srv:               srv,
+		gh:                gh,
+		appInstallationID: appInstallationID,
+		repoOwner:         repoOwner,
+		repoName:          repoName,
+		sender:            Commenter{Login: login, AuthorAssociation: association},
+		config:            config,
+	}, nil
+}
+
+// forCheckRun binds the request to the check run and returns false if the
+// check run wasn't created by us for a build.
+func (r *checkRunActionRequest) forCheckRun(checkRun *github.CheckRun) bool {
+	meta, ok := buildCheckRunMeta(checkRun)
+	if !ok {
+		return false
+	}
+	r.checkRun = checkRun
+	r.meta = meta
+	return true
+}
+
+// buildCheckRunMeta returns the meta data of a check run that we created for
+// a build and false for any other check run.
+func buildCheckRunMeta(checkRun *github.CheckRun) (*CheckRunMeta, bool) {
+	meta, err := CheckRunMetaFromCheckRun(checkRun)
+	if err != nil || meta.Approval != nil {
+		return nil, false
+	}
+	return meta, true
+}
+
+// reply writes a comment on the pull request of the check run. Check runs
+// without a pull request (e.g. of a push) get the message in their summary
+// instead.
+func (r checkRunActionRequest) reply(msg string) error {
+	prNumber := r.meta.pullRequestNumber(r.checkRun)
+	if prNumber == 0 {
+		log.Printf("check run %d: %s", r.checkRun.GetID(), msg)
+		summary := WrapMsgWithTimePrefix(msg, time.Now())
+		if r.checkRun.GetOutput().GetSummary() != "" {
+			summary = strings.Join([]string{r.checkRun.GetOutput().GetSummary(), summary}, "\n")
+		}
+		_, _, err := r.gh.Checks.UpdateCheckRun(context.Background(), r.repoOwner, r.repoName, r.checkRun.GetID(), github.UpdateCheckRunOptions{
+			Name: r.checkRun.GetName(),
+			Output: &github.CheckRunOutput{
+				Title:   github.String(r.checkRun.GetOutput().GetTitle()),
+				Summary: github.String(summary),
+				Text:    github.String(r.checkRun.GetOutput().GetText()),
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add message to check run %d: %w", r.checkRun.GetID(), err)
+		}
+		return nil
+	}
+	_, _, err := r.gh.Issues.CreateComment(context.Background(), r.repoOwner, r.repoName, prNumber, &github.IssueComment{
+		Body: github.String(msg),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write comment: %w", err)
+	}
+	return nil
+}
+
+// authorize replies with the reasons why the sender may not do what the
+// command does and returns false in that case.
+func (r checkRunActionRequest) authorize(cmd *command.Command, what string) (bool, error) {
+	reasons, err := r.config.Authorization.Authorize(cmd, r.sender, githubTeamMembership(r.gh))
+	if err != nil {
+		return false, fmt.Errorf("failed to authorize %s: %w", what, err)
+	}
+	if len(reasons) == 0 {
+		return true, nil
+	}
+	log.Printf("denied %s of %s: %s", what, r.sender.Login, strings.Join(reasons, "; "))
+	msg := fmt.Sprintf("Sorry @%s, but you are not allowed to %s <code>%s</code>:\n\n* %s", r.sender.Login, what, r.checkRun.GetName(), strings.Join(reasons, "\n* "))
+	return false, r.reply(msg)
+}
+
+// onMakeMandatoryAction handles the "Make check required" and "Make check
+// optional" buttons. The flag is stored in the check run's meta data and a
+// completed check run gets the conclusion that it would have had with the new
+// flag, so failures turn into neutral and back.
+func onMakeMandatoryAction(srv Server, event *github.CheckRunEvent, isMandatory bool) error {
+	r, err := newCheckRunActionRequest(srv, event)
+	if err != nil || r == nil {
+		return err
+	}
+	what := "make optional"
+	if isMandatory {
+		what = "make mandatory"
+	}
+	cmd := command.New()
+	cmd.IsMandatory = isMandatory
+	if ok, err := r.authorize(cmd, what); !ok || err != nil {
+		return err
+	}
+	if r.meta.Command.IsMandatory == isMandatory {
+		log.Printf("check run %d already is %s", r.checkRun.GetID(), strings.TrimPrefix(what, "make "))
+		return nil
+	}
+
+	r.meta.Command.IsMandatory = isMandatory
+	opts := github.UpdateCheckRunOptions{
+		Name: r.checkRun.GetName(),
+	}
+	if r.checkRun.GetStatus() == string(CheckRunStateCompleted) {
+		current := CheckRunConclusion(r.checkRun.GetConclusion())
+		// Remember the build's own conclusion before it is hidden
+		if r.meta.BuildConclusion == "" {
+			r.meta.BuildConclusion = current
+		}
+		conclusion := CheckRunConclusionFor(r.meta.BuildConclusion, isMandatory)
+		opts.Status = github.String(string(CheckRunStateCompleted))
+		opts.Conclusion = github.String(string(conclusion))
+	}
+	externalID, err := r.meta.ExternalID()
+	if err != nil {
+		return err
+	}
+	opts.ExternalID = github.String(externalID)
+	summary := WrapMsgWithTimePrefix(fmt.Sprintf("@%s made this check %s.", r.sender.Login, strings.TrimPrefix(what, "make ")), time.Now())
+	if r.checkRun.GetOutput().GetSummary() != "" {
+		summary = strings.Join([]string{r.checkRun.GetOutput().GetSummary(), summary}, "\n")
+	}
+	opts.Output = &github.CheckRunOutput{
+		Title:   github.String(CheckRunTitle(isMandatory)),
+		Summary: github.String(summary),
+		Text:    github.String(r.checkRun.GetOutput().GetText()),
+	}
+	opts.Actions = CheckRunActions()
+	_, _, err = r.gh.Checks.UpdateCheckRun(context.Background(), r.repoOwner, r.repoName, r.checkRun.GetID(), opts)
+	if err != nil {
+		return fmt.Errorf("failed to %s check run %d: %w", what, r.checkRun.GetID(), err)
+	}
+	return nil
+}
+
+// onReRunCheckAction handles the "Rerun check" button. It builds the command
+// of the check run again for the pull request's head SHA in a new check run,
+// just like /buildbot retry does. Builds without a pull request (e.g. of a
+// push) are built again for the check run's head SHA.
+func onReRunCheckAction(srv Server, event *github.CheckRunEvent) error {
+	r, err := newCheckRunActionRequest(srv, event)
+	if err != nil || r == nil {
+		return err
+	}
+	return r.rerun()
+}
+
+// rerun builds the command of the request's check run again in a new check
+// run (see rerunTarget).
+func (r checkRunActionRequest) rerun() error {
+	// GitHub builds the merge group again when the pull request is added to
+	// the merge queue again.
+	if r.meta.Command.Trigger == command.TriggerMergeGroup {
+		return r.reply(fmt.Sprintf("Sorry @%s, but builds of a merge group can't be rerun. Add the pull request to the merge queue again instead.", r.sender.Login))
+	}
+
+	retryCmd := command.New()
+	retryCmd.Subcommand = command.SubcommandRetry
+	retryCmd.BuilderNames = r.meta.Command.BuilderNames
+	if ok, err := r.authorize(retryCmd, "rerun"); !ok || err != nil {
+		return err
+	}
+	// The rerun builds the original command, so the sender needs the rights
+	// to all of its options as well.
+	cmd := *r.meta.Command
+	cmd.CommentAuthor = r.sender.Login
+	cmd.CommentID = 0
+	cmd.DryRun = false
+	if ok, err := r.authorize(&cmd, "rerun"); !ok || err != nil {
+		return err
+	}
+	cmd.Force = true
+	if r.config.Authorization.RequiresApproval(r.sender) {
+		return r.reply(fmt.Sprintf("Sorry @%s, but builds of first-time and external contributors have to be requested with a <code>%s</code> comment and approved by a maintainer.", r.sender.Login, command.BuildbotCommand))
+	}
+	var rateLimitErr *RateLimitError
+	if err := r.srv.TakeBuildToken(r.repoOwner, r.repoName, r.sender, r.config.RateLimits); errors.As(err, &rateLimitErr) {
+		return r.reply(rateLimitMessage(r.sender.Login, rateLimitErr, time.Now()))
+	} else if err != nil {
+		return fmt.Errorf("failed to check rate limits: %w", err)
+	}
+
+	target, err := r.rerunTarget()
+	if err != nil {
+		return err
+	}
+	thankYouComment := fmt.Sprintf("Thank you @%s for rerunning <code>%s</code>! ", r.sender.Login, r.checkRun.GetName())
+	if err := runBuild(r.srv, r.gh, r.appInstallationID, target, &cmd, thankYouComment); err != nil {
+		return fmt.Errorf("failed to rerun check run %d: %w", r.checkRun.GetID(), err)
+	}
+	return nil
+}
+
+// rerunTarget returns what a rerun of the request's check run builds: the
+// current head SHA of its pull request or, for builds without a pull request
+// (e.g. of a push), the check run's head SHA with the refs of its check suite.
+func (r checkRunActionRequest) rerunTarget() (*BuildTarget, error) {
+	if prNumber := r.meta.pullRequestNumber(r.checkRun); prNumber != 0 {
+		pr, _, err := r.gh.PullRequests.Get(context.Background(), r.repoOwner, r.repoName, prNumber)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pull request: %w", err)
+		}
+		return NewBuildTarget(pr), nil
+	}
+	suite := r.checkRun.GetCheckSuite()
+	return &BuildTarget{
+		BaseRepoName:  r.repoName,
+		BaseRepoOwner: r.repoOwner,
+		BaseRef:       suite.GetHeadBranch(),
+		BaseSHA:       suite.GetBeforeSHA(),
+		HeadRef:       suite.GetHeadBranch(),
+		HeadSHA:       r.checkRun.GetHeadSHA(),
+	}, nil
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"time"
+
+	"github.com/google/go-github/v50/github"
 )
 
 // See https://docs.github.com/en/rest/guides/using-the-rest-api-to-interact-with-checks?apiVersion=2022-11-28#about-check-runs
@@ -41,6 +43,70 @@ const CheckSuiteConclusionStale = "stale"
 const CheckSuiteConclusionStartup_failure = "startup_failure"
 const CheckSuiteConclusionSuccess = "success"
 
+// CheckRunActionApproveBuild is the identifier of the button that approves a
+// parked build request.
+const CheckRunActionApproveBuild = "ApproveBuild"
+
+// ApprovalCheckRunActions returns the buttons that we show on the check run of
+// a build request that waits for a maintainer's approval.
+func ApprovalCheckRunActions() []*github.CheckRunAction {
+	return []*github.CheckRunAction{
+		{
+			Label:       "Approve build",
+			Description: "Run this build request",
+			Identifier:  CheckRunActionApproveBuild,
+		},
+	}
+}
+
+// Identifiers of the buttons that we show on every check run page (see
+// CheckRunActions).
+const (
+	CheckRunActionMakeMandatory = "MakeMandatory"
+	CheckRunActionMakeOptional  = "MakeOptional"
+	CheckRunActionReRunCheck    = "ReRunCheck"
+)
+
+// CheckRunActions returns the buttons that we show on every check run page.
+func CheckRunActions() []*github.CheckRunAction {
+	return []*github.CheckRunAction{
+		{
+			Label:       "Make check required",
+			Description: "Make check required to pass",
+			Identifier:  CheckRunActionMakeMandatory,
+		},
+		{
+			Label:       "Make check optional",
+			Description: "This check is optional",
+			Identifier:  CheckRunActionMakeOptional,
+		},
+		{
+			Label:       "Rerun check",
+			Description: "Reruns the check",
+			Identifier:  CheckRunActionReRunCheck,
+		},
+	}
+}
+
+// CheckRunTitle returns the title of a check run that logs the status of its
+// builds.
+func CheckRunTitle(isMandatory bool) string {
+	if !isMandatory {
+		return "Buildbot Status Log (check is optional)"
+	}
+	return "Buildbot Status Log"
+}
+
+// CheckRunConclusionFor returns the conclusion of a check run whose build
+// concluded with the given conclusion. Optional check runs never fail, so
+// anything but success turns into neutral.
+func CheckRunConclusionFor(buildConclusion CheckRunConclusion, isMandatory bool) CheckRunConclusion {
+	if !isMandatory && buildConclusion != CheckRunConclusionSuccess {
+		return CheckRunConclusionNeutral
+	}
+	return buildConclusion
+}
+
 // See also https://docs.buildbot.net/latest/developer/results.html#build-result-codes
 func CheckRunStateFromBuildbotResult(resultCode int) CheckRunConclusion {
 	switch resultCode {
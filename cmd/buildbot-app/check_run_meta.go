@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+)
+
+// CheckRunMeta is what we store in the external ID of every check run that we
+// create. It allows us to find out later which command created a check run,
+// for example when the build shall be cancelled or retried.
+type CheckRunMeta struct {
+	Command *command.Command `json:"command"`
+	// Approval is set for check runs that stand in for a build request that
+	// needs a maintainer's approval (see parkForApproval).
+	Approval *Approval `json:"approval,omitempty"`
+	// Number of the pull request that the check run was created for. Check
+	// runs of pull requests from forks don't tell.
+	PullRequestNumber int `json:"pull_request_number,omitempty"`
+	// BuildConclusion is the conclusion of the check run's build before it
+	// was turned into neutral because the check run is optional (see
+	// CheckRunConclusionFor). It allows us to make the check run mandatory
+	// again.
+	BuildConclusion CheckRunConclusion `json:"build_conclusion,omitempty"`
+	// ID of the comment that logs the state changes of the check run's
+	// builds
+	BuildLogCommentID int64 `json:"build_log_comment_id,omitempty"`
+}
+
+// pullRequestNumber returns the number of the pull request that the check run
+// was created for or zero if it is unknown.
+func (m CheckRunMeta) pullRequestNumber(checkRun *github.CheckRun) int {
+	switch {
+	case m.PullRequestNumber != 0:
+		return m.PullRequestNumber
+	case m.Approval != nil:
+		return m.Approval.PullRequestNumber
+	case len(checkRun.PullRequests) > 0:
+		return checkRun.PullRequests[0].GetNumber()
+	}
+	return 0
+}
+
+// An Approval tells whether a parked build request has been approved and by
+// whom.
+type Approval struct {
+	// Number of the pull request that the build was requested for
+	PullRequestNumber int `json:"pull_request_number"`
+	// Author association of the user who requested the build. The build
+	// counts against the rate limits of the user once it is approved.
+	AuthorAssociation string `json:"author_association,omitempty"`
+	// Login of the maintainer who approved the build request or empty if it
+	// still waits for approval.
+	ApprovedBy string `json:"approved_by,omitempty"`
+}
+
+// ExternalID returns the JSON representation of the meta data to be stored as
+// a check run's external ID.
+func (m CheckRunMeta) ExternalID() (string, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal check run meta data: %w", err)
+	}
+	return string(data), nil
+}
+
+// CheckRunMetaFromCheckRun decodes the meta data stored in the external ID of
+// the given check run. An error is returned if the check run wasn't created by
+// us or if it has been created before we stored meta data.
+func CheckRunMetaFromCheckRun(checkRun *github.CheckRun) (*CheckRunMeta, error) {
+	if checkRun == nil || checkRun.GetExternalID() == "" {
+		return nil, fmt.Errorf("check run has no external ID")
+	}
+	meta := CheckRunMeta{}
+	if err := json.Unmarshal([]byte(checkRun.GetExternalID()), &meta); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal check run meta data: %w", err)
+	}
+	if meta.Command == nil {
+		return nil, fmt.Errorf("check run meta data has no command")
+	}
+	return &meta, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v50/github"
+)
+
+// checkRunReporter reports every build with a check run. The check run
+// remembers the command of the build (see CheckRunMeta), which is what
+// cancelling, retrying and the check run's buttons rely on.
+type checkRunReporter struct{}
+
+func (checkRunReporter) ExistingBuilds(gh *github.Client, appInstallationID int64, target *BuildTarget) ([]*github.CheckRun, error) {
+	return GetAllCheckRunsForRef(gh, appInstallationID, target.BaseRepoOwner, target.BaseRepoName, target.HeadSHA)
+}
+
+func (checkRunReporter) TracksBuilds() bool {
+	return true
+}
+
+// BuildQueued creates the check run of the build and remembers it until the
+// build is complete if the build is for a pull request.
+func (checkRunReporter) BuildQueued(r BuildRequest, buildLogCommentID int64) (int64, error) {
+	target := r.Target
+	cmd := r.Command
+	repoOwner := target.BaseRepoOwner
+	repoName := target.BaseRepoName
+
+	externalID, err := CheckRunMeta{Command: cmd, PullRequestNumber: target.Number, BuildLogCommentID: buildLogCommentID}.ExternalID()
+	if err != nil {
+		return 0, err
+	}
+	opts := github.CreateCheckRunOptions{
+		Name:       cmd.ToGithubCheckNameString(),
+		HeadSHA:    target.HeadSHA,
+		ExternalID: github.String(externalID),
+		Status:     github.String(string(CheckRunStateQueued)),
+		Output: &github.CheckRunOutput{
+			Title:   github.String(CheckRunTitle(cmd.IsMandatory)),
+			Summary: github.String(r.checkRunSummary()),
+			Text:    github.String("Please wait for the URL to your buildbot job to appear here."),
+			Images:  nil,
+		},
+		Actions: CheckRunActions(),
+	}
+	checkRunTryBot, _, err := r.Github.Checks.CreateCheckRun(context.Background(), repoOwner, repoName, opts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create try bot check run: %w", err)
+	}
+	if target.IsPullRequest() {
+		r.Server.TrackCheckRun(repoOwner, repoName, target.Number, inFlightCheckRun{
+			CheckRunID:        checkRunTryBot.GetID(),
+			Name:              opts.Name,
+			HeadSHA:           target.HeadSHA,
+			BuildLogCommentID: buildLogCommentID,
+		})
+	}
+	return checkRunTryBot.GetID(), nil
+}
+
+// BuildUpdated adds the state of the build to the summary of its check run
+// and completes the check run once the build is complete.
+func (checkRunReporter) BuildUpdated(ctx context.Context, gh *github.Client, u BuildUpdate) (BuildReport, error) {
+	repoOwner := u.Target.BaseRepoOwner
+	repoName := u.Target.BaseRepoName
+	checkRunID := u.Properties.CheckRunID
+	buildStatus := u.Status
+
+	checkRun, _, err := gh.Checks.GetCheckRun(ctx, repoOwner, repoName, checkRunID)
+	if err != nil {
+		return BuildReport{}, fmt.Errorf("error getting check run: %w", err)
+	}
+
+	// The check run knows better than the build properties whether it is
+	// mandatory because the flag can be changed with the check run's
+	// buttons after the build was started.
+	isMandatory := u.Command.IsMandatory
+	var externalID *string
+	// The comment that requested the build (if any)
+	var commentID int64
+	buildConclusion := CheckRunStateFromBuildbotResult(buildStatus.Results)
+	if meta, err := CheckRunMetaFromCheckRun(checkRun); err == nil {
+		isMandatory = meta.Command.IsMandatory
+		commentID = meta.Command.CommentID
+		if buildStatus.Complete {
+			meta.BuildConclusion = buildConclusion
+			id, err := meta.ExternalID()
+			if err != nil {
+				return BuildReport{}, err
+			}
+			externalID = github.String(id)
+		}
+	}
+	conclusion := CheckRunConclusionFor(buildConclusion, isMandatory)
+
+	now := time.Now()
+	newStateString := fmt.Sprintf("[Builder: %s]: %s ([log](%s))", buildStatus.Builder.Name, buildStatus.StateString, buildStatus.URL)
+	if checkRun.Output != nil && checkRun.Output.Summary != nil {
+		newStateString = strings.Join([]string{*checkRun.Output.Summary, WrapMsgWithTimePrefix(newStateString, now)}, "\n")
+	}
+
+	title := CheckRunTitle(isMandatory)
+	// Builds that are still running keep the check run in progress. A check
+	// run that timed out or was cancelled stays that way, even when
+	// Buildbot reports the builds that we've stopped.
+	stopped := false
+	switch CheckRunConclusion(checkRun.GetConclusion()) {
+	case CheckRunConclusionTimedOut, CheckRunConclusionCancelled:
+		conclusion = CheckRunConclusion(checkRun.GetConclusion())
+		stopped = true
+	}
+	status := github.String(string(CheckRunStateCompleted))
+	conclusionStr := github.String(string(conclusion))
+	if !buildStatus.Complete && !stopped {
+		status = github.String(string(CheckRunStateInProgress))
+		conclusionStr = nil
+	}
+	_, _, err = gh.Checks.UpdateCheckRun(ctx, repoOwner, repoName, checkRunID, github.UpdateCheckRunOptions{
+		Name:       *checkRun.Name,
+		ExternalID: externalID,
+		Status:     status,
+		Conclusion: conclusionStr,
+		DetailsURL: github.String(buildStatus.URL),
+		Output: &github.CheckRunOutput{
+			Title:   github.String(title),
+			Summary: github.String(newStateString),
+			Text:    github.String(fmt.Sprintf("[Buildbot Build Page](%s)", buildStatus.URL)),
+		},
+		Actions: CheckRunActions(),
+	})
+	if err != nil {
+		return BuildReport{}, fmt.Errorf("failed to update try bot check run: %w", err)
+	}
+	log.Printf("updated github check run: %s\n", *checkRun.Name)
+	log.Printf("check run details: %s\n", buildStatus.URL)
+	return BuildReport{Conclusion: conclusion, CommentID: commentID}, nil
+}
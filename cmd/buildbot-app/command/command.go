@@ -2,9 +2,9 @@ package command
 
 import (
 	"fmt"
-	"regexp"
 	"sort"
 	"strings"
+	"time"
 )
 
 // tag::command_options[]
@@ -22,113 +22,339 @@ const (
 	// list of builders with no duplicates.
 	CommandOptionBuilder = "builder"
 
+	// CommandOptionPreset is the option that can be used multiple times to
+	// build on the builders and with the options of a named preset of the
+	// repository (e.g. preset=full).
+	CommandOptionPreset = "preset"
+
 	// CommandOptionForce is the boolean option to enforce a new build even if
 	// one is already present.
 	CommandOptionForce = "force"
+
+	// CommandOptionOs selects builders by the operating system of their
+	// workers (e.g. os=linux).
+	CommandOptionOs = "os"
+
+	// CommandOptionArch selects builders by the architecture of their workers
+	// (e.g. arch=aarch64).
+	CommandOptionArch = "arch"
+
+	// CommandOptionOsDistro selects builders by the operating system
+	// distribution of their workers (e.g. os-distro=fedora).
+	CommandOptionOsDistro = "os-distro"
+
+	// CommandOptionOsVer selects builders by the operating system version of
+	// their workers (e.g. os-ver=38).
+	CommandOptionOsVer = "os-ver"
+
+	// CommandOptionProperty is the namespaced option to pass custom
+	// properties to the builders (e.g. prop:test_filter=foo).
+	CommandOptionProperty = "prop"
+
+	// CommandOptionDryRun is the boolean option to only show what a command
+	// would do without building anything.
+	CommandOptionDryRun = "dry-run"
+
+	// CommandOptionTimeout is the duration option after which the app gives up
+	// on a build that hasn't completed and cancels it (e.g. timeout=2h).
+	CommandOptionTimeout = "timeout"
+
+	// CommandOptionPriority is the option to make Buildbot handle a build
+	// request before or after others (priority=high, normal or low).
+	CommandOptionPriority = "priority"
+
+	// PropertyCommentAuthor is the name of the try-bot property that holds
+	// the user's GitHub login that issued the command.
+	PropertyCommentAuthor = "command_author"
+
+	// PropertyTrigger is the name of the try-bot property that holds what
+	// started a command that wasn't written in a comment (see Trigger).
+	PropertyTrigger = "command_trigger"
+
+	// PropertyLabel is the name of the try-bot property that holds the pull
+	// request label that started a command (see Label).
+	PropertyLabel = "command_label"
+
+	// PropertyBuildRequestPriority is the name of the try-bot property that
+	// holds the Buildbot build request priority (see BuildRequestPriority).
+	PropertyBuildRequestPriority = "build_request_priority"
 )
 
 // end::command_options[]
 
-// TODO(kwk): Maybe we can have a ResolveBuilderNames() method in order to have
-// a more highlevel selection process for builder names. For example, if
-// somebody narrows down a command to run on linux operating systems (os=linux)
-// with an aarch64 architecture (arch=aarch64), we could resolve this to a list
-// of builder names available.
-
 // tag::command[]
 // A Command represents all information about a /buildbot command
 type Command struct {
+	// What to do (default: build).
+	Subcommand Subcommand `json:"subcommand"`
 	// When true, the command has to pass for the PR in order pass gating
 	// (default: true).
-	IsMandatory bool
+	IsMandatory bool `json:"mandatory"`
 	// Case-sensitive, sorted list of builders without duplicates to run build on.
 	// TODO(kwk): Maybe we can default to something reasonable here?
-	BuilderNames []string
+	BuilderNames []string `json:"builders"`
+	// Names of the presets that the builders and options came from (see
+	// Presets). The check run name shows them instead of their builders.
+	Presets []string `json:"presets,omitempty"`
+	// Builders that were added by the presets
+	PresetBuilderNames []string `json:"preset-builders,omitempty"`
 	// The user's GitHub login that issued the /buildbot comment
-	CommentAuthor string
+	CommentAuthor string `json:"author"`
+	// The ID of the /buildbot comment. Zero if the command wasn't written in
+	// a comment.
+	CommentID int64 `json:"comment-id,omitempty"`
+	// What started the command if it wasn't written in a comment (e.g.
+	// TriggerAutomatic). The check run name shows it instead of the author.
+	Trigger string `json:"trigger,omitempty"`
+	// The pull request label that started the command (see TriggerLabel)
+	Label string `json:"label,omitempty"`
 	// When true, we'll try to run the build even if the PR has already been
 	// tested at this stage (default: false).
-	Force bool
+	Force bool `json:"force"`
+	// Attribute selectors that are resolved to builder names using
+	// ResolveBuilderNames(). An empty string means "any".
+	Os       string `json:"os,omitempty"`
+	Arch     string `json:"arch,omitempty"`
+	OsDistro string `json:"os-distro,omitempty"`
+	OsVer    string `json:"os-ver,omitempty"`
+	// Custom properties that are passed to the builders as user_<name>
+	Properties map[string]string `json:"properties,omitempty"`
+	// When true, we only reply with what the command would do (default:
+	// false).
+	DryRun bool `json:"dry-run,omitempty"`
+	// The build is cancelled and its check run is completed as timed out if
+	// it hasn't completed after this duration. Zero means no timeout.
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// One of high, normal or low (default: normal).
+	Priority string `json:"priority"`
 }
 
 // end::command[]
 
-// New returns a Command object with defaults applied
+// TriggerAutomatic marks commands that the app started on its own for a
+// pull request that was opened, pushed to or reopened.
+const TriggerAutomatic = "automatic"
+
+// TriggerLabel marks commands that the app started because a label was added
+// to a pull request (see Label).
+const TriggerLabel = "label"
+
+// TriggerMergeGroup marks commands that the app started for a merge group of
+// GitHub's merge queue.
+const TriggerMergeGroup = "merge_group"
+
+// TriggerPush marks commands that the app started for a push to a branch.
+const TriggerPush = "push"
+
+// tag::priorities[]
+// Values of the priority option
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// buildRequestPriorities maps the values of the priority option to Buildbot
+// build request priorities. Buildbot handles build requests with a higher
+// priority first.
+var buildRequestPriorities = map[string]int{
+	PriorityHigh:   10,
+	PriorityNormal: 0,
+	PriorityLow:    -10,
+}
+
+// end::priorities[]
+
+// BuildRequestPriority returns the Buildbot build request priority for the
+// command's priority. An unknown priority is treated as normal.
+func (c Command) BuildRequestPriority() int {
+	return buildRequestPriorities[c.Priority]
+}
+
+// New returns a Command object with the defaults of all options applied
 func New() *Command {
-	return &Command{
-		IsMandatory:   true,
+	cmd := &Command{
+		Subcommand:    SubcommandBuild,
 		BuilderNames:  []string{},
 		CommentAuthor: "",
-		Force:         false,
 	}
+	for _, o := range Options {
+		if o.Default == "" {
+			continue
+		}
+		if err := o.Set(cmd, o.Default); err != nil {
+			panic(fmt.Sprintf("invalid default for command option %q: %v", o.Name, err))
+		}
+	}
+	return cmd
 }
 
-// From creates a new command object from the given string.
+// FromString creates a new command object from the given string. If the
+// string cannot be parsed, a *ParseError is returned that tells where and why
+// parsing failed. Commands that use presets need FromStringWithPresets.
 func FromString(s string) (*Command, error) {
-	if !StringIsCommand(s) {
-		return nil, fmt.Errorf("string is no valid command: %s", s)
+	return FromStringWithPresets(s, nil)
+}
+
+// FromStringWithPresets is like FromString but expands preset=<name> options
+// into the builders and option values of the given presets. Options that are
+// written in the command override the ones of the presets and builders of
+// both are merged.
+func FromStringWithPresets(s string, presets Presets) (*Command, error) {
+	if presets == nil {
+		presets = Presets{}
 	}
-	args, err := parseIntoMap(s)
+	args, err := parseString(s, presets)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse string into command map: %s", s)
+		return nil, err
 	}
 
 	// Get a command with proper defaults
 	cmd := New()
 
-	if mandatory, ok := args[CommandOptionMandatory]; ok {
-		cmd.IsMandatory = valueIsTrue(mandatory)
+	if subcommand, ok := args[subcommandKey]; ok {
+		cmd.Subcommand = Subcommand(fmt.Sprintf("%v", subcommand))
 	}
-	if force, ok := args[CommandOptionForce]; ok {
-		cmd.Force = valueIsTrue(force)
+
+	if names, ok := args[CommandOptionPreset].([]string); ok {
+		if err := cmd.applyPresets(names, presets); err != nil {
+			return nil, err
+		}
 	}
-	if builderNames, ok := args[CommandOptionBuilder]; ok {
-		builderNamesArr, ok := builderNames.([]string)
+
+	for _, o := range Options {
+		value, ok := args[o.Name]
 		if !ok {
-			return nil, fmt.Errorf("invalud builder names: %+v", builderNames)
+			continue
+		}
+		if builders, ok := value.([]string); ok && o.Name == CommandOptionBuilder {
+			value = removeDuplicatesInPlace(append(append([]string{}, cmd.BuilderNames...), builders...))
+		}
+		if err := o.Set(cmd, value); err != nil {
+			return nil, err
 		}
-		cmd.BuilderNames = builderNamesArr
 	}
 
 	return cmd, nil
 }
 
 // ToGithubCheckNameString returns a string representation to be used as a GitHub check
-// run name.
+// run name. Only options that are marked with InCheckName are part of it. A
+// command with attribute selectors is named after its selectors (e.g.
+// os=linux arch=aarch64) rather than after the builders they resolve to. A
+// command with presets is named after its presets and only the builders that
+// don't come from a preset are listed. Commands with a Trigger are named after
+// it (e.g. [automatic] /buildbot ...) instead of after their author.
 func (c Command) ToGithubCheckNameString() string {
-	return fmt.Sprintf("@%s %s %s=%t %s=%t %s=%s", c.CommentAuthor, BuildbotCommand, CommandOptionMandatory, c.IsMandatory, CommandOptionForce, c.Force, CommandOptionBuilder, c.BuilderNames)
+	hasSelectors := len(c.Selectors()) > 0
+	if len(c.Presets) > 0 {
+		c.BuilderNames = c.nonPresetBuilderNames()
+	}
+	parts := []string{"@" + c.CommentAuthor, BuildbotCommand}
+	if c.Trigger != "" {
+		parts[0] = "[" + c.Trigger + "]"
+	}
+	for _, o := range Options {
+		if !o.InCheckName || o.OmitEmpty && o.IsEmpty(c) {
+			continue
+		}
+		if o.Name == CommandOptionBuilder && (hasSelectors || len(c.Presets) > 0 && len(c.BuilderNames) == 0) {
+			continue
+		}
+		parts = append(parts, o.checkNameParts(c)...)
+	}
+	return strings.Join(parts, " ")
+}
+
+// nonPresetBuilderNames returns the builders that were given in addition to
+// the ones of the presets.
+func (c Command) nonPresetBuilderNames() []string {
+	names := []string{}
+	for _, name := range c.BuilderNames {
+		found := false
+		for _, presetName := range c.PresetBuilderNames {
+			if name == presetName {
+				found = true
+				break
+			}
+		}
+		if !found {
+			names = append(names, name)
+		}
+	}
+	return names
 }
 
 // ToTryBotPropertyArray returns a string array with properties set to be passed
 // along to a "buildbot try" command.
 func (c Command) ToTryBotPropertyArray() []string {
-	return []string{
-		fmt.Sprintf("--property=command_is_mandatory=%t", c.IsMandatory),
-		fmt.Sprintf("--property=command_force=%t", c.Force),
-		fmt.Sprintf("--property=command_builders=%s", strings.Join(c.BuilderNames, ";")),
+	properties := []string{}
+	for _, o := range Options {
+		properties = append(properties, o.ToTryBotProperties(c)...)
 	}
+	return properties
 }
 
 // tag::string_is_command[]
 // StringIsCommand returns true if the given string is a valid /buildbot command.
 func StringIsCommand(s string) bool {
-	// force=yes|no : Can be used to allow for PRs to be build even when
-	// they are closed or when a check run for the exact same SHA has been
-	// run already.
-	return regexp.MustCompile(buildRegexPattern()).MatchString(s)
+	_, err := parse(s)
+	return err == nil
 }
 
-// end::string_is_command[]
+// LooksLikeCommand returns true if the given string starts with /buildbot. Use
+// it to find out if a user meant to write a command, even if FromString fails
+// to parse it.
+func LooksLikeCommand(s string) bool {
+	fields := strings.Fields(s)
+	return len(fields) > 0 && fields[0] == BuildbotCommand
+}
 
-// TODO(kwk): implement fromTryBotPropertyArray() *Command
+// end::string_is_command[]
 
-// toMap converts the command into a map
-func (c Command) toMap() map[string]interface{} {
-	return map[string]interface{}{
-		CommandOptionMandatory: c.IsMandatory,
-		CommandOptionBuilder:   c.BuilderNames,
-		CommandOptionForce:     c.Force,
+// FromProperties creates a command from the build properties that Buildbot
+// reports back for a build that was started with ToTryBotPropertyArray().
+// Options without a property keep their defaults.
+func FromProperties(properties map[string]string) (*Command, error) {
+	cmd := New()
+	for _, o := range Options {
+		if o.PropertyName == "" || o.Derive != nil {
+			continue
+		}
+		if o.Type == OptionTypeMap {
+			m := map[string]string{}
+			for name, value := range properties {
+				if key := strings.TrimPrefix(name, o.PropertyName); key != name && key != "" {
+					m[key] = value
+				}
+			}
+			if len(m) == 0 {
+				continue
+			}
+			if err := o.Set(cmd, m); err != nil {
+				return nil, fmt.Errorf("failed to read %s* properties: %w", o.PropertyName, err)
+			}
+			continue
+		}
+		value, ok := properties[o.PropertyName]
+		if !ok {
+			continue
+		}
+		var err error
+		if o.Type == OptionTypeList {
+			elements := []string{}
+			if value != "" {
+				elements = strings.Split(value, ";")
+			}
+			err = o.Set(cmd, elements)
+		} else {
+			err = o.Set(cmd, value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read property %s: %w", o.PropertyName, err)
+		}
 	}
+	return cmd, nil
 }
 
 // valueIsTrue returns true if a given value as is a string and its lowercase
@@ -153,71 +379,6 @@ func valueIsFalse(i interface{}) bool {
 	return s == "false" || s == "f" || s == "no" || s == "n" || s == "0"
 }
 
-// tag::command_regex[]
-// buildRegexPattern returns the regex pattern to match a string against a
-// /buildbot command
-func buildRegexPattern() string {
-	tfOptions := `(yes|no|true|false|f|t|y|n|0|1)`
-	mandatoryOption := fmt.Sprintf(`%s=%s`, CommandOptionMandatory, tfOptions)
-	forceOption := fmt.Sprintf(`%s=%s`, CommandOptionForce, tfOptions)
-	builderOption := fmt.Sprintf(`%s=(\w+)`, CommandOptionBuilder)
-	return fmt.Sprintf(`^%s(\s+|%s|%s|%s)*$`, BuildbotCommand, mandatoryOption, forceOption, builderOption)
-}
-
-// end::command_regex[]
-
-// stripPrefix returns anything after the first appearance of "/buildbot" in a
-// command string with trimmed spaces
-func stripPrefix(s string) string {
-	_, after, found := strings.Cut(s, BuildbotCommand)
-	if found {
-		after = strings.TrimSpace(after)
-		return after
-	}
-	return s
-}
-
-// parseIntoMap returns a map with case insensitive options that can override or
-// extend each other The options are parsed left-to-right. For overwritable
-// options the last option given wins. For example:
-//
-//	/buildbot mandatory=yes builder=foo mandatory=no builder=bar
-//
-// Will return map{mandatory:no, builder:[]string{"foo", "bar"}}
-func parseIntoMap(s string) (map[string]interface{}, error) {
-	if !StringIsCommand(s) {
-		return nil, fmt.Errorf("string is no valid command: %s", s)
-	}
-
-	arguments := map[string]interface{}{}
-	argString := stripPrefix(s)
-	kvList := strings.Split(argString, " ")
-	if len(kvList) == 1 && kvList[0] == "" {
-		return arguments, nil
-	}
-
-	for _, kvStr := range kvList {
-		kv := strings.SplitN(kvStr, "=", 2)
-		if len(kv) != 2 {
-			return nil, fmt.Errorf("invalid comment: %s", s)
-		}
-		key := strings.ToLower(kv[0])
-		value := kv[1]
-		switch key {
-		case "builder":
-			if err := makeCaseInsensitiveStringList(arguments, key, value); err != nil {
-				return nil, err
-			}
-			if builderNames, ok := arguments[key].([]string); ok {
-				arguments[key] = removeDuplicatesInPlace(builderNames)
-			}
-		default:
-			arguments[key] = value
-		}
-	}
-	return arguments, nil
-}
-
 // See https://hackernoon.com/how-to-remove-duplicates-in-go-slices
 func removeDuplicatesInPlace(elements []string) []string {
 	// if there are 0 or 1 items we return the slice itself.
@@ -241,19 +402,3 @@ func removeDuplicatesInPlace(elements []string) []string {
 
 	return elements[:uniqPointer+1]
 }
-
-func makeCaseInsensitiveStringList(m map[string]interface{}, key string, value interface{}) error {
-	s := fmt.Sprintf("%v", value)
-	if _, ok := m[key]; ok {
-		elements, ok := m[key].([]string)
-		if !ok {
-			return fmt.Errorf("list of '%s' is not a string slice: %s", key, s)
-		}
-		elements = append(elements, s)
-		// sort.Strings(elements)
-		m[key] = elements
-	} else {
-		m[key] = []string{s}
-	}
-	return nil
-}
@@ -2,6 +2,7 @@ package command
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -11,9 +12,11 @@ func TestNew(t *testing.T) {
 		want *Command
 	}{
 		{"default", &Command{
+			Subcommand:    SubcommandBuild,
 			IsMandatory:   true,
 			BuilderNames:  []string{},
 			CommentAuthor: "",
+			Priority:      PriorityNormal,
 		}},
 	}
 	for _, tt := range tests {
@@ -187,6 +190,83 @@ func TestFromString(t *testing.T) {
 			}(),
 			false,
 		},
+		{
+			"selectors",
+			"/buildbot os=linux arch=aarch64 os-distro=fedora os-ver=38",
+			func() *Command {
+				c := New()
+				c.Os = "linux"
+				c.Arch = "aarch64"
+				c.OsDistro = "fedora"
+				c.OsVer = "38"
+				return c
+			}(),
+			false,
+		},
+		{
+			"explicit build",
+			"/buildbot build force=yes",
+			func() *Command {
+				c := New()
+				c.Force = true
+				return c
+			}(),
+			false,
+		},
+		{
+			"cancel",
+			"/buildbot cancel builder=hello os=linux",
+			func() *Command {
+				c := New()
+				c.Subcommand = SubcommandCancel
+				c.BuilderNames = []string{"hello"}
+				c.Os = "linux"
+				return c
+			}(),
+			false,
+		},
+		{
+			"status",
+			"/buildbot status",
+			func() *Command {
+				c := New()
+				c.Subcommand = SubcommandStatus
+				return c
+			}(),
+			false,
+		},
+		{
+			"retry",
+			"/buildbot retry",
+			func() *Command {
+				c := New()
+				c.Subcommand = SubcommandRetry
+				return c
+			}(),
+			false,
+		},
+		{
+			"help",
+			"/buildbot help",
+			func() *Command {
+				c := New()
+				c.Subcommand = SubcommandHelp
+				return c
+			}(),
+			false,
+		},
+		{
+			"option not accepted by subcommand",
+			"/buildbot cancel force=yes",
+			nil, // not important because we expect an error
+			true,
+		},
+		{
+			"unknown subcommand",
+			"/buildbot restart",
+			nil, // not important because we expect an error
+			true,
+		},
 		{
 			"t14",
 			"/buildbot builder=",
@@ -220,30 +300,6 @@ func TestFromString(t *testing.T) {
 	}
 }
 
-func TestStripPrefix(t *testing.T) {
-	tests := []struct {
-		name           string
-		input          string
-		expectedOutput string
-	}{
-		{"t1", "/buildbot foo=bar arg1=arg2 ", "foo=bar arg1=arg2"},
-		{"t2", "@kwk's /buildbot foo=bar arg1=arg2 ", "foo=bar arg1=arg2"},
-		{"t3", "@kwk's /buildbot", ""},
-		{"t4", "/buildbot", ""},
-		{"t5", "buildbot", "buildbot"},
-		{"t6", "foobar", "foobar"},
-		{"t7", "foo=bar", "foo=bar"},
-		{"t8", "", ""},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := stripPrefix(tt.input); got != tt.expectedOutput {
-				t.Errorf("StripPrefix() = %v, want %v", got, tt.expectedOutput)
-			}
-		})
-	}
-}
-
 func TestStringIsCommand(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -259,6 +315,11 @@ func TestStringIsCommand(t *testing.T) {
 		{"t7", "foobar", false},
 		{"t8", "foo=bar", false},
 		{"t9", "", false},
+		{"t10", "/buildbot os=linux arch=x86_64 os-ver=9.2", true},
+		{"t11", "/buildbot os=", false},
+		{"t12", "/buildbot cancel builder=foo", true},
+		{"t13", "/buildbot help", true},
+		{"t14", "/buildbot helpme", false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -269,78 +330,6 @@ func TestStringIsCommand(t *testing.T) {
 	}
 }
 
-func TestCommand_ToMap(t *testing.T) {
-	type fields struct {
-		IsMandatory   bool
-		BuilderNames  []string
-		CommentAuthor string
-		Force         bool
-	}
-	tests := []struct {
-		name   string
-		fields fields
-		want   map[string]interface{}
-	}{
-		{
-			"t1",
-			fields{
-				IsMandatory:   true,
-				BuilderNames:  []string{"foo", "bar"},
-				CommentAuthor: "johndoe",
-				Force:         false,
-			},
-			map[string]interface{}{
-				CommandOptionBuilder:   []string{"foo", "bar"},
-				CommandOptionMandatory: true,
-				CommandOptionForce:     false,
-			},
-		},
-		{
-			"t2",
-			fields{
-				IsMandatory:   false,
-				BuilderNames:  []string{"hello", "world"},
-				CommentAuthor: "janedoe",
-				Force:         true,
-			},
-			map[string]interface{}{
-				CommandOptionBuilder:   []string{"hello", "world"},
-				CommandOptionMandatory: false,
-				CommandOptionForce:     true,
-			},
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			c := Command{
-				IsMandatory:   tt.fields.IsMandatory,
-				BuilderNames:  tt.fields.BuilderNames,
-				CommentAuthor: tt.fields.CommentAuthor,
-				Force:         tt.fields.Force,
-			}
-			if got := c.toMap(); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("Command.ToMap() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
-func TestBuildRegexPattern(t *testing.T) {
-	tests := []struct {
-		name string
-		want string
-	}{
-		{"default", `^/buildbot(\s+|mandatory=(yes|no|true|false|f|t|y|n|0|1)|force=(yes|no|true|false|f|t|y|n|0|1)|builder=(\w+))*$`},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := buildRegexPattern(); got != tt.want {
-				t.Errorf("BuildRegexPattern() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
 func TestValueIsTrue(t *testing.T) {
 	tests := []struct {
 		name string
@@ -410,6 +399,7 @@ func TestCommand_ToGithubCheckNameString(t *testing.T) {
 		IsMandatory   bool
 		BuilderNames  []string
 		CommentAuthor string
+		Trigger       string
 		Force         bool
 	}
 	tests := []struct {
@@ -437,6 +427,16 @@ func TestCommand_ToGithubCheckNameString(t *testing.T) {
 			},
 			"@janedoe /buildbot mandatory=false force=true builder=[hello world]",
 		},
+		{
+			"automatic",
+			fields{
+				IsMandatory:   true,
+				BuilderNames:  []string{"foo"},
+				CommentAuthor: "janedoe",
+				Trigger:       TriggerAutomatic,
+			},
+			"[automatic] /buildbot mandatory=true force=false builder=[foo]",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -444,6 +444,7 @@ func TestCommand_ToGithubCheckNameString(t *testing.T) {
 				IsMandatory:   tt.fields.IsMandatory,
 				BuilderNames:  tt.fields.BuilderNames,
 				CommentAuthor: tt.fields.CommentAuthor,
+				Trigger:       tt.fields.Trigger,
 				Force:         tt.fields.Force,
 			}
 			if got := c.ToGithubCheckNameString(); got != tt.want {
@@ -453,108 +454,72 @@ func TestCommand_ToGithubCheckNameString(t *testing.T) {
 	}
 }
 
-func TestParseIntoMap(t *testing.T) {
-	tests := []struct {
-		name    string
-		input   string
-		want    map[string]interface{}
-		wantErr bool
-	}{
-		{
-			"t1",
-			"/buildbot",
-			map[string]interface{}{},
-			false,
-		},
-		{
-			"t2",
-			"/buildbot force=true",
-			map[string]interface{}{
-				CommandOptionForce: "true",
-			},
-			false,
-		},
-		{
-			"t3",
-			"/buildbot force=false",
-			map[string]interface{}{
-				CommandOptionForce: "false",
-			},
-			false,
-		},
-		{
-			"t4",
-			"/buildbot mandatory=true",
-			map[string]interface{}{
-				CommandOptionMandatory: "true",
-			},
-			false,
-		},
-		{
-			"t5",
-			"/buildbot mandatory=false",
-			map[string]interface{}{
-				CommandOptionMandatory: "false",
-			},
-			false,
-		},
-		{
-			"t6",
-			"/buildbot builder=hello builder=world",
-			map[string]interface{}{
-				CommandOptionBuilder: []string{"hello", "world"},
-			},
-			false,
-		},
-		{
-			"t7",
-			"/buildbot builder=hello force=true builder=world mandatory=false",
-			map[string]interface{}{
-				CommandOptionMandatory: "false",
-				CommandOptionForce:     "true",
-				CommandOptionBuilder:   []string{"hello", "world"},
-			},
-			false,
-		},
-		{
-			"t8",
-			"/buildbot builder=hello force=false builder=world mandatory=true",
-			map[string]interface{}{
-				CommandOptionMandatory: "true",
-				CommandOptionForce:     "false",
-				CommandOptionBuilder:   []string{"hello", "world"},
-			},
-			false,
-		},
-		{
-			"t9",
-			"/buildbot foo=bar",
-			nil, // output not relevant because of error
-			true,
-		},
-		{
-			"t10",
-			"/buildbot builder=",
-			nil, // output not relevant because of error
-			true,
-		},
-		{
-			"t11",
-			"/buildbot force=",
-			nil, // output not relevant because of error
-			true,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got, err := parseIntoMap(tt.input)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("ParseIntoMap() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("ParseIntoMap() = %v, want %v", got, tt.want)
-			}
-		})
+// propertyMap turns the "--property=name=value" arguments for "buildbot try"
+// into the name/value map that Buildbot reports back.
+func propertyMap(t *testing.T, properties []string) map[string]string {
+	m := map[string]string{}
+	for _, p := range properties {
+		name, value, found := strings.Cut(strings.TrimPrefix(p, "--property="), "=")
+		if !found {
+			t.Fatalf("invalid property argument: %s", p)
+		}
+		m[name] = value
 	}
+	return m
+}
+
+func TestFromProperties(t *testing.T) {
+	t.Run("round-trip", func(t *testing.T) {
+		tests := []string{
+			"/buildbot",
+			"/buildbot mandatory=no force=yes builder=foo builder=bar",
+			"/buildbot builder=foo os=linux arch=aarch64 os-distro=fedora os-ver=38",
+			"/buildbot builder=foo prop:test_filter=foo prop:cmake_build_type=Release",
+			"/buildbot builder=foo timeout=2h priority=low",
+		}
+		for _, input := range tests {
+			t.Run(input, func(t *testing.T) {
+				want, err := FromString(input)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want.CommentAuthor = "johndoe"
+				got, err := FromProperties(propertyMap(t, want.ToTryBotPropertyArray()))
+				if err != nil {
+					t.Fatalf("FromProperties() error = %v", err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("FromProperties() = %+v, want %+v", got, want)
+				}
+			})
+		}
+	})
+	t.Run("trigger", func(t *testing.T) {
+		want := New()
+		want.CommentAuthor = "johndoe"
+		want.Trigger = TriggerLabel
+		want.Label = "buildbot:builder=linux"
+		got, err := FromProperties(propertyMap(t, want.ToTryBotPropertyArray()))
+		if err != nil {
+			t.Fatalf("FromProperties() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("FromProperties() = %+v, want %+v", got, want)
+		}
+	})
+	t.Run("missing properties keep defaults", func(t *testing.T) {
+		got, err := FromProperties(map[string]string{})
+		if err != nil {
+			t.Fatalf("FromProperties() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, New()) {
+			t.Errorf("FromProperties() = %+v, want %+v", got, New())
+		}
+	})
+	t.Run("invalid property", func(t *testing.T) {
+		_, err := FromProperties(map[string]string{"command_force": "maybe"})
+		if err == nil {
+			t.Errorf("FromProperties() expected an error")
+		}
+	})
 }
@@ -15,3 +15,26 @@ func ExampleCommand_ToGithubCheckNameString() {
 	fmt.Println(c.ToGithubCheckNameString())
 	// Output: @user /buildbot mandatory=false force=true builder=[linux mac windows]
 }
+
+func ExampleCommand_ToTryBotPropertyArray() {
+	c, err := command.FromString("/buildbot builder=linux prop:test_filter=foo prop:cmake_build_type=Release timeout=2h priority=high")
+	if err != nil {
+		panic(err)
+	}
+	c.CommentAuthor = "user"
+	fmt.Println(c.ToGithubCheckNameString())
+	for _, p := range c.ToTryBotPropertyArray() {
+		fmt.Println(p)
+	}
+	// Output:
+	// @user /buildbot mandatory=true force=false builder=[linux] prop:cmake_build_type=Release prop:test_filter=foo
+	// --property=command_author=user
+	// --property=build_request_priority=10
+	// --property=command_is_mandatory=true
+	// --property=command_force=false
+	// --property=command_builders=linux
+	// --property=user_cmake_build_type=Release
+	// --property=user_test_filter=foo
+	// --property=command_timeout=2h0m0s
+	// --property=command_priority=high
+}
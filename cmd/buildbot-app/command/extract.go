@@ -0,0 +1,59 @@
+package command
+
+import (
+	"strings"
+)
+
+// CommandLine is a line of a comment that holds a /buildbot command.
+type CommandLine struct {
+	// Number of the line in the comment (starting at 1)
+	Number int
+	// The line without surrounding whitespace
+	Text string
+}
+
+// tag::extract_command_lines[]
+// ExtractCommandLines returns all lines of a (markdown) comment that start with
+// /buildbot. Lines in quoted reply blocks (e.g. "> /buildbot") and in fenced
+// code blocks (``` or ~~~) are skipped because they don't express the intent
+// of the comment's author to run a command.
+func ExtractCommandLines(body string) []CommandLine {
+	commandLines := []CommandLine{}
+	fence := ""
+	for i, line := range strings.Split(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			// A fence is closed by at least as many fence characters as it
+			// was opened with.
+			if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+				fence = ""
+			}
+			continue
+		}
+		if f := openingFence(trimmed); f != "" {
+			fence = f
+			continue
+		}
+		if strings.HasPrefix(trimmed, ">") {
+			continue
+		}
+		if LooksLikeCommand(trimmed) {
+			commandLines = append(commandLines, CommandLine{Number: i + 1, Text: trimmed})
+		}
+	}
+	return commandLines
+}
+
+// end::extract_command_lines[]
+
+// openingFence returns the fence (e.g. "```" or "~~~~") that the given line
+// opens or an empty string if the line doesn't open a fenced code block.
+func openingFence(line string) string {
+	for _, c := range []string{"`", "~"} {
+		n := len(line) - len(strings.TrimLeft(line, c))
+		if n >= 3 {
+			return strings.Repeat(c, n)
+		}
+	}
+	return ""
+}
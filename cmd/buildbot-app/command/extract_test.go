@@ -0,0 +1,55 @@
+package command
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractCommandLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []CommandLine
+	}{
+		{"empty", "", []CommandLine{}},
+		{"no command", "LGTM, thanks!", []CommandLine{}},
+		{"only command", "/buildbot", []CommandLine{{1, "/buildbot"}}},
+		{
+			"prose before command",
+			"Looks good to me.\r\nLet's see what the builders say:\r\n  /buildbot builder=foo  \r\n",
+			[]CommandLine{{3, "/buildbot builder=foo"}},
+		},
+		{
+			"several commands",
+			"/buildbot builder=foo\n\n/buildbot os=linux\nThanks!\n/buildbot status",
+			[]CommandLine{{1, "/buildbot builder=foo"}, {3, "/buildbot os=linux"}, {5, "/buildbot status"}},
+		},
+		{
+			"command in the middle of a line",
+			"Please run /buildbot builder=foo",
+			[]CommandLine{},
+		},
+		{
+			"quoted reply",
+			"> /buildbot builder=foo\n>> /buildbot builder=bar\n/buildbot builder=baz",
+			[]CommandLine{{3, "/buildbot builder=baz"}},
+		},
+		{
+			"fenced code",
+			"```\n/buildbot builder=foo\n```\n~~~~text\n/buildbot builder=bar\n~~~\n/buildbot builder=still-in-fence\n~~~~\n/buildbot builder=baz",
+			[]CommandLine{{9, "/buildbot builder=baz"}},
+		},
+		{
+			"unterminated fence",
+			"/buildbot builder=foo\n```\n/buildbot builder=bar",
+			[]CommandLine{{1, "/buildbot builder=foo"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExtractCommandLines(tt.input); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExtractCommandLines() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
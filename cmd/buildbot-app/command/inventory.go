@@ -0,0 +1,168 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// tag::inventory[]
+// BuilderInfo describes a builder and the attributes of the workers it runs
+// on. The attribute names are the same as the worker properties that Buildbot
+// reports back to us in its HTTP status push (see
+// buildbot_http_status_push.Properties).
+type BuilderInfo struct {
+	Name     string `json:"name"`
+	Os       string `json:"os,omitempty"`
+	Arch     string `json:"arch,omitempty"`
+	OsDistro string `json:"os-distro,omitempty"`
+	OsVer    string `json:"os-ver,omitempty"`
+}
+
+// Inventory is the list of builders that attribute selectors such as os=linux
+// or arch=aarch64 are resolved against.
+type Inventory []BuilderInfo
+
+// end::inventory[]
+
+// SelectorAttributes is the list of attributes by which builders can be
+// selected in a command.
+var SelectorAttributes = []string{
+	CommandOptionOs,
+	CommandOptionArch,
+	CommandOptionOsDistro,
+	CommandOptionOsVer,
+}
+
+// InventoryFromFile reads an inventory from a JSON file that contains an
+// array of BuilderInfo objects.
+func InventoryFromFile(path string) (Inventory, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory file: %w", err)
+	}
+	inv := Inventory{}
+	if err := json.Unmarshal(data, &inv); err != nil {
+		return nil, fmt.Errorf("failed to parse inventory file %s: %w", path, err)
+	}
+	return inv, nil
+}
+
+// Attribute returns the value of the given attribute (e.g. "os") or an empty
+// string if the attribute is unknown or not set.
+func (b BuilderInfo) Attribute(name string) string {
+	switch name {
+	case CommandOptionOs:
+		return b.Os
+	case CommandOptionArch:
+		return b.Arch
+	case CommandOptionOsDistro:
+		return b.OsDistro
+	case CommandOptionOsVer:
+		return b.OsVer
+	}
+	return ""
+}
+
+// Values returns a sorted list of all distinct values that the given attribute
+// has in the inventory.
+func (inv Inventory) Values(attribute string) []string {
+	values := []string{}
+	for _, b := range inv {
+		if v := b.Attribute(attribute); v != "" {
+			values = append(values, v)
+		}
+	}
+	return removeDuplicatesInPlace(values)
+}
+
+// Match returns the sorted names of all builders whose attributes equal all of
+// the given selectors. A selector value can be a comma separated list of which
+// any value matches (e.g. os=linux,macos).
+func (inv Inventory) Match(selectors map[string]string) []string {
+	names := []string{}
+	for _, b := range inv {
+		matches := true
+		for attr, want := range selectors {
+			if !selectorMatches(want, b.Attribute(attr)) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			names = append(names, b.Name)
+		}
+	}
+	return removeDuplicatesInPlace(names)
+}
+
+// SelectorError is returned by ResolveBuilderNames when a selector names a
+// value that no builder has or when no builder satisfies all selectors at once.
+type SelectorError struct {
+	// Selectors as given in the command
+	Selectors map[string]string
+	// Available maps each selector attribute to the values that are present in
+	// the inventory.
+	Available map[string][]string
+}
+
+func (e *SelectorError) Error() string {
+	return fmt.Sprintf("no builder matches %s", selectorString(e.Selectors))
+}
+
+// tag::resolve_builder_names[]
+// ResolveBuilderNames adds the names of all builders in the inventory that
+// match the command's attribute selectors (e.g. os=linux arch=aarch64) to the
+// command's builder names. The builder names stay sorted and free of
+// duplicates. If the command has no selectors, nothing happens. A
+// *SelectorError is returned if the selectors cannot be satisfied.
+func (c *Command) ResolveBuilderNames(inv Inventory) error {
+	selectors := c.Selectors()
+	if len(selectors) == 0 {
+		return nil
+	}
+	matches := inv.Match(selectors)
+	if len(matches) == 0 {
+		available := map[string][]string{}
+		for _, attr := range SelectorAttributes {
+			available[attr] = inv.Values(attr)
+		}
+		return &SelectorError{Selectors: selectors, Available: available}
+	}
+	c.BuilderNames = removeDuplicatesInPlace(append(c.BuilderNames, matches...))
+	return nil
+}
+
+// end::resolve_builder_names[]
+
+// Selectors returns the attribute selectors that were set on the command.
+func (c Command) Selectors() map[string]string {
+	selectors := map[string]string{}
+	for attr, value := range map[string]string{
+		CommandOptionOs:       c.Os,
+		CommandOptionArch:     c.Arch,
+		CommandOptionOsDistro: c.OsDistro,
+		CommandOptionOsVer:    c.OsVer,
+	} {
+		if value != "" {
+			selectors[attr] = value
+		}
+	}
+	return selectors
+}
+
+// selectorString returns the selectors in a stable "key=value key=value" form.
+func selectorString(selectors map[string]string) string {
+	keys := make([]string, 0, len(selectors))
+	for k := range selectors {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kvs := make([]string, len(keys))
+	for i, k := range keys {
+		kvs[i] = fmt.Sprintf("%s=%s", k, selectors[k])
+	}
+	return strings.Join(kvs, " ")
+}
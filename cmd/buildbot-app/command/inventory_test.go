@@ -0,0 +1,100 @@
+package command
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func testInventory() Inventory {
+	return Inventory{
+		{Name: "linux-x86_64", Os: "linux", Arch: "x86_64", OsDistro: "fedora", OsVer: "38"},
+		{Name: "linux-aarch64", Os: "linux", Arch: "aarch64", OsDistro: "fedora", OsVer: "38"},
+		{Name: "linux-aarch64-ubuntu", Os: "linux", Arch: "aarch64", OsDistro: "ubuntu", OsVer: "22.04"},
+		{Name: "macos-aarch64", Os: "macos", Arch: "aarch64"},
+		{Name: "windows-x86_64", Os: "windows", Arch: "x86_64"},
+	}
+}
+
+func TestInventory_Values(t *testing.T) {
+	tests := []struct {
+		name      string
+		attribute string
+		want      []string
+	}{
+		{"os", CommandOptionOs, []string{"linux", "macos", "windows"}},
+		{"arch", CommandOptionArch, []string{"aarch64", "x86_64"}},
+		{"os-distro", CommandOptionOsDistro, []string{"fedora", "ubuntu"}},
+		{"os-ver", CommandOptionOsVer, []string{"22.04", "38"}},
+		{"unknown", "gpu", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := testInventory().Values(tt.attribute); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Inventory.Values() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommand_ResolveBuilderNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{"no selectors", "/buildbot builder=foo", []string{"foo"}, false},
+		{"os", "/buildbot os=macos", []string{"macos-aarch64"}, false},
+		{"os and arch", "/buildbot os=linux arch=aarch64", []string{"linux-aarch64", "linux-aarch64-ubuntu"}, false},
+		{"all attributes", "/buildbot os=linux arch=aarch64 os-distro=ubuntu os-ver=22.04", []string{"linux-aarch64-ubuntu"}, false},
+		{"merged with builders", "/buildbot builder=zzz os=windows builder=foo", []string{"foo", "windows-x86_64", "zzz"}, false},
+		{"list of values", "/buildbot os=macos,windows", []string{"macos-aarch64", "windows-x86_64"}, false},
+		{"unknown value", "/buildbot os=solaris", nil, true},
+		{"unsatisfiable", "/buildbot os=windows arch=aarch64", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := FromString(tt.input)
+			if err != nil {
+				t.Fatalf("FromString() error = %v", err)
+			}
+			err = c.ResolveBuilderNames(testInventory())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ResolveBuilderNames() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				var selectorErr *SelectorError
+				if !errors.As(err, &selectorErr) {
+					t.Fatalf("ResolveBuilderNames() error = %T, want *SelectorError", err)
+				}
+				if want := []string{"linux", "macos", "windows"}; !reflect.DeepEqual(selectorErr.Available[CommandOptionOs], want) {
+					t.Errorf("SelectorError.Available[os] = %v, want %v", selectorErr.Available[CommandOptionOs], want)
+				}
+				return
+			}
+			if !reflect.DeepEqual(c.BuilderNames, tt.want) {
+				t.Errorf("ResolveBuilderNames() = %v, want %v", c.BuilderNames, tt.want)
+			}
+		})
+	}
+}
+
+func TestInventoryFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	data := `[{"name": "foo", "os": "linux", "arch": "x86_64", "os-distro": "fedora", "os-ver": "38"}]`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := InventoryFromFile(path)
+	if err != nil {
+		t.Fatalf("InventoryFromFile() error = %v", err)
+	}
+	want := Inventory{{Name: "foo", Os: "linux", Arch: "x86_64", OsDistro: "fedora", OsVer: "38"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("InventoryFromFile() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,95 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tag::matrix[]
+// Matrix expands attribute selectors with comma separated values (e.g.
+// os=linux,macos arch=x86_64,aarch64) into the cartesian product of commands
+// that have exactly one value per selector. All other fields are copied into
+// every command. A command without such selectors is returned as the only
+// element.
+func (c Command) Matrix() []*Command {
+	cells := []*Command{c.copy()}
+	for _, o := range Options {
+		if !isSelector(o.Name) || o.IsEmpty(c) {
+			continue
+		}
+		values := splitSelectorValues(o.Get(c).(string))
+		expanded := make([]*Command, 0, len(cells)*len(values))
+		for _, cell := range cells {
+			for _, value := range values {
+				newCell := cell.copy()
+				if err := o.Set(newCell, value); err != nil {
+					// The value was already validated by the parser
+					panic(err)
+				}
+				expanded = append(expanded, newCell)
+			}
+		}
+		cells = expanded
+	}
+	return cells
+}
+
+// end::matrix[]
+
+// IsMatrix returns true if Matrix() expands the command into more than one
+// command.
+func (c Command) IsMatrix() bool {
+	for attr, value := range c.Selectors() {
+		if isSelector(attr) && len(splitSelectorValues(value)) > 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// copy returns a deep copy of the command.
+func (c Command) copy() *Command {
+	c.BuilderNames = append([]string{}, c.BuilderNames...)
+	return &c
+}
+
+// isSelector returns true if the given option name is an attribute selector.
+func isSelector(name string) bool {
+	for _, attr := range SelectorAttributes {
+		if attr == name {
+			return true
+		}
+	}
+	return false
+}
+
+// splitSelectorValues splits a comma separated list of selector values.
+func splitSelectorValues(s string) []string {
+	values := strings.Split(s, ",")
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+	return values
+}
+
+// selectorMatches returns true if value is one of the comma separated
+// selector values.
+func selectorMatches(selector string, value string) bool {
+	for _, v := range splitSelectorValues(selector) {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// validateSelectorValues makes sure that no value in a comma separated list
+// of selector values is empty.
+func validateSelectorValues(value interface{}) error {
+	for _, v := range splitSelectorValues(value.(string)) {
+		if v == "" {
+			return fmt.Errorf("empty value in list %q", value)
+		}
+	}
+	return nil
+}
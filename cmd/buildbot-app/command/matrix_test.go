@@ -0,0 +1,79 @@
+package command
+
+import (
+	"testing"
+)
+
+func TestCommand_Matrix(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			"no selectors",
+			"/buildbot builder=foo",
+			[]string{"@johndoe /buildbot mandatory=true force=false builder=[foo]"},
+		},
+		{
+			"single values",
+			"/buildbot os=linux arch=x86_64",
+			[]string{"@johndoe /buildbot mandatory=true force=false os=linux arch=x86_64"},
+		},
+		{
+			"cartesian product",
+			"/buildbot os=linux,macos arch=x86_64,aarch64 mandatory=no",
+			[]string{
+				"@johndoe /buildbot mandatory=false force=false os=linux arch=x86_64",
+				"@johndoe /buildbot mandatory=false force=false os=linux arch=aarch64",
+				"@johndoe /buildbot mandatory=false force=false os=macos arch=x86_64",
+				"@johndoe /buildbot mandatory=false force=false os=macos arch=aarch64",
+			},
+		},
+		{
+			"one list",
+			"/buildbot os=linux arch=x86_64,aarch64",
+			[]string{
+				"@johndoe /buildbot mandatory=true force=false os=linux arch=x86_64",
+				"@johndoe /buildbot mandatory=true force=false os=linux arch=aarch64",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := FromString(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cmd.CommentAuthor = "johndoe"
+			cells := cmd.Matrix()
+			if len(cells) != len(tt.want) {
+				t.Fatalf("Command.Matrix() returned %d commands, want %d", len(cells), len(tt.want))
+			}
+			for i, cell := range cells {
+				if got := cell.ToGithubCheckNameString(); got != tt.want[i] {
+					t.Errorf("cell %d: ToGithubCheckNameString() = %q, want %q", i, got, tt.want[i])
+				}
+			}
+			if got, want := cmd.IsMatrix(), len(tt.want) > 1; got != want {
+				t.Errorf("Command.IsMatrix() = %t, want %t", got, want)
+			}
+		})
+	}
+	t.Run("cells don't share builders", func(t *testing.T) {
+		cmd, err := FromString("/buildbot os=linux,macos")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cells := cmd.Matrix()
+		cells[0].BuilderNames = append(cells[0].BuilderNames, "foo")
+		if len(cells[1].BuilderNames) != 0 || len(cmd.BuilderNames) != 0 {
+			t.Errorf("modifying one cell changed another command")
+		}
+	})
+	t.Run("empty value in list", func(t *testing.T) {
+		if _, err := FromString("/buildbot os=linux,"); err == nil {
+			t.Errorf("FromString() expected an error for an empty value")
+		}
+	})
+}
@@ -0,0 +1,505 @@
+package command
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An OptionType tells how the value of an option is parsed and stored.
+type OptionType string
+
+const (
+	// OptionTypeBool is stored in a bool field and accepts values like yes,
+	// no, true or false.
+	OptionTypeBool OptionType = "bool"
+
+	// OptionTypeString is stored in a string field.
+	OptionTypeString OptionType = "string"
+
+	// OptionTypeList is stored in a []string field. The option can be given
+	// multiple times and the resulting list is sorted and has no duplicates.
+	OptionTypeList OptionType = "list"
+
+	// OptionTypeInt is stored in an int field.
+	OptionTypeInt OptionType = "int"
+
+	// OptionTypeDuration is stored in a time.Duration field and accepts
+	// values like 90m or 2h.
+	OptionTypeDuration OptionType = "duration"
+
+	// OptionTypeMap is stored in a map[string]string field. The option is
+	// namespaced: it is written as name:key=value (e.g. prop:test_filter=foo)
+	// and can be given multiple times with different keys. For try-bot
+	// properties the PropertyName is used as a prefix for every key.
+	OptionTypeMap OptionType = "map"
+)
+
+// tag::option[]
+// An Option declares everything there is to know about a command option. The
+// parser, the check run name, the try-bot properties and the help text are all
+// derived from these declarations.
+type Option struct {
+	// Name as written in a command (e.g. "mandatory")
+	Name string
+	Type OptionType
+	// Field is the name of the Command struct field that holds the value. The
+	// field's type must match the option's Type.
+	Field string
+	// Derive computes the value from other fields of the Command for options
+	// without a Field of their own. Such options are only passed to "buildbot
+	// try" and never read back from properties.
+	Derive func(c Command) string
+	// Subcommands that the option can be written with. Options without
+	// subcommands can't be written in a command and are set by the app (e.g.
+	// the comment author).
+	Subcommands []Subcommand
+	// Default value as a user would write it. An empty string means the zero
+	// value of the field.
+	Default string
+	// Choices restricts the allowed values if not empty.
+	Choices []string
+	// Validate is an optional check that is run on the parsed value.
+	Validate func(value interface{}) error
+	// PropertyName is the name of the Buildbot property in which the value is
+	// passed to "buildbot try". An empty string means the value isn't passed.
+	PropertyName string
+	// OmitEmpty skips the option in properties and maps when it has its zero
+	// value.
+	OmitEmpty bool
+	// InCheckName makes the value part of the check run name. Commands with
+	// the same check run name are considered the same build. Options that
+	// also have OmitEmpty set are left out of the name when empty.
+	InCheckName bool
+	// Values describes the allowed values in the help text. If empty, it is
+	// derived from the Type and Choices.
+	Values      string
+	Description string
+}
+
+// end::option[]
+
+// selectingSubcommands are the subcommands whose options select builds or
+// builders.
+var selectingSubcommands = []Subcommand{SubcommandBuild, SubcommandCancel, SubcommandRetry}
+
+// tag::options[]
+// Options lists all options of a command. The order of this list is the order
+// in which options appear in check run names, try-bot properties and the help
+// text.
+var Options = []Option{
+	{
+		Name:         "author",
+		Type:         OptionTypeString,
+		Field:        "CommentAuthor",
+		PropertyName: PropertyCommentAuthor,
+		Description:  "The user's GitHub login that issued the command.",
+	},
+	{
+		Name:         "build-request-priority",
+		Type:         OptionTypeInt,
+		Derive:       func(c Command) string { return strconv.Itoa(c.BuildRequestPriority()) },
+		PropertyName: PropertyBuildRequestPriority,
+		Description:  "The Buildbot build request priority of the priority option.",
+	},
+	{
+		Name:         "trigger",
+		Type:         OptionTypeString,
+		Field:        "Trigger",
+		PropertyName: PropertyTrigger,
+		OmitEmpty:    true,
+		Description:  "What started a command that wasn't written in a comment.",
+	},
+	{
+		Name:         "label",
+		Type:         OptionTypeString,
+		Field:        "Label",
+		PropertyName: PropertyLabel,
+		OmitEmpty:    true,
+		Description:  "The pull request label that started the command.",
+	},
+	{
+		Name:         CommandOptionMandatory,
+		Type:         OptionTypeBool,
+		Field:        "IsMandatory",
+		Subcommands:  []Subcommand{SubcommandBuild},
+		Default:      "yes",
+		PropertyName: "command_is_mandatory",
+		InCheckName:  true,
+		Description:  "Whether the check run has to pass for the pull request to pass gating.",
+	},
+	{
+		Name:         CommandOptionForce,
+		Type:         OptionTypeBool,
+		Field:        "Force",
+		Subcommands:  []Subcommand{SubcommandBuild},
+		Default:      "no",
+		PropertyName: "command_force",
+		InCheckName:  true,
+		Description:  "Build even if the same build was already requested for the current head SHA.",
+	},
+	{
+		Name:         CommandOptionPreset,
+		Type:         OptionTypeList,
+		Field:        "Presets",
+		Subcommands:  []Subcommand{SubcommandBuild},
+		PropertyName: "command_presets",
+		OmitEmpty:    true,
+		InCheckName:  true,
+		Values:       "preset name",
+		Description:  "Builds on the builders and with the options of a preset of the repository. Can be given multiple times.",
+	},
+	{
+		Name:         CommandOptionBuilder,
+		Type:         OptionTypeList,
+		Field:        "BuilderNames",
+		Subcommands:  selectingSubcommands,
+		PropertyName: "command_builders",
+		InCheckName:  true,
+		Values:       "builder name",
+		Description:  "Builder to run on. Can be given multiple times.",
+	},
+	{
+		Name:         CommandOptionOs,
+		Type:         OptionTypeString,
+		Field:        "Os",
+		Subcommands:  selectingSubcommands,
+		PropertyName: "command_os",
+		OmitEmpty:    true,
+		InCheckName:  true,
+		Validate:     validateSelectorValues,
+		Values:       "e.g. linux or linux,macos",
+		Description:  "Select builders by the operating system of their workers.",
+	},
+	{
+		Name:         CommandOptionArch,
+		Type:         OptionTypeString,
+		Field:        "Arch",
+		Subcommands:  selectingSubcommands,
+		PropertyName: "command_arch",
+		OmitEmpty:    true,
+		InCheckName:  true,
+		Validate:     validateSelectorValues,
+		Values:       "e.g. aarch64 or x86_64,aarch64",
+		Description:  "Select builders by the architecture of their workers.",
+	},
+	{
+		Name:         CommandOptionOsDistro,
+		Type:         OptionTypeString,
+		Field:        "OsDistro",
+		Subcommands:  selectingSubcommands,
+		PropertyName: "command_os_distro",
+		OmitEmpty:    true,
+		InCheckName:  true,
+		Validate:     validateSelectorValues,
+		Values:       "e.g. fedora",
+		Description:  "Select builders by the operating system distribution of their workers.",
+	},
+	{
+		Name:         CommandOptionOsVer,
+		Type:         OptionTypeString,
+		Field:        "OsVer",
+		Subcommands:  selectingSubcommands,
+		PropertyName: "command_os_ver",
+		OmitEmpty:    true,
+		InCheckName:  true,
+		Validate:     validateSelectorValues,
+		Values:       "e.g. 38",
+		Description:  "Select builders by the operating system version of their workers.",
+	},
+	{
+		Name:         CommandOptionProperty,
+		Type:         OptionTypeMap,
+		Field:        "Properties",
+		Subcommands:  []Subcommand{SubcommandBuild},
+		PropertyName: "user_",
+		OmitEmpty:    true,
+		InCheckName:  true,
+		Values:       "any value",
+		Description:  "Passes a custom property to the builders (e.g. prop:test_filter=foo). Only properties that are allowed for the repository can be used.",
+	},
+	{
+		Name:        CommandOptionDryRun,
+		Type:        OptionTypeBool,
+		Field:       "DryRun",
+		Subcommands: []Subcommand{SubcommandBuild},
+		Default:     "no",
+		OmitEmpty:   true,
+		Description: "Only reply with the builders, duplicate check and properties of the build instead of running it.",
+	},
+	{
+		Name:         CommandOptionTimeout,
+		Type:         OptionTypeDuration,
+		Field:        "Timeout",
+		Subcommands:  []Subcommand{SubcommandBuild},
+		PropertyName: "command_timeout",
+		OmitEmpty:    true,
+		Validate:     validatePositiveDuration,
+		Description:  "Cancel the build and complete its check run as timed out if it hasn't completed after this long.",
+	},
+	{
+		Name:         CommandOptionPriority,
+		Type:         OptionTypeString,
+		Field:        "Priority",
+		Subcommands:  []Subcommand{SubcommandBuild},
+		Default:      PriorityNormal,
+		OmitEmpty:    true,
+		Choices:      []string{PriorityHigh, PriorityNormal, PriorityLow},
+		PropertyName: "command_priority",
+		Description:  "Buildbot handles build requests with a higher priority first.",
+	},
+}
+
+// end::options[]
+
+// validatePositiveDuration makes sure that a duration is greater than zero.
+func validatePositiveDuration(value interface{}) error {
+	if d, ok := value.(time.Duration); !ok || d <= 0 {
+		return fmt.Errorf("duration must be greater than zero")
+	}
+	return nil
+}
+
+// OptionByName returns the option with the given name or nil if there is no
+// such option. Options that are set by the app (see Option.Subcommands) can't
+// be written and aren't found.
+func OptionByName(name string) *Option {
+	for i := range Options {
+		if Options[i].Name == name && len(Options[i].Subcommands) > 0 {
+			return &Options[i]
+		}
+	}
+	return nil
+}
+
+// Parse converts a value as written by a user into the type that is stored in
+// the option's Command field. For list options a single element is returned
+// as a []string.
+func (o Option) Parse(s string) (interface{}, error) {
+	if len(o.Choices) > 0 {
+		found := false
+		for _, c := range o.Choices {
+			if strings.EqualFold(c, s) {
+				s = c
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid value %q for option %q", s, o.Name)
+		}
+	}
+
+	var value interface{}
+	switch o.Type {
+	case OptionTypeBool:
+		if !valueIsTrue(s) && !valueIsFalse(s) {
+			return nil, fmt.Errorf("invalid boolean value %q for option %q", s, o.Name)
+		}
+		value = valueIsTrue(s)
+	case OptionTypeString:
+		value = s
+	case OptionTypeList:
+		value = []string{s}
+	case OptionTypeMap:
+		value = s
+	case OptionTypeInt:
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer value %q for option %q", s, o.Name)
+		}
+		value = i
+	case OptionTypeDuration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration %q for option %q", s, o.Name)
+		}
+		value = d
+	default:
+		return nil, fmt.Errorf("option %q has unknown type %q", o.Name, o.Type)
+	}
+
+	if o.Validate != nil {
+		if err := o.Validate(value); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// suggestions returns the values that are suggested when a user writes an
+// invalid value for the option.
+func (o Option) suggestions() []string {
+	if len(o.Choices) > 0 {
+		return o.Choices
+	}
+	if o.Type == OptionTypeBool {
+		return []string{"yes", "no", "true", "false"}
+	}
+	return nil
+}
+
+// valuesHelp returns the description of the allowed values for the help text.
+func (o Option) valuesHelp() string {
+	if o.Values != "" {
+		return o.Values
+	}
+	if len(o.Choices) > 0 {
+		return strings.Join(o.Choices, ", ")
+	}
+	switch o.Type {
+	case OptionTypeBool:
+		return "yes, no"
+	case OptionTypeInt:
+		return "number"
+	case OptionTypeDuration:
+		return "e.g. 90m, 2h"
+	}
+	return string(o.Type)
+}
+
+// usage returns how the option is written in a command (e.g. "builder" or
+// "prop:<name>").
+func (o Option) usage() string {
+	if o.Type == OptionTypeMap {
+		return o.Name + ":<name>"
+	}
+	return o.Name
+}
+
+// field returns the Command struct field that holds the option's value.
+func (o Option) field(c *Command) reflect.Value {
+	f := reflect.ValueOf(c).Elem().FieldByName(o.Field)
+	if !f.IsValid() {
+		panic(fmt.Sprintf("command option %q refers to unknown field %q", o.Name, o.Field))
+	}
+	return f
+}
+
+// acceptedBy returns true if the option can be written with the subcommand.
+func (o Option) acceptedBy(s Subcommand) bool {
+	for _, sub := range o.Subcommands {
+		if sub == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the option's value in the given command.
+func (o Option) Get(c Command) interface{} {
+	return o.field(&c).Interface()
+}
+
+// IsEmpty returns true if the option has its zero value in the given command.
+// Lists and maps without elements are empty as well.
+func (o Option) IsEmpty(c Command) bool {
+	f := o.field(&c)
+	if f.Kind() == reflect.Slice || f.Kind() == reflect.Map {
+		return f.Len() == 0
+	}
+	return f.IsZero()
+}
+
+// Set converts the given value (see Parse) and stores it in the command. The
+// value can either be a string as written by a user, a []string for list
+// options or a map[string]string for map options. The entries of a map are
+// added to the ones that are already stored in the command.
+func (o Option) Set(c *Command, value interface{}) error {
+	if m, ok := value.(map[string]string); ok {
+		if o.Type != OptionTypeMap {
+			return fmt.Errorf("option %q does not take named values", o.Name)
+		}
+		f := o.field(c)
+		if f.IsNil() {
+			f.Set(reflect.ValueOf(map[string]string{}))
+		}
+		for k, v := range m {
+			parsed, err := o.Parse(v)
+			if err != nil {
+				return err
+			}
+			f.SetMapIndex(reflect.ValueOf(k), reflect.ValueOf(parsed))
+		}
+		return nil
+	}
+
+	var converted interface{}
+	switch v := value.(type) {
+	case string:
+		parsed, err := o.Parse(v)
+		if err != nil {
+			return err
+		}
+		converted = parsed
+	case []string:
+		if o.Type != OptionTypeList {
+			return fmt.Errorf("option %q does not take a list of values", o.Name)
+		}
+		converted = v
+	default:
+		return fmt.Errorf("invalid value %+v for option %q", value, o.Name)
+	}
+	o.field(c).Set(reflect.ValueOf(converted))
+	return nil
+}
+
+// format returns the option's value in the given command as a string.
+func (o Option) format(c Command) string {
+	if o.Derive != nil {
+		return o.Derive(c)
+	}
+	switch v := o.Get(c).(type) {
+	case []string:
+		return strings.Join(v, ";")
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// sortedKeys returns the keys of the option's map in the given command in
+// ascending order.
+func (o Option) sortedKeys(c Command) []string {
+	m, _ := o.Get(c).(map[string]string)
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkNameParts returns the option as it appears in a check run name (e.g.
+// "force=true" or "prop:a=1 prop:b=2").
+func (o Option) checkNameParts(c Command) []string {
+	if o.Type != OptionTypeMap {
+		return []string{fmt.Sprintf("%s=%v", o.Name, o.Get(c))}
+	}
+	m := o.Get(c).(map[string]string)
+	parts := []string{}
+	for _, k := range o.sortedKeys(c) {
+		parts = append(parts, fmt.Sprintf("%s:%s=%s", o.Name, k, m[k]))
+	}
+	return parts
+}
+
+// ToTryBotProperties returns the "--property=name=value" arguments for
+// "buildbot try". Map options result in one argument per key and options
+// without a PropertyName result in none.
+func (o Option) ToTryBotProperties(c Command) []string {
+	if o.PropertyName == "" || o.OmitEmpty && o.IsEmpty(c) {
+		return nil
+	}
+	if o.Type != OptionTypeMap {
+		return []string{fmt.Sprintf("--property=%s=%s", o.PropertyName, o.format(c))}
+	}
+	m := o.Get(c).(map[string]string)
+	properties := []string{}
+	for _, k := range o.sortedKeys(c) {
+		properties = append(properties, fmt.Sprintf("--property=%s%s=%s", o.PropertyName, k, m[k]))
+	}
+	return properties
+}
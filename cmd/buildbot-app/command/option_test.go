@@ -0,0 +1,123 @@
+package command
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestOptions makes sure that every declared option refers to an existing
+// Command field of the matching type or derives its value from other fields.
+func TestOptions(t *testing.T) {
+	fieldTypes := map[OptionType]reflect.Type{
+		OptionTypeBool:     reflect.TypeOf(false),
+		OptionTypeString:   reflect.TypeOf(""),
+		OptionTypeList:     reflect.TypeOf([]string{}),
+		OptionTypeInt:      reflect.TypeOf(0),
+		OptionTypeDuration: reflect.TypeOf(time.Duration(0)),
+		OptionTypeMap:      reflect.TypeOf(map[string]string{}),
+	}
+	names := map[string]bool{}
+	for _, o := range Options {
+		t.Run(o.Name, func(t *testing.T) {
+			if names[o.Name] {
+				t.Fatalf("option %q is declared more than once", o.Name)
+			}
+			names[o.Name] = true
+			if o.Derive != nil {
+				if o.Field != "" || len(o.Subcommands) > 0 {
+					t.Errorf("derived option %q can't have a field or be written with a subcommand", o.Name)
+				}
+				return
+			}
+			field, ok := reflect.TypeOf(Command{}).FieldByName(o.Field)
+			if !ok {
+				t.Fatalf("option %q refers to unknown field %q", o.Name, o.Field)
+			}
+			if want := fieldTypes[o.Type]; field.Type != want {
+				t.Errorf("field %q has type %s but option %q of type %s needs %s", o.Field, field.Type, o.Name, o.Type, want)
+			}
+			if o.Default != "" {
+				if _, err := o.Parse(o.Default); err != nil {
+					t.Errorf("invalid default: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestOption_Parse(t *testing.T) {
+	tests := []struct {
+		name    string
+		option  Option
+		input   string
+		want    interface{}
+		wantErr bool
+	}{
+		{"bool yes", Option{Name: "b", Type: OptionTypeBool}, "yes", true, false},
+		{"bool FALSE", Option{Name: "b", Type: OptionTypeBool}, "FALSE", false, false},
+		{"bool invalid", Option{Name: "b", Type: OptionTypeBool}, "maybe", nil, true},
+		{"string", Option{Name: "s", Type: OptionTypeString}, "linux", "linux", false},
+		{"list", Option{Name: "l", Type: OptionTypeList}, "foo", []string{"foo"}, false},
+		{"int", Option{Name: "i", Type: OptionTypeInt}, "42", 42, false},
+		{"int invalid", Option{Name: "i", Type: OptionTypeInt}, "forty-two", nil, true},
+		{"duration", Option{Name: "d", Type: OptionTypeDuration}, "2h", 2 * time.Hour, false},
+		{"duration invalid", Option{Name: "d", Type: OptionTypeDuration}, "2 hours", nil, true},
+		{"choice", Option{Name: "c", Type: OptionTypeString, Choices: []string{"high", "low"}}, "HIGH", "high", false},
+		{"choice invalid", Option{Name: "c", Type: OptionTypeString, Choices: []string{"high", "low"}}, "medium", nil, true},
+		{
+			"validate",
+			Option{Name: "v", Type: OptionTypeInt, Validate: func(value interface{}) error {
+				if value.(int) < 1 {
+					return fmt.Errorf("must be positive")
+				}
+				return nil
+			}},
+			"0",
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.option.Parse(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Option.Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Option.Parse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOption_ToTryBotProperties(t *testing.T) {
+	cmd := New()
+	cmd.BuilderNames = []string{"bar", "foo"}
+	cmd.Os = "linux"
+	cmd.Properties = map[string]string{"test_filter": "foo", "cmake_build_type": "Release"}
+	cmd.Timeout = 90 * time.Minute
+	tests := []struct {
+		name   string
+		option string
+		want   []string
+	}{
+		{"bool", CommandOptionMandatory, []string{"--property=command_is_mandatory=true"}},
+		{"list", CommandOptionBuilder, []string{"--property=command_builders=bar;foo"}},
+		{"string", CommandOptionOs, []string{"--property=command_os=linux"}},
+		{"omitted when empty", CommandOptionArch, nil},
+		{"not passed along", CommandOptionDryRun, nil},
+		{"duration", CommandOptionTimeout, []string{"--property=command_timeout=1h30m0s"}},
+		{"default", CommandOptionPriority, []string{"--property=command_priority=normal"}},
+		{"map", CommandOptionProperty, []string{"--property=user_cmake_build_type=Release", "--property=user_test_filter=foo"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := OptionByName(tt.option).ToTryBotProperties(*cmd)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Option.ToTryBotProperties() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,346 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// tag::parse_error[]
+// ParseError describes why a string could not be parsed as a command and
+// where in the string the problem is.
+type ParseError struct {
+	// Line and Column of the offending token (both starting at 1)
+	Line   int
+	Column int
+	// The offending token as written by the user (empty at the end of input)
+	Token string
+	// What is wrong
+	Message string
+	// An optional "did you mean" suggestion for the offending token
+	Suggestion string
+}
+
+// end::parse_error[]
+
+func (e *ParseError) Error() string {
+	msg := fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message)
+	if e.Suggestion != "" {
+		msg += fmt.Sprintf(" (did you mean %q?)", e.Suggestion)
+	}
+	return msg
+}
+
+// Annotate returns the line of the input in which the error occurred together
+// with a second line that has a caret pointing at the offending column.
+func (e *ParseError) Annotate(input string) string {
+	lines := strings.Split(input, "\n")
+	if e.Line < 1 || e.Line > len(lines) {
+		return ""
+	}
+	line := strings.TrimRight(lines[e.Line-1], "\r")
+	return fmt.Sprintf("%s\n%s^", line, strings.Repeat(" ", e.Column-1))
+}
+
+// tokenKind is the kind of a token produced by the lexer.
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	// A bare word like "/buildbot", "cancel", "force" or "yes"
+	tokenWord
+	// A single or double quoted string
+	tokenString
+	// The "=" between an option name and its value
+	tokenEquals
+)
+
+// token is a lexical unit of a command string.
+type token struct {
+	kind tokenKind
+	// value is the token's value with quotes and escapes removed
+	value string
+	// text is the token as written in the input
+	text   string
+	line   int
+	column int
+	// spaceBefore is true if the token is preceded by whitespace
+	spaceBefore bool
+}
+
+// lex splits the given string into tokens. Tokens are separated by any amount
+// of whitespace (spaces, tabs and newlines). The returned list always ends with
+// a tokenEOF token.
+func lex(s string) ([]token, error) {
+	tokens := []token{}
+	runes := []rune(s)
+	line, column := 1, 1
+	i := 0
+	spaceBefore := false
+	// advance moves forward by one rune and keeps track of line and column.
+	advance := func() {
+		if runes[i] == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+		i++
+	}
+
+	for i < len(runes) {
+		r := runes[i]
+		if unicode.IsSpace(r) {
+			spaceBefore = true
+			advance()
+			continue
+		}
+		switch {
+		case r == '=':
+			tokens = append(tokens, token{kind: tokenEquals, value: "=", text: "=", line: line, column: column, spaceBefore: spaceBefore})
+			advance()
+		case r == '"' || r == '\'':
+			quote := r
+			startLine, startColumn, start := line, column, i
+			advance()
+			var value strings.Builder
+			terminated := false
+			for i < len(runes) {
+				c := runes[i]
+				if c == quote {
+					advance()
+					terminated = true
+					break
+				}
+				if c == '\\' && quote == '"' && i+1 < len(runes) {
+					advance()
+					c = runes[i]
+				}
+				value.WriteRune(c)
+				advance()
+			}
+			if !terminated {
+				return nil, &ParseError{
+					Line:    startLine,
+					Column:  startColumn,
+					Token:   string(runes[start:i]),
+					Message: fmt.Sprintf("unterminated quoted value, missing closing %c", quote),
+				}
+			}
+			tokens = append(tokens, token{kind: tokenString, value: value.String(), text: string(runes[start:i]), line: startLine, column: startColumn, spaceBefore: spaceBefore})
+		default:
+			startLine, startColumn, start := line, column, i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '=' && runes[i] != '"' && runes[i] != '\'' {
+				advance()
+			}
+			word := string(runes[start:i])
+			tokens = append(tokens, token{kind: tokenWord, value: word, text: word, line: startLine, column: startColumn, spaceBefore: spaceBefore})
+		}
+		spaceBefore = false
+	}
+	tokens = append(tokens, token{kind: tokenEOF, line: line, column: column, spaceBefore: spaceBefore})
+	return tokens, nil
+}
+
+// parser turns a list of tokens into a map of options. It implements the
+// grammar documented in docs/media/command-ebnf.puml:
+//
+//	command    = "/buildbot", [subcommand], {option};
+//	subcommand = "build" | "cancel" | "status" | "retry" | "approve" | "help";
+//	option     = name, [":", key], "=", value; (* without whitespace in between *)
+//	value      = word | quoted;
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// errorAt returns a ParseError for the given token.
+func errorAt(t token, suggestion string, format string, args ...interface{}) *ParseError {
+	return &ParseError{
+		Line:       t.line,
+		Column:     t.column,
+		Token:      t.text,
+		Message:    fmt.Sprintf(format, args...),
+		Suggestion: suggestion,
+	}
+}
+
+// parse parses the given string into a map with lowercase option names as
+// keys. The options are parsed left-to-right. For overwritable options the
+// last option given wins and list options are extended. For example:
+//
+//	/buildbot mandatory=yes builder=foo mandatory=no builder=bar
+//
+// Will return map{mandatory:no, builder:[]string{"foo", "bar"}}
+//
+// A subcommand is stored under the "subcommand" key. Preset names are not
+// checked.
+func parse(s string) (map[string]interface{}, error) {
+	return parseString(s, nil)
+}
+
+// parseString is parse with an optional check of preset names. If presets is
+// not nil, every preset=<name> has to refer to one of them.
+func parseString(s string, presets Presets) (map[string]interface{}, error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	arguments := map[string]interface{}{}
+
+	// "/buildbot"
+	first := p.next()
+	if first.kind != tokenWord || first.value != BuildbotCommand {
+		return nil, errorAt(first, suggest(first.text, []string{BuildbotCommand}), "expected %s", BuildbotCommand)
+	}
+
+	// [subcommand]
+	subcommand := SubcommandBuild
+	if t := p.peek(); t.kind == tokenWord && p.tokens[p.pos+1].kind != tokenEquals {
+		p.next()
+		subcommand = Subcommand(strings.ToLower(t.value))
+		if subcommand.Definition() == nil {
+			if OptionByName(string(subcommand)) != nil {
+				return nil, errorAt(p.peek(), t.text+"=", "expected \"=\" after option %q", string(subcommand))
+			}
+			return nil, errorAt(t, suggest(t.value, subcommandNames()), "unknown subcommand %q", t.value)
+		}
+		arguments[subcommandKey] = string(subcommand)
+	}
+
+	// {option}
+	for p.peek().kind != tokenEOF {
+		nameToken := p.next()
+		if nameToken.kind != tokenWord {
+			return nil, errorAt(nameToken, "", "expected an option name but got %q", nameToken.text)
+		}
+		name := strings.ToLower(nameToken.value)
+		// Namespaced options are written as name:key (e.g. prop:test_filter)
+		name, key, namespaced := strings.Cut(name, ":")
+		if !subcommand.AcceptsOption(name) {
+			if OptionByName(name) != nil {
+				return nil, errorAt(nameToken, "", "option %q cannot be used with %s %s", name, BuildbotCommand, subcommand)
+			}
+			return nil, errorAt(nameToken, suggest(name, subcommand.Definition().Options()), "unknown option %q", nameToken.value)
+		}
+		option := OptionByName(name)
+		if option.Type == OptionTypeMap && (!namespaced || !isValidKey(key)) {
+			return nil, errorAt(nameToken, "", "option %q needs a name made of letters, digits and underscores (e.g. %s:my_name=value)", name, name)
+		}
+		if option.Type != OptionTypeMap && namespaced {
+			return nil, errorAt(nameToken, name, "option %q does not take a name", name)
+		}
+		if t := p.next(); t.kind != tokenEquals || t.spaceBefore {
+			return nil, errorAt(t, nameToken.text+"=", "expected \"=\" after option %q", name)
+		}
+		valueToken := p.next()
+		if valueToken.kind != tokenWord && valueToken.kind != tokenString || valueToken.spaceBefore || valueToken.value == "" {
+			return nil, errorAt(valueToken, "", "missing value for option %q", name)
+		}
+		value := valueToken.value
+
+		if _, err := option.Parse(value); err != nil {
+			return nil, errorAt(valueToken, suggest(value, option.suggestions()), "%s", err)
+		}
+		if _, ok := presets[value]; presets != nil && option.Name == CommandOptionPreset && !ok {
+			return nil, errorAt(valueToken, suggest(value, presets.sortedNames()), "unknown preset %q", value)
+		}
+		if option.Type == OptionTypeMap {
+			m, _ := arguments[name].(map[string]string)
+			if m == nil {
+				m = map[string]string{}
+			}
+			m[key] = value
+			arguments[name] = m
+		} else if option.Type == OptionTypeList {
+			elements, _ := arguments[name].([]string)
+			arguments[name] = removeDuplicatesInPlace(append(elements, value))
+		} else {
+			arguments[name] = value
+		}
+	}
+	return arguments, nil
+}
+
+// isValidKey returns true if the key of a namespaced option is not empty and
+// only consists of letters, digits and underscores.
+func isValidKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// subcommandNames returns the names of all subcommands.
+func subcommandNames() []string {
+	names := make([]string, len(SubcommandDefinitions))
+	for i, def := range SubcommandDefinitions {
+		names[i] = string(def.Name)
+	}
+	return names
+}
+
+// suggest returns the candidate that is closest to the given word or an empty
+// string if no candidate is close enough to be a likely typo.
+func suggest(word string, candidates []string) string {
+	word = strings.ToLower(word)
+	best := ""
+	bestDistance := -1
+	for _, c := range candidates {
+		d := levenshtein(word, strings.ToLower(c))
+		if bestDistance == -1 || d < bestDistance {
+			best, bestDistance = c, d
+		}
+	}
+	if bestDistance == -1 || bestDistance > 1 && bestDistance > len(word)/3 {
+		return ""
+	}
+	return best
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, minInt(curr[j-1]+1, prev[j-1]+cost))
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
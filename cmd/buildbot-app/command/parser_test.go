@@ -0,0 +1,290 @@
+package command
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFromString_Grammar(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  *Command
+	}{
+		{
+			"tabs and newlines",
+			"/buildbot\tforce=yes\n  builder=foo\r\n",
+			func() *Command {
+				c := New()
+				c.Force = true
+				c.BuilderNames = []string{"foo"}
+				return c
+			}(),
+		},
+		{
+			"dashes and dots in builder names",
+			"/buildbot builder=clang-x86_64-linux builder=gcc.12",
+			func() *Command {
+				c := New()
+				c.BuilderNames = []string{"clang-x86_64-linux", "gcc.12"}
+				return c
+			}(),
+		},
+		{
+			"quoted values",
+			`/buildbot builder="my builder" builder='it"s' os="li\"nux"`,
+			func() *Command {
+				c := New()
+				c.BuilderNames = []string{"it\"s", "my builder"}
+				c.Os = `li"nux`
+				return c
+			}(),
+		},
+		{
+			"custom properties",
+			"/buildbot prop:test_filter=foo prop:CMake_Build_Type=Release prop:test_filter=bar",
+			func() *Command {
+				c := New()
+				c.Properties = map[string]string{"test_filter": "bar", "cmake_build_type": "Release"}
+				return c
+			}(),
+		},
+		{
+			"timeout and priority",
+			"/buildbot timeout=1h30m priority=HIGH",
+			func() *Command {
+				c := New()
+				c.Timeout = 90 * time.Minute
+				c.Priority = PriorityHigh
+				return c
+			}(),
+		},
+		{
+			"approve",
+			"/buildbot approve",
+			func() *Command {
+				c := New()
+				c.Subcommand = SubcommandApprove
+				return c
+			}(),
+		},
+		{
+			"case insensitive names",
+			"/buildbot CANCEL Builder=Foo",
+			func() *Command {
+				c := New()
+				c.Subcommand = SubcommandCancel
+				c.BuilderNames = []string{"Foo"}
+				return c
+			}(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FromString(tt.input)
+			if err != nil {
+				t.Fatalf("FromString() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FromString() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{"no options", "/buildbot", map[string]interface{}{}, false},
+		{"bool", "/buildbot force=true", map[string]interface{}{CommandOptionForce: "true"}, false},
+		{"last value wins", "/buildbot mandatory=yes force=true mandatory=no", map[string]interface{}{CommandOptionMandatory: "no", CommandOptionForce: "true"}, false},
+		{"repeated list options merge", "/buildbot builder=world force=false builder=hello", map[string]interface{}{CommandOptionBuilder: []string{"hello", "world"}, CommandOptionForce: "false"}, false},
+		{"repeated list values are kept once", "/buildbot builder=hello builder=hello", map[string]interface{}{CommandOptionBuilder: []string{"hello"}}, false},
+		{"repeated map options merge", "/buildbot prop:a=1 prop:b=2 prop:a=3", map[string]interface{}{CommandOptionProperty: map[string]string{"a": "3", "b": "2"}}, false},
+		{"subcommand", "/buildbot cancel builder=hello", map[string]interface{}{subcommandKey: "cancel", CommandOptionBuilder: []string{"hello"}}, false},
+		{"unknown option", "/buildbot foo=bar", nil, true},
+		{"missing list value", "/buildbot builder=", nil, true},
+		{"missing bool value", "/buildbot force=", nil, true},
+		{"invalid bool value", "/buildbot force=maybe", nil, true},
+		{"invalid choice", "/buildbot priority=urgent", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parse(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromString_ParseError(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  ParseError
+	}{
+		{
+			"not a command",
+			"/buildbto force=yes",
+			ParseError{Line: 1, Column: 1, Token: "/buildbto", Message: `expected /buildbot`, Suggestion: "/buildbot"},
+		},
+		{
+			"unknown option",
+			"/buildbot forse=yes",
+			ParseError{Line: 1, Column: 11, Token: "forse", Message: `unknown option "forse"`, Suggestion: "force"},
+		},
+		{
+			"unknown option without suggestion",
+			"/buildbot foo=bar",
+			ParseError{Line: 1, Column: 11, Token: "foo", Message: `unknown option "foo"`},
+		},
+		{
+			"property without name",
+			"/buildbot prop=foo",
+			ParseError{Line: 1, Column: 11, Token: "prop", Message: `option "prop" needs a name made of letters, digits and underscores (e.g. prop:my_name=value)`},
+		},
+		{
+			"property with invalid name",
+			"/buildbot prop:test-filter=foo",
+			ParseError{Line: 1, Column: 11, Token: "prop:test-filter", Message: `option "prop" needs a name made of letters, digits and underscores (e.g. prop:my_name=value)`},
+		},
+		{
+			"name for option without names",
+			"/buildbot builder:foo=bar",
+			ParseError{Line: 1, Column: 11, Token: "builder:foo", Message: `option "builder" does not take a name`, Suggestion: "builder"},
+		},
+		{
+			"invalid priority",
+			"/buildbot priority=hgh",
+			ParseError{Line: 1, Column: 20, Token: "hgh", Message: `invalid value "hgh" for option "priority"`, Suggestion: "high"},
+		},
+		{
+			"invalid timeout",
+			"/buildbot timeout=0s",
+			ParseError{Line: 1, Column: 19, Token: "0s", Message: `duration must be greater than zero`},
+		},
+		{
+			"unknown subcommand",
+			"/buildbot cancle",
+			ParseError{Line: 1, Column: 11, Token: "cancle", Message: `unknown subcommand "cancle"`, Suggestion: "cancel"},
+		},
+		{
+			"option without value",
+			"/buildbot force",
+			ParseError{Line: 1, Column: 16, Token: "", Message: `expected "=" after option "force"`, Suggestion: "force="},
+		},
+		{
+			"missing value",
+			"/buildbot builder= force=yes",
+			ParseError{Line: 1, Column: 20, Token: "force", Message: `missing value for option "builder"`},
+		},
+		{
+			"whitespace before equal sign",
+			"/buildbot force =yes",
+			ParseError{Line: 1, Column: 17, Token: "=", Message: `expected "=" after option "force"`, Suggestion: "force="},
+		},
+		{
+			"whitespace after equal sign",
+			"/buildbot force= yes",
+			ParseError{Line: 1, Column: 18, Token: "yes", Message: `missing value for option "force"`},
+		},
+		{
+			"invalid boolean",
+			"/buildbot\n  mandatory=yess",
+			ParseError{Line: 2, Column: 13, Token: "yess", Message: `invalid boolean value "yess" for option "mandatory"`, Suggestion: "yes"},
+		},
+		{
+			"option not accepted by subcommand",
+			"/buildbot cancel force=yes",
+			ParseError{Line: 1, Column: 18, Token: "force", Message: `option "force" cannot be used with /buildbot cancel`},
+		},
+		{
+			"unterminated quote",
+			`/buildbot builder="foo`,
+			ParseError{Line: 1, Column: 19, Token: `"foo`, Message: `unterminated quoted value, missing closing "`},
+		},
+		{
+			"value without option",
+			`/buildbot builder=foo "bar"`,
+			ParseError{Line: 1, Column: 23, Token: `"bar"`, Message: `expected an option name but got "\"bar\""`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := FromString(tt.input)
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("FromString() error = %v, want *ParseError", err)
+			}
+			if !reflect.DeepEqual(*parseErr, tt.want) {
+				t.Errorf("FromString() error = %+v, want %+v", *parseErr, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseError_Annotate(t *testing.T) {
+	input := "/buildbot\n  forse=yes"
+	_, err := FromString(input)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("FromString() error = %v, want *ParseError", err)
+	}
+	want := "  forse=yes\n  ^"
+	if got := parseErr.Annotate(input); got != want {
+		t.Errorf("ParseError.Annotate() = %q, want %q", got, want)
+	}
+}
+
+func TestLooksLikeCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  bool
+	}{
+		{"command", "/buildbot", true},
+		{"invalid command", "/buildbot forse=yes", true},
+		{"leading whitespace", "  \n/buildbot", true},
+		{"prose", "I like /buildbot", false},
+		{"prefix", "/buildbots", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := LooksLikeCommand(tt.input); got != tt.want {
+				t.Errorf("LooksLikeCommand() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	tests := []struct {
+		name       string
+		word       string
+		candidates []string
+		want       string
+	}{
+		{"typo", "mandatroy", []string{"mandatory", "force"}, "mandatory"},
+		{"case", "FORCE", []string{"mandatory", "force"}, "force"},
+		{"too far off", "banana", []string{"mandatory", "force"}, ""},
+		{"no candidates", "force", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := suggest(tt.word, tt.candidates); got != tt.want {
+				t.Errorf("suggest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
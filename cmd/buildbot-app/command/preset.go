@@ -0,0 +1,146 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// tag::preset[]
+// A Preset is a named set of builders and option values that a build command
+// can refer to with preset=<name> instead of listing every builder on its own.
+// Options that are written in the command override the ones of the preset.
+type Preset struct {
+	// Builders to run on
+	Builders []string `yaml:"builders"`
+	// Option values as a user would write them (e.g. "timeout: 2h" or
+	// "prop:test_filter: MLIR"). Builders go into Builders instead.
+	Options map[string]string `yaml:"options"`
+	// Other presets whose builders and options are part of this one. Options
+	// of this preset override the ones of the included presets.
+	Include []string `yaml:"include"`
+}
+
+// Presets maps the name of a preset to its definition.
+type Presets map[string]Preset
+
+// end::preset[]
+
+// Validate returns an error if a preset sets an unknown option, includes an
+// unknown preset or includes itself through other presets.
+func (p Presets) Validate() error {
+	for _, name := range p.sortedNames() {
+		for key, value := range p[name].Options {
+			if err := checkPresetOption(key, value); err != nil {
+				return fmt.Errorf("preset %q: %w", name, err)
+			}
+		}
+		if _, _, err := p.Expand([]string{name}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPresetOption returns an error if the option cannot be set by a preset
+// or if the value is invalid.
+func checkPresetOption(key string, value string) error {
+	name, mapKey, namespaced := strings.Cut(strings.ToLower(key), ":")
+	o := OptionByName(name)
+	if o == nil || !SubcommandBuild.AcceptsOption(name) {
+		return fmt.Errorf("unknown option %q", key)
+	}
+	if o.Type == OptionTypeList {
+		return fmt.Errorf("option %q cannot be set in the options of a preset", key)
+	}
+	if o.Type == OptionTypeMap && (!namespaced || !isValidKey(mapKey)) {
+		return fmt.Errorf("option %q needs a name (e.g. %s:my_name)", key, name)
+	}
+	if o.Type != OptionTypeMap && namespaced {
+		return fmt.Errorf("option %q does not take a name", name)
+	}
+	if _, err := o.Parse(value); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Expand returns the sorted builders without duplicates and the option values
+// of the given presets including the presets that they include. For options
+// that are set more than once, the last preset wins.
+func (p Presets) Expand(names []string) ([]string, map[string]string, error) {
+	builders := []string{}
+	options := map[string]string{}
+	for _, name := range names {
+		if err := p.expand(name, []string{}, &builders, options); err != nil {
+			return nil, nil, err
+		}
+	}
+	return removeDuplicatesInPlace(builders), options, nil
+}
+
+// expand adds the builders and options of the named preset to the given ones.
+// The stack holds the presets that are currently being expanded and is used
+// to detect cycles.
+func (p Presets) expand(name string, stack []string, builders *[]string, options map[string]string) error {
+	for _, visiting := range stack {
+		if visiting == name {
+			return fmt.Errorf("presets include each other: %s", strings.Join(append(stack, name), " -> "))
+		}
+	}
+	preset, ok := p[name]
+	if !ok {
+		if len(stack) > 0 {
+			return fmt.Errorf("preset %q includes unknown preset %q", stack[len(stack)-1], name)
+		}
+		return fmt.Errorf("unknown preset %q", name)
+	}
+	stack = append(stack, name)
+	for _, include := range preset.Include {
+		if err := p.expand(include, stack, builders, options); err != nil {
+			return err
+		}
+	}
+	*builders = append(*builders, preset.Builders...)
+	for key, value := range preset.Options {
+		options[strings.ToLower(key)] = value
+	}
+	return nil
+}
+
+// sortedNames returns the names of all presets in ascending order.
+func (p Presets) sortedNames() []string {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyPresets sets the builders and options of the given presets in the
+// command. The builders are remembered in PresetBuilderNames so that they
+// can be left out of the check run name.
+func (c *Command) applyPresets(names []string, presets Presets) error {
+	builders, options, err := presets.Expand(names)
+	if err != nil {
+		return err
+	}
+	for key, value := range options {
+		name, mapKey, namespaced := strings.Cut(key, ":")
+		o := OptionByName(name)
+		if o == nil {
+			return fmt.Errorf("unknown option %q in presets %s", key, strings.Join(names, ", "))
+		}
+		var v interface{} = value
+		if namespaced {
+			v = map[string]string{mapKey: value}
+		}
+		if err := o.Set(c, v); err != nil {
+			return err
+		}
+	}
+	c.BuilderNames = builders
+	c.PresetBuilderNames = append([]string{}, builders...)
+	return nil
+}
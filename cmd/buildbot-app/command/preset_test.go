@@ -0,0 +1,177 @@
+package command
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testPresets() Presets {
+	return Presets{
+		"quick": {Builders: []string{"linux-x86_64"}},
+		"full": {
+			Include:  []string{"quick"},
+			Builders: []string{"macos-aarch64", "linux-aarch64"},
+			Options:  map[string]string{"timeout": "2h", "priority": "low"},
+		},
+		"nightly": {
+			Include: []string{"full"},
+			Options: map[string]string{"Priority": "high", "prop:test_filter": "all"},
+		},
+	}
+}
+
+func TestPresets_Expand(t *testing.T) {
+	tests := []struct {
+		name         string
+		presets      []string
+		wantBuilders []string
+		wantOptions  map[string]string
+		wantErr      string
+	}{
+		{"single", []string{"quick"}, []string{"linux-x86_64"}, map[string]string{}, ""},
+		{"include", []string{"full"}, []string{"linux-aarch64", "linux-x86_64", "macos-aarch64"}, map[string]string{"timeout": "2h", "priority": "low"}, ""},
+		{"including preset wins", []string{"nightly"}, []string{"linux-aarch64", "linux-x86_64", "macos-aarch64"}, map[string]string{"timeout": "2h", "priority": "high", "prop:test_filter": "all"}, ""},
+		{"several", []string{"quick", "full"}, []string{"linux-aarch64", "linux-x86_64", "macos-aarch64"}, map[string]string{"timeout": "2h", "priority": "low"}, ""},
+		{"unknown", []string{"slow"}, nil, nil, `unknown preset "slow"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builders, options, err := testPresets().Expand(tt.presets)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("Presets.Expand() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Presets.Expand() error = %v", err)
+			}
+			if !reflect.DeepEqual(builders, tt.wantBuilders) {
+				t.Errorf("Presets.Expand() builders = %v, want %v", builders, tt.wantBuilders)
+			}
+			if !reflect.DeepEqual(options, tt.wantOptions) {
+				t.Errorf("Presets.Expand() options = %v, want %v", options, tt.wantOptions)
+			}
+		})
+	}
+}
+
+func TestPresets_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		presets Presets
+		wantErr string
+	}{
+		{"ok", testPresets(), ""},
+		{"cycle", Presets{"a": {Include: []string{"b"}}, "b": {Include: []string{"c"}}, "c": {Include: []string{"a"}}}, "presets include each other: a -> b -> c -> a"},
+		{"includes itself", Presets{"a": {Include: []string{"a"}}}, "presets include each other: a -> a"},
+		{"unknown include", Presets{"a": {Include: []string{"b"}}}, `preset "a" includes unknown preset "b"`},
+		{"unknown option", Presets{"a": {Options: map[string]string{"forse": "yes"}}}, `preset "a": unknown option "forse"`},
+		{"invalid value", Presets{"a": {Options: map[string]string{"priority": "urgent"}}}, `preset "a": invalid value "urgent" for option "priority"`},
+		{"builders as option", Presets{"a": {Options: map[string]string{"builder": "foo"}}}, `preset "a": option "builder" cannot be set in the options of a preset`},
+		{"property without name", Presets{"a": {Options: map[string]string{"prop": "foo"}}}, `preset "a": option "prop" needs a name (e.g. prop:my_name)`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.presets.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Presets.Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("Presets.Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFromStringWithPresets(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		wantBuilders  []string
+		wantTimeout   time.Duration
+		wantPriority  string
+		wantCheckName string
+	}{
+		{
+			"preset",
+			"/buildbot preset=full",
+			[]string{"linux-aarch64", "linux-x86_64", "macos-aarch64"},
+			2 * time.Hour,
+			PriorityLow,
+			"@johndoe /buildbot mandatory=true force=false preset=[full]",
+		},
+		{
+			"command overrides preset",
+			"/buildbot preset=full priority=high",
+			[]string{"linux-aarch64", "linux-x86_64", "macos-aarch64"},
+			2 * time.Hour,
+			PriorityHigh,
+			"@johndoe /buildbot mandatory=true force=false preset=[full]",
+		},
+		{
+			"additional builders",
+			"/buildbot builder=windows preset=quick builder=linux-x86_64",
+			[]string{"linux-x86_64", "windows"},
+			0,
+			PriorityNormal,
+			"@johndoe /buildbot mandatory=true force=false preset=[quick] builder=[windows]",
+		},
+		{
+			"several presets",
+			"/buildbot preset=quick preset=nightly",
+			[]string{"linux-aarch64", "linux-x86_64", "macos-aarch64"},
+			2 * time.Hour,
+			PriorityHigh,
+			"@johndoe /buildbot mandatory=true force=false preset=[nightly quick] prop:test_filter=all",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := FromStringWithPresets(tt.input, testPresets())
+			if err != nil {
+				t.Fatalf("FromStringWithPresets() error = %v", err)
+			}
+			cmd.CommentAuthor = "johndoe"
+			if !reflect.DeepEqual(cmd.BuilderNames, tt.wantBuilders) {
+				t.Errorf("FromStringWithPresets() builders = %v, want %v", cmd.BuilderNames, tt.wantBuilders)
+			}
+			if cmd.Timeout != tt.wantTimeout {
+				t.Errorf("FromStringWithPresets() timeout = %v, want %v", cmd.Timeout, tt.wantTimeout)
+			}
+			if cmd.Priority != tt.wantPriority {
+				t.Errorf("FromStringWithPresets() priority = %v, want %v", cmd.Priority, tt.wantPriority)
+			}
+			if got := cmd.ToGithubCheckNameString(); got != tt.wantCheckName {
+				t.Errorf("Command.ToGithubCheckNameString() = %v, want %v", got, tt.wantCheckName)
+			}
+		})
+	}
+
+	t.Run("unknown preset", func(t *testing.T) {
+		_, err := FromStringWithPresets("/buildbot preset=ful", testPresets())
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("FromStringWithPresets() error = %v, want *ParseError", err)
+		}
+		want := ParseError{Line: 1, Column: 18, Token: "ful", Message: `unknown preset "ful"`, Suggestion: "full"}
+		if !reflect.DeepEqual(*parseErr, want) {
+			t.Errorf("FromStringWithPresets() error = %+v, want %+v", *parseErr, want)
+		}
+	})
+
+	t.Run("presets are only checked when parsing commands", func(t *testing.T) {
+		if !StringIsCommand("/buildbot preset=full") {
+			t.Errorf("StringIsCommand() = false, want true")
+		}
+		if _, err := FromString("/buildbot preset=full"); err == nil || !strings.Contains(err.Error(), `unknown preset "full"`) {
+			t.Errorf("FromString() error = %v, want unknown preset", err)
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Subcommand is the verb that follows /buildbot in a command comment.
+type Subcommand string
+
+// tag::subcommands[]
+const (
+	// SubcommandBuild starts builds. It is implied when no subcommand is given.
+	SubcommandBuild Subcommand = "build"
+
+	// SubcommandCancel stops in-flight builds of the pull request.
+	SubcommandCancel Subcommand = "cancel"
+
+	// SubcommandStatus replies with an overview of the pull request's check
+	// runs.
+	SubcommandStatus Subcommand = "status"
+
+	// SubcommandRetry starts failed, cancelled or timed out builds again.
+	SubcommandRetry Subcommand = "retry"
+
+	// SubcommandApprove runs the build requests of first-time or external
+	// contributors that wait for a maintainer's approval.
+	SubcommandApprove Subcommand = "approve"
+
+	// SubcommandHelp replies with the command reference.
+	SubcommandHelp Subcommand = "help"
+)
+
+// end::subcommands[]
+
+// subcommandKey is the key under which parse stores the subcommand.
+const subcommandKey = "subcommand"
+
+// SubcommandDefinition documents a subcommand. The options it accepts are
+// declared by the Options themselves (see Option.Subcommands).
+type SubcommandDefinition struct {
+	Name        Subcommand
+	Description string
+}
+
+// tag::definitions[]
+// SubcommandDefinitions lists all subcommands that /buildbot understands.
+var SubcommandDefinitions = []SubcommandDefinition{
+	{
+		Name:        SubcommandBuild,
+		Description: "Starts a build (this is the default when no subcommand is given).",
+	},
+	{
+		Name:        SubcommandCancel,
+		Description: "Stops the in-flight builds of this pull request. Use options to narrow down which ones.",
+	},
+	{
+		Name:        SubcommandStatus,
+		Description: "Shows a table of this pull request's check runs.",
+	},
+	{
+		Name:        SubcommandRetry,
+		Description: "Runs failed, cancelled or timed out builds again. Use options to narrow down which ones.",
+	},
+	{
+		Name:        SubcommandApprove,
+		Description: "Runs the build requests for the current head SHA that wait for a maintainer's approval. Only maintainers may use it.",
+	},
+	{
+		Name:        SubcommandHelp,
+		Description: "Shows this help.",
+	},
+}
+
+// end::definitions[]
+
+// Definition returns the definition of the subcommand or nil if the
+// subcommand is unknown.
+func (s Subcommand) Definition() *SubcommandDefinition {
+	for i := range SubcommandDefinitions {
+		if SubcommandDefinitions[i].Name == s {
+			return &SubcommandDefinitions[i]
+		}
+	}
+	return nil
+}
+
+// Options returns the names of the options that can be used with the
+// subcommand.
+func (d SubcommandDefinition) Options() []string {
+	names := []string{}
+	for _, o := range Options {
+		if o.acceptedBy(d.Name) {
+			names = append(names, o.Name)
+		}
+	}
+	return names
+}
+
+// AcceptsOption returns true if the given option can be used with the
+// subcommand.
+func (s Subcommand) AcceptsOption(option string) bool {
+	o := OptionByName(option)
+	return o != nil && o.acceptedBy(s)
+}
+
+// HelpText returns a markdown formatted reference of all subcommands and
+// their options. It is derived from SubcommandDefinitions and Options.
+func HelpText() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Usage: <code>%s [subcommand] [option=value ...]</code>\n\n", BuildbotCommand)
+	sb.WriteString("| Subcommand | Options | Description |\n")
+	sb.WriteString("|------------|---------|-------------|\n")
+	for _, def := range SubcommandDefinitions {
+		names := def.Options()
+		options := make([]string, len(names))
+		for i, o := range names {
+			options[i] = fmt.Sprintf("`%s`", o)
+		}
+		fmt.Fprintf(&sb, "| `%s` | %s | %s |\n", def.Name, strings.Join(options, ", "), def.Description)
+	}
+	sb.WriteString("\n| Option | Values | Default | Description |\n")
+	sb.WriteString("|--------|--------|---------|-------------|\n")
+	for _, o := range Options {
+		if len(o.Subcommands) == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "| `%s` | %s | %s | %s |\n", o.usage(), o.valuesHelp(), o.Default, o.Description)
+	}
+	return sb.String()
+}
@@ -0,0 +1,49 @@
+package command
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSubcommand_AcceptsOption(t *testing.T) {
+	tests := []struct {
+		name       string
+		subcommand Subcommand
+		option     string
+		want       bool
+	}{
+		{"build builder", SubcommandBuild, CommandOptionBuilder, true},
+		{"build force", SubcommandBuild, CommandOptionForce, true},
+		{"cancel builder", SubcommandCancel, CommandOptionBuilder, true},
+		{"cancel os", SubcommandCancel, CommandOptionOs, true},
+		{"cancel force", SubcommandCancel, CommandOptionForce, false},
+		{"status builder", SubcommandStatus, CommandOptionBuilder, false},
+		{"build author", SubcommandBuild, "author", false},
+		{"unknown subcommand", Subcommand("foo"), CommandOptionBuilder, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.subcommand.AcceptsOption(tt.option); got != tt.want {
+				t.Errorf("Subcommand.AcceptsOption() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHelpText(t *testing.T) {
+	help := HelpText()
+	for _, def := range SubcommandDefinitions {
+		if !strings.Contains(help, "| `"+string(def.Name)+"` |") {
+			t.Errorf("HelpText() does not document subcommand %s", def.Name)
+		}
+	}
+	for _, o := range Options {
+		documented := strings.Contains(help, "| `"+o.usage()+"` |")
+		if len(o.Subcommands) > 0 && !documented {
+			t.Errorf("HelpText() does not document option %s", o.Name)
+		}
+		if len(o.Subcommands) == 0 && documented {
+			t.Errorf("HelpText() documents option %s that can't be written", o.Name)
+		}
+	}
+}
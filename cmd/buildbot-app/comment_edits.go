@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+)
+
+// tag::comment_edits[]
+// changedCommandLines compares the command lines of an edited comment with the
+// ones from before the edit. It returns the lines that the edit added and the
+// ones that it removed. Lines that are in both are unchanged, no matter where
+// they are in the comment.
+func changedCommandLines(lines []command.CommandLine, previousLines []command.CommandLine) (added []command.CommandLine, removed []command.CommandLine) {
+	count := map[string]int{}
+	for _, line := range previousLines {
+		count[line.Text]++
+	}
+	for _, line := range lines {
+		if count[line.Text] > 0 {
+			count[line.Text]--
+			continue
+		}
+		added = append(added, line)
+	}
+	for _, line := range previousLines {
+		if count[line.Text] > 0 {
+			count[line.Text]--
+			removed = append(removed, line)
+		}
+	}
+	return added, removed
+}
+
+// end::comment_edits[]
+
+// builderNamesOf returns the sorted builders that the build commands of the
+// given lines run on. Lines that aren't builds or that can't be parsed are
+// skipped. Matrix builds don't count because their builders are resolved per
+// combination.
+func (r commentRequest) builderNamesOf(lines []command.CommandLine) ([]string, error) {
+	inventory, err := r.srv.GetBuilderInventory()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get builder inventory: %w", err)
+	}
+	names := map[string]bool{}
+	for _, line := range lines {
+		cmd, err := command.FromStringWithPresets(r.config.ExpandAliases(line.Text), r.config.Presets)
+		if err != nil || cmd.Subcommand != command.SubcommandBuild || cmd.IsMatrix() {
+			continue
+		}
+		if len(cmd.BuilderNames) == 0 && len(cmd.Selectors()) == 0 {
+			cmd.BuilderNames = r.config.DefaultBuilders
+		}
+		if err := cmd.ResolveBuilderNames(r.config.FilterInventory(inventory)); err != nil {
+			continue
+		}
+		for _, name := range cmd.BuilderNames {
+			names[name] = true
+		}
+	}
+	return sortedKeys(names), nil
+}
+
+// reconcileEdit cancels the in-flight builds of the comment that build one of
+// the builders that the edit removed. It returns the builders that the added
+// command lines may build: the ones that the edit added plus the remaining
+// ones of the cancelled check runs, so that they don't get lost.
+func (r commentRequest) reconcileEdit(added []command.CommandLine, removed []command.CommandLine) ([]string, error) {
+	oldBuilders, err := r.builderNamesOf(removed)
+	if err != nil {
+		return nil, err
+	}
+	newBuilders, err := r.builderNamesOf(added)
+	if err != nil {
+		return nil, err
+	}
+	removedBuilders := subtractStrings(oldBuilders, newBuilders)
+	addedBuilders := map[string]bool{}
+	for _, name := range subtractStrings(newBuilders, oldBuilders) {
+		addedBuilders[name] = true
+	}
+	if len(removedBuilders) > 0 {
+		reason := fmt.Sprintf(`Cancelled because @%s removed it from <a href="%s">the comment</a> that requested it.`, r.commenter.Login, r.commentURL)
+		cancelled, err := r.cancelCommentBuilds(removedBuilders, reason)
+		if err != nil {
+			return nil, err
+		}
+		for _, meta := range cancelled {
+			for _, name := range meta.Command.BuilderNames {
+				if containsString(newBuilders, name) {
+					addedBuilders[name] = true
+				}
+			}
+		}
+	}
+	return sortedKeys(addedBuilders), nil
+}
+
+// cancelCommentBuilds cancels the in-flight builds of the pull request's head
+// SHA that were requested by the comment and that build one of the given
+// builders (or any builder if none are given). The meta data of the cancelled
+// check runs is returned.
+func (r commentRequest) cancelCommentBuilds(builderNames []string, reason string) ([]*CheckRunMeta, error) {
+	return cancelPullRequestCheckRuns(r.srv, r.gh, r.appInstallationID, r.pr, reason, func(meta *CheckRunMeta) bool {
+		return meta.Command.CommentID == r.commentID && builderNamesOverlap(builderNames, meta.Command.BuilderNames)
+	})
+}
+
+// subtractStrings returns the elements of a that aren't in b.
+func subtractStrings(a []string, b []string) []string {
+	result := []string{}
+	for _, s := range a {
+		if !containsString(b, s) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// sortedKeys returns the sorted keys of the set.
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
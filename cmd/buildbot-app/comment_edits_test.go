@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+	"github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangedCommandLines(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		previousBody string
+		wantAdded    []string
+		wantRemoved  []string
+	}{
+		{"unchanged", "/buildbot builder=foo\n/buildbot builder=bar", "/buildbot builder=foo\n/buildbot builder=bar", nil, nil},
+		{"moved", "/buildbot builder=bar\ntext\n/buildbot builder=foo", "/buildbot builder=foo\n/buildbot builder=bar", nil, nil},
+		{"added", "/buildbot builder=foo\n/buildbot builder=bar", "/buildbot builder=foo", []string{"/buildbot builder=bar"}, nil},
+		{"removed", "/buildbot builder=foo", "/buildbot builder=foo\n/buildbot builder=bar", nil, []string{"/buildbot builder=bar"}},
+		{"changed", "/buildbot builder=baz", "/buildbot builder=bar", []string{"/buildbot builder=baz"}, []string{"/buildbot builder=bar"}},
+		{"duplicate removed", "/buildbot", "/buildbot\n/buildbot", nil, []string{"/buildbot"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, removed := changedCommandLines(command.ExtractCommandLines(tt.body), command.ExtractCommandLines(tt.previousBody))
+			texts := func(lines []command.CommandLine) []string {
+				var result []string
+				for _, line := range lines {
+					result = append(result, line.Text)
+				}
+				return result
+			}
+			require.Equal(t, tt.wantAdded, texts(added))
+			require.Equal(t, tt.wantRemoved, texts(removed))
+		})
+	}
+}
+
+// commentMetaOK returns the meta data of a build of the given builders that
+// was requested by the comment with the given ID.
+func commentMetaOK(commentID int64, builderNames ...string) CheckRunMeta {
+	cmd := commandOK(builderNames...)
+	cmd.CommentID = commentID
+	return CheckRunMeta{Command: cmd, BuildLogCommentID: 11}
+}
+
+func TestOnIssueCommentEventEdits(t *testing.T) {
+	pr := prOK()
+	pr.Number = github.Int(123)
+	pr.Base.Ref = github.String("main")
+	pr.Base.SHA = github.String("1ea3fd4f1b3f2b58a7a2f2c2a25d1ecf0c8ff40b")
+	pr.Head.Ref = github.String("feature")
+	// Check run 1 was requested by the comment, check run 2 by another one
+	checkRuns := []*github.CheckRun{
+		checkRunOK(1, "in_progress", "", commentMetaOK(99, "bar", "foo")),
+		checkRunOK(2, "in_progress", "", commentMetaOK(98, "bar")),
+	}
+	newMockServer := func(updates *[]github.UpdateCheckRunOptions, buildLogComments *[]string) *MockServer {
+		return NewMockServer(
+			mock.WithRequestMatch(
+				mock.GetReposPullsByOwnerByRepoByPullNumber,
+				pr,
+			),
+			mock.WithRequestMatchHandler(
+				mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write(mock.MustMarshal(github.ListCheckRunsResults{Total: github.Int(len(checkRuns)), CheckRuns: checkRuns}))
+				}),
+			),
+			mock.WithRequestMatch(
+				mock.PostReposCheckRunsByOwnerByRepo,
+				github.CheckRun{ID: github.Int64(3)},
+			),
+			recordCheckRunUpdates(t, updates),
+			recordComments(t, &[]string{}),
+			mock.WithRequestMatch(
+				mock.GetReposIssuesCommentsByOwnerByRepoByCommentId,
+				github.IssueComment{Body: github.String("build log")},
+			),
+			mock.WithRequestMatchHandler(
+				mock.PatchReposIssuesCommentsByOwnerByRepoByCommentId,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					comment := github.IssueComment{}
+					require.NoError(t, json.NewDecoder(r.Body).Decode(&comment))
+					*buildLogComments = append(*buildLogComments, comment.GetBody())
+					w.Write(mock.MustMarshal(comment))
+				}),
+			),
+		)
+	}
+	eventOK := func(action string, body string, previousBody string) *github.IssueCommentEvent {
+		event := issueCommentEventOK()
+		event.Action = github.String(action)
+		event.Comment.ID = github.Int64(99)
+		event.Comment.Body = github.String(body)
+		event.Changes = &github.EditChange{
+			Body: &github.EditBody{From: github.String(previousBody)},
+		}
+		event.Sender = &github.User{Login: github.String("johndoe")}
+		return event
+	}
+
+	t.Run("edited without changed commands", func(t *testing.T) {
+		srv := NewMockServer()
+		event := eventOK("edited", "Typo fixed\n/buildbot builder=foo builder=bar", "Typo\n/buildbot builder=foo builder=bar")
+		err := OnIssueCommentEventAny(srv)("1234", "edited", event)
+		require.NoError(t, err)
+		require.Empty(t, *srv.cancelledCheckRuns)
+		require.Empty(t, *srv.tryBotRuns)
+	})
+	t.Run("edited builder", func(t *testing.T) {
+		updates := []github.UpdateCheckRunOptions{}
+		buildLogComments := []string{}
+		srv := newMockServer(&updates, &buildLogComments)
+		event := eventOK("edited", "/buildbot builder=foo builder=baz", "/buildbot builder=foo builder=bar")
+		err := OnIssueCommentEventAny(srv)("1234", "edited", event)
+		require.NoError(t, err)
+		require.Equal(t, []int64{1}, *srv.cancelledCheckRuns)
+		require.Len(t, updates, 1)
+		require.Contains(t, updates[0].GetOutput().GetSummary(), `Cancelled because @johndoe removed it from <a href="http://github.com/jane/doe/example">the comment</a> that requested it.`)
+		require.Len(t, buildLogComments, 1)
+		// foo was only cancelled because it ran together with bar
+		require.Len(t, *srv.tryBotRuns, 1)
+		require.Contains(t, (*srv.tryBotRuns)[0], "--property=command_builders=baz;foo")
+	})
+	t.Run("deleted", func(t *testing.T) {
+		updates := []github.UpdateCheckRunOptions{}
+		buildLogComments := []string{}
+		srv := newMockServer(&updates, &buildLogComments)
+		event := eventOK("deleted", "/buildbot builder=foo builder=bar", "")
+		event.Changes = nil
+		err := OnIssueCommentEventAny(srv)("1234", "deleted", event)
+		require.NoError(t, err)
+		require.Equal(t, []int64{1}, *srv.cancelledCheckRuns)
+		require.Empty(t, *srv.tryBotRuns)
+		require.Len(t, buildLogComments, 1)
+		require.Contains(t, buildLogComments[0], "Cancelled because @johndoe deleted the comment that requested it.")
+	})
+}
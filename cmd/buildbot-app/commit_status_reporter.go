@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/google/go-github/v50/github"
+)
+
+const (
+	// commitStatusContextPrefix is put in front of the builder name to get
+	// the context of a commit status.
+	commitStatusContextPrefix = "buildbot/"
+
+	// maxCommitStatusDescriptionLength is the number of characters of a
+	// commit status description that GitHub accepts.
+	maxCommitStatusDescriptionLength = 140
+)
+
+// commitStatusReporter reports every builder of a build with a commit status
+// whose context is named after the builder (e.g. buildbot/linux). Commit
+// statuses don't remember the command that started a build, so builds
+// reported this way can't be cancelled, retried or skipped as duplicates.
+type commitStatusReporter struct{}
+
+func (commitStatusReporter) ExistingBuilds(gh *github.Client, appInstallationID int64, target *BuildTarget) ([]*github.CheckRun, error) {
+	return nil, nil
+}
+
+func (commitStatusReporter) TracksBuilds() bool {
+	return false
+}
+
+// BuildQueued creates a pending commit status for every builder of the
+// build.
+func (commitStatusReporter) BuildQueued(r BuildRequest, buildLogCommentID int64) (int64, error) {
+	for _, builderName := range r.Command.BuilderNames {
+		err := createCommitStatus(context.Background(), r.Github, r.Target, &github.RepoStatus{
+			State:       github.String("pending"),
+			Context:     github.String(commitStatusContextPrefix + builderName),
+			Description: github.String("Forwarded to Buildbot"),
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return 0, nil
+}
+
+// BuildUpdated sets the commit status of the build's builder to the state of
+// the build. The description is Buildbot's state string of the build and the
+// status links to the build page.
+func (commitStatusReporter) BuildUpdated(ctx context.Context, gh *github.Client, u BuildUpdate) (BuildReport, error) {
+	buildStatus := u.Status
+	conclusion := CheckRunConclusionFor(CheckRunStateFromBuildbotResult(buildStatus.Results), u.Command.IsMandatory)
+	state := "pending"
+	if buildStatus.Complete {
+		state = commitStatusState(conclusion)
+	}
+	err := createCommitStatus(ctx, gh, u.Target, &github.RepoStatus{
+		State:       github.String(state),
+		Context:     github.String(commitStatusContextPrefix + buildStatus.Builder.Name),
+		Description: github.String(commitStatusDescription(buildStatus.StateString)),
+		TargetURL:   github.String(buildStatus.URL),
+	})
+	if err != nil {
+		return BuildReport{}, err
+	}
+	return BuildReport{Conclusion: conclusion}, nil
+}
+
+// createCommitStatus creates the commit status on the head SHA of the
+// target.
+func createCommitStatus(ctx context.Context, gh *github.Client, target *BuildTarget, status *github.RepoStatus) error {
+	_, _, err := gh.Repositories.CreateStatus(ctx, target.BaseRepoOwner, target.BaseRepoName, target.HeadSHA, status)
+	if err != nil {
+		return fmt.Errorf("failed to create commit status %s: %w", status.GetContext(), err)
+	}
+	log.Printf("set commit status %s of %s to %s", status.GetContext(), target.HeadSHA, status.GetState())
+	return nil
+}
+
+// commitStatusState returns the state of a commit status for a completed
+// build with the given conclusion. Commit statuses have no neutral state, so
+// builds that don't fail the check succeed.
+func commitStatusState(conclusion CheckRunConclusion) string {
+	switch conclusion {
+	case CheckRunConclusionSuccess, CheckRunConclusionNeutral, CheckRunConclusionSkipped:
+		return "success"
+	case CheckRunConclusionFailure, CheckRunConclusionTimedOut:
+		return "failure"
+	}
+	return "error"
+}
+
+// commitStatusDescription shortens Buildbot's state string of a build to what
+// GitHub accepts as the description of a commit status.
+func commitStatusDescription(stateString string) string {
+	runes := []rune(stateString)
+	if len(runes) <= maxCommitStatusDescriptionLength {
+		return stateString
+	}
+	return string(runes[:maxCommitStatusDescriptionLength-1]) + "…"
+}
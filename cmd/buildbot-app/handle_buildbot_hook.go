@@ -13,7 +13,7 @@ import (
 // BuildStatus is what we expect buildbot to report back to the Github App, a
 // very simple struct for now.
 type BuildStatus struct {
-	GithubPullRequest
+	BuildTarget
 	BuildbotBuildStatus  string `json:"buildbot_build_status" binding:"required"`
 	BuildbotBuildHTMLURL string `json:"buildbot_build_html_url" binding:"required"`
 	BuildbotWorkerName   string `json:"buildbot_worker_name" binding:"required"`
@@ -6,12 +6,11 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/google/go-github/v50/github"
 	"github.com/kwk/buildbot-app/cmd/buildbot-app/buildbot_http_status_push"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
 )
 
 func (srv *AppServer) HandleBuildBotStatusHook() func(http.ResponseWriter, *http.Request) {
@@ -42,103 +41,100 @@ func (srv *AppServer) HandleBuildBotStatusHook() func(http.ResponseWriter, *http
 		}
 		log.Printf("buildStatus: %+v\n", buildStatus)
 
-		// Update the github check run associated with this build status
-		// Create a github client based for this app's installation
-		appInstallId, err := strconv.ParseInt(buildStatus.Properties.GithubAppInstallationID[0], 10, 64)
+		// Rebuild the objects that we've sent to Buildbot as properties
+		properties := buildStatus.Properties.Strings()
+		target, err := BuildTargetFromProperties(properties)
 		if err != nil {
-			err = fmt.Errorf("failed to parse buildStatus.Properties.GithubAppInstallationID[0] as int64: %w", err)
 			log.Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		gh, err := srv.NewGithubClient(appInstallId)
+		buildProperties, err := BuildPropertiesFromProperties(properties)
 		if err != nil {
-			err = fmt.Errorf("error creating github client: %w", err)
 			log.Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-
-		checkRunID, err := strconv.ParseInt(buildStatus.Properties.GithubCheckRunID[0], 10, 64)
+		cmd, err := command.FromProperties(properties)
 		if err != nil {
-			err = fmt.Errorf("failed to parse buildStatus.Properties.GithubCheckRunID[0] as int64: %w", err)
+			err = fmt.Errorf("failed to read command from properties: %w", err)
 			log.Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		repoOwner := target.BaseRepoOwner
+		repoName := target.BaseRepoName
+		checkRunID := buildProperties.CheckRunID
 
-		checkRun, _, err := gh.Checks.GetCheckRun(req.Context(), buildStatus.Properties.GithubPullRequestRepoOwner[0], buildStatus.Properties.GithubPullRequestRepoName[0], checkRunID)
+		// Report the build status on github (e.g. in the check run)
+		// Create a github client based for this app's installation
+		gh, err := srv.NewGithubClient(buildProperties.AppInstallationID)
 		if err != nil {
-			err = fmt.Errorf("error getting check run: %w", err)
+			err = fmt.Errorf("error creating github client: %w", err)
 			log.Println(err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		conclusion := CheckRunStateFromBuildbotResult(buildStatus.Results)
-		if !buildStatus.Properties.CommandIsMandatory {
-			if conclusion != CheckRunConclusionSuccess {
-				conclusion = CheckRunConclusionNeutral
+		// Remember running builds so that they can be cancelled. Only builds
+		// with a check run can be cancelled.
+		if buildStatus.Complete {
+			if checkRunID != 0 {
+				srv.builds.Remove(checkRunID, buildStatus.Buildid)
+				srv.timeouts.Stop(checkRunID)
+				if target.IsPullRequest() {
+					srv.prBuilds.Remove(repoOwner, repoName, target.Number, checkRunID)
+				}
 			}
+			// Count the time the build spent on its worker against the daily
+			// quotas of the commenter and the repository.
+			srv.limiter.AddWorkerTime(repoOwner, repoName, cmd.CommentAuthor, chargedWorkerTime(buildStatus))
+		} else if checkRunID != 0 {
+			srv.builds.Add(checkRunID, buildStatus.Buildid)
 		}
 
-		now := time.Now()
-		newStateString := fmt.Sprintf("[Builder: %s]: %s ([log](%s))", buildStatus.Builder.Name, buildStatus.StateString, buildStatus.URL)
-		if checkRun.Output != nil && checkRun.Output.Summary != nil {
-			newStateString = strings.Join([]string{*checkRun.Output.Summary, WrapMsgWithTimePrefix(newStateString, now)}, "\n")
-		}
-
-		title := "Buildbot Status Log"
-		if buildStatus.Properties.CommandIsMandatory {
-			title = fmt.Sprintf("%s (check is optional)", title)
-		}
-		_, _, err = gh.Checks.UpdateCheckRun(req.Context(), buildStatus.Properties.GithubPullRequestRepoOwner[0], buildStatus.Properties.GithubPullRequestRepoName[0], checkRunID, github.UpdateCheckRunOptions{
-			Name:       *checkRun.Name,
-			Status:     github.String(string(CheckRunStateCompleted)),
-			Conclusion: github.String(string(conclusion)),
-			DetailsURL: github.String(buildStatus.URL),
-			Output: &github.CheckRunOutput{
-				Title:   github.String(title),
-				Summary: github.String(newStateString),
-				Text:    github.String(fmt.Sprintf("[Buildbot Build Page](%s)", buildStatus.URL)),
-			},
-			Actions: []*github.CheckRunAction{
-				{
-					Label:       "Make check required",
-					Description: "Make check required to pass",
-					Identifier:  "MakeMandatory",
-				},
-				{
-					Label:       "Make check optional",
-					Description: "This check is optional",
-					Identifier:  "MakeOptional",
-				},
-				{
-					Label:       "Rerun check",
-					Description: "Reruns the check",
-					Identifier:  "ReRunCheck",
-				},
-			},
+		report, err := reporterOfBuild(buildProperties).BuildUpdated(req.Context(), gh, BuildUpdate{
+			Target:     target,
+			Properties: buildProperties,
+			Command:    cmd,
+			Status:     &buildStatus,
 		})
 		if err != nil {
-			err := fmt.Errorf("failed to update try bot check run: %w", err)
 			log.Println(err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		log.Printf("updated github check run: %s\n", *checkRun.Name)
-		log.Printf("check run details: %s\n", buildStatus.URL)
+		now := time.Now()
+
+		// Builds of a matrix share one build log comment and we don't want
+		// their updates to overwrite each other. The same goes for the
+		// reaction on the comment that requested the builds.
+		srv.buildLogCommentMu.Lock()
+		defer srv.buildLogCommentMu.Unlock()
+
+		// Once all builds of a comment are complete, the reaction on the
+		// comment shows whether they succeeded.
+		if buildStatus.Complete && report.CommentID != 0 {
+			config, err := srv.GetRepoConfig(buildProperties.AppInstallationID, repoOwner, repoName)
+			if err != nil {
+				log.Printf("failed to get repository configuration: %v", err)
+			} else if config.Reactions.Enabled {
+				if err := reactToFinishedBuilds(gh, buildProperties.AppInstallationID, repoOwner, repoName, target.HeadSHA, report.CommentID, checkRunID, report.Conclusion); err != nil {
+					log.Println(err)
+				}
+			}
+		}
 
 		// Update the build log comment
 		//-------------------------------------
-		buildLogCommentID, err := strconv.ParseInt(buildStatus.Properties.GithubBuildLogCommentID[0], 10, 64)
-		if err != nil {
-			err = fmt.Errorf("failed to parse buildStatus.Properties.GithubBuildLogCommentID[0] as int64: %w", err)
-			log.Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		buildLogCommentID := buildProperties.BuildLogCommentID
+		// The repository may only want reactions and builds without a pull
+		// request (e.g. of a push to a branch) have nowhere to comment.
+		if buildLogCommentID == 0 || !target.IsPullRequest() {
+			io.WriteString(w, "thank you for calling back to the buildbot-app")
 			return
 		}
-		buildLogComment, _, err := gh.Issues.GetComment(req.Context(), buildStatus.Properties.GithubPullRequestRepoOwner[0], buildStatus.Properties.GithubPullRequestRepoName[0], buildLogCommentID)
+		buildLogComment, _, err := gh.Issues.GetComment(req.Context(), repoOwner, repoName, buildLogCommentID)
 		if err != nil {
 			err := fmt.Errorf("failed to get build log comment: %w", err)
 			log.Println(err)
@@ -148,7 +144,25 @@ func (srv *AppServer) HandleBuildBotStatusHook() func(http.ResponseWriter, *http
 		buildLogCommentBody := buildLogComment.Body
 		newBuildLogComment := buildLogComment
 		newBuildLogComment.Body = github.String(fmt.Sprintf(`%s<br/><strong>%s</strong> <i>[Builder: %s]</i> %s (<a href="%s">log</a>)`, *buildLogCommentBody, now.Format(time.RFC1123Z), buildStatus.Builder.Name, buildStatus.StateString, buildStatus.URL))
-		_, _, err = gh.Issues.EditComment(req.Context(), buildStatus.Properties.GithubPullRequestRepoOwner[0], buildStatus.Properties.GithubPullRequestRepoName[0], buildLogCommentID, newBuildLogComment)
+
+		// Fill in the cell of this build if the comment shows a build matrix.
+		// The worker reports its attributes (e.g. os and arch) as properties.
+		// We fall back to the command's selectors for anything it doesn't
+		// report.
+		selectors := cmd.Selectors()
+		for _, attr := range command.SelectorAttributes {
+			if value := properties[attr]; value != "" {
+				selectors[attr] = value
+			}
+		}
+		body, err := UpdateBuildMatrixInComment(newBuildLogComment.GetBody(), selectors, fmt.Sprintf("[%s](%s)", buildStatus.StateString, buildStatus.URL))
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		newBuildLogComment.Body = github.String(body)
+		_, _, err = gh.Issues.EditComment(req.Context(), repoOwner, repoName, buildLogCommentID, newBuildLogComment)
 		if err != nil {
 			err := fmt.Errorf("failed to edit build log comment: %w", err)
 			log.Println(err)
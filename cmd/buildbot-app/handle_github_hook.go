@@ -4,12 +4,21 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+
+	"github.com/google/go-github/v50/github"
 )
 
 func (srv *AppServer) HandleGithubHook() func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
 		log.Println("/github-hook")
-		err := srv.GithubEventHandler.HandleEventRequest(req)
+		var err error
+		// githubevents doesn't know about the merge queue, so we handle its
+		// events ourselves.
+		if github.WebHookType(req) == "merge_group" {
+			err = handleMergeGroupEventRequest(req, srv.githubWebhookSecret, OnMergeGroupEvent(srv))
+		} else {
+			err = srv.GithubEventHandler.HandleEventRequest(req)
+		}
 		if err != nil {
 			log.Printf("error while processing request: %+v", err)
 			fmt.Println("error")
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/cbrgm/githubevents/githubevents"
+	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+)
+
+// tag::label_builds[]
+// LabelBuilds map the names of pull request labels to the options of the build
+// that adding the label starts (e.g. "buildbot:full": "preset=full"). Removing
+// the label cancels the build.
+//
+// Labels that aren't mapped but start with BuildLabelPrefix build the options
+// after the prefix (e.g. buildbot:builder=linux), after aliases are expanded.
+type LabelBuilds map[string]BuildOptions
+
+// BuildLabelPrefix starts the names of labels that build the options after it.
+const BuildLabelPrefix = "buildbot:"
+
+// end::label_builds[]
+
+// Validate returns an error if the command of a label is invalid or wouldn't
+// build anything.
+func (l LabelBuilds) Validate(c RepoConfig) error {
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("label names must not be empty")
+		}
+		if err := c.validateBuildCommand(l[name].commandLine()); err != nil {
+			return fmt.Errorf("label %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// commandLineOfLabel returns the command line of the build that the label
+// starts and false if the label doesn't start a build. Like on GitHub, label
+// names are case-insensitive.
+func (c RepoConfig) commandLineOfLabel(label string) (string, bool) {
+	for name, options := range c.LabelBuilds {
+		if strings.EqualFold(name, label) {
+			return options.commandLine(), true
+		}
+	}
+	if len(label) > len(BuildLabelPrefix) && strings.EqualFold(label[:len(BuildLabelPrefix)], BuildLabelPrefix) {
+		return BuildOptions(label[len(BuildLabelPrefix):]).commandLine(), true
+	}
+	return "", false
+}
+
+// OnPullRequestEventLabelBuild starts the build of a label that was added to
+// a pull request and cancels the in-flight builds of a label that was
+// removed. The build goes through the same steps as a /buildbot comment of
+// the user who added the label, so authorization, approval and rate limits
+// apply to it as well.
+func OnPullRequestEventLabelBuild(srv Server) githubevents.PullRequestEventHandleFunc {
+	return func(deliveryID string, eventName string, event *github.PullRequestEvent) error {
+		if event == nil || event.PullRequest == nil || event.Label == nil {
+			return nil
+		}
+		action := event.GetAction()
+		if action != "labeled" && action != "unlabeled" {
+			return nil
+		}
+		appInstallationID := event.GetInstallation().GetID()
+		repoOwner := event.GetRepo().GetOwner().GetLogin()
+		repoName := event.GetRepo().GetName()
+		pr := event.GetPullRequest()
+		label := event.GetLabel().GetName()
+
+		// Most labels have nothing to do with builds, so an invalid
+		// configuration is reported on the next /buildbot comment.
+		config, err := srv.GetRepoConfig(appInstallationID, repoOwner, repoName)
+		var configErr *RepoConfigError
+		if errors.As(err, &configErr) {
+			log.Printf("no label builds for %s/%s#%d: %v", repoOwner, repoName, pr.GetNumber(), configErr)
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to get repository configuration: %w", err)
+		}
+		commandLine, ok := config.commandLineOfLabel(label)
+		if !ok {
+			return nil
+		}
+
+		gh, err := srv.NewGithubClient(appInstallationID)
+		if err != nil {
+			return fmt.Errorf("error creating github client: %w", err)
+		}
+		if action == "unlabeled" {
+			reason := fmt.Sprintf("Cancelled because @%s removed the label <code>%s</code>.", event.GetSender().GetLogin(), label)
+			_, err := cancelPullRequestCheckRuns(srv, gh, appInstallationID, pr, reason, func(meta *CheckRunMeta) bool {
+				return strings.EqualFold(meta.Command.Label, label)
+			})
+			return err
+		}
+		if pr.GetState() == "closed" {
+			log.Printf("not building label %q of closed pull request %s/%s#%d", label, repoOwner, repoName, pr.GetNumber())
+			return nil
+		}
+
+		r, err := newPullRequestEventRequest(srv, gh, config, event, command.TriggerLabel)
+		if err != nil {
+			return err
+		}
+		r.label = label
+		r.thankYouComment = fmt.Sprintf(
+			`@%s added the label <code>%s</code> to this pull request, so the <a href="%s">buildbot app</a> builds it. `,
+			r.commenter.Login,
+			label,
+			config.docsURL(),
+		)
+		// Mapped labels are validated with the configuration but the options
+		// of a prefixed label are only known now. Parse errors are explained
+		// by handleCommandLine.
+		cmd, err := command.FromStringWithPresets(config.ExpandAliases(commandLine), config.Presets)
+		if err == nil && cmd.Subcommand != command.SubcommandBuild {
+			return r.reply(fmt.Sprintf("Sorry, but labels can only start builds, not <code>%s</code>.", cmd.Subcommand))
+		}
+		return r.handleCommandLine(command.CommandLine{Number: 1, Text: commandLine})
+	}
+}
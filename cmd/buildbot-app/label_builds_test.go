@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+	"github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepoConfig_commandLineOfLabel(t *testing.T) {
+	config := RepoConfig{LabelBuilds: LabelBuilds{"Buildbot:Full": "builder=linux builder=macos"}}
+	tests := []struct {
+		label  string
+		want   string
+		wantOk bool
+	}{
+		{"buildbot:full", "/buildbot builder=linux builder=macos", true},
+		{"buildbot:builder=docs", "/buildbot builder=docs", true},
+		{"BUILDBOT:os=linux", "/buildbot os=linux", true},
+		{"buildbot:", "", false},
+		{"bug", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			got, ok := config.commandLineOfLabel(tt.label)
+			require.Equal(t, tt.wantOk, ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// labelMetaOK returns the meta data of a build that was started by the given
+// label.
+func labelMetaOK(label string) CheckRunMeta {
+	cmd := commandOK("linux")
+	cmd.Trigger = command.TriggerLabel
+	cmd.Label = label
+	return CheckRunMeta{Command: cmd}
+}
+
+func TestOnPullRequestEventLabelBuild(t *testing.T) {
+	prEventOK := func(action string, label string) *github.PullRequestEvent {
+		pr := prOK()
+		pr.Mergeable = nil
+		pr.Number = github.Int(123)
+		pr.User = &github.User{Login: github.String("johndoe")}
+		pr.AuthorAssociation = github.String("MEMBER")
+		pr.Base.Ref = github.String("main")
+		pr.Base.SHA = github.String("1ea3fd4f1b3f2b58a7a2f2c2a25d1ecf0c8ff40b")
+		pr.Head.Ref = github.String("feature")
+		return &github.PullRequestEvent{
+			Action:       github.String(action),
+			PullRequest:  &pr,
+			Label:        &github.Label{Name: github.String(label)},
+			Installation: &github.Installation{ID: github.Int64(1234)},
+			Repo: &github.Repository{
+				Owner: &github.User{Login: github.String("janedoe")},
+				Name:  github.String("examplerepo"),
+			},
+			Sender: &github.User{Login: github.String("johndoe")},
+		}
+	}
+	config := &RepoConfig{
+		Aliases:     map[string]string{"docs": "builder=docs"},
+		LabelBuilds: LabelBuilds{"buildbot:full": "builder=linux builder=macos"},
+	}
+
+	tests := []struct {
+		name          string
+		event         *github.PullRequestEvent
+		wantCheckRuns []string
+		wantComment   string
+		wantCancelled []int64
+	}{
+		{
+			"mapped label",
+			prEventOK("labeled", "buildbot:full"),
+			[]string{"[label] /buildbot mandatory=true force=false builder=[linux macos]"},
+			"@johndoe added the label <code>buildbot:full</code> to this pull request, so the",
+			[]int64{},
+		},
+		{
+			"prefixed label with alias",
+			prEventOK("labeled", "buildbot:docs"),
+			[]string{"[label] /buildbot mandatory=true force=false builder=[docs]"},
+			"@johndoe added the label <code>buildbot:docs</code> to this pull request, so the",
+			[]int64{},
+		},
+		{
+			"prefixed label with subcommand",
+			prEventOK("labeled", "buildbot:cancel"),
+			[]string{},
+			"Sorry, but labels can only start builds, not <code>cancel</code>.",
+			[]int64{},
+		},
+		{"other label", prEventOK("labeled", "bug"), []string{}, "", []int64{}},
+		{"removed label", prEventOK("unlabeled", "buildbot:full"), []string{}, "", []int64{1}},
+		{"other action", prEventOK("opened", "buildbot:full"), []string{}, "", []int64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkRunNames := []string{}
+			comments := []string{}
+			srv := NewMockServer(
+				mock.WithRequestMatchHandler(
+					mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						checkRuns := []*github.CheckRun{checkRunOK(1, "in_progress", "", labelMetaOK("BuildBot:Full")), checkRunOK(2, "in_progress", "", labelMetaOK("buildbot:docs"))}
+						if tt.event.GetAction() == "labeled" {
+							checkRuns = nil
+						}
+						w.Write(mock.MustMarshal(github.ListCheckRunsResults{Total: github.Int(len(checkRuns)), CheckRuns: checkRuns}))
+					}),
+				),
+				recordComments(t, &comments),
+				recordCheckRunUpdates(t, &[]github.UpdateCheckRunOptions{}),
+				mock.WithRequestMatchHandler(
+					mock.PostReposCheckRunsByOwnerByRepo,
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						opts := github.CreateCheckRunOptions{}
+						require.NoError(t, json.NewDecoder(r.Body).Decode(&opts))
+						checkRunNames = append(checkRunNames, opts.Name)
+						meta, err := CheckRunMetaFromCheckRun(&github.CheckRun{ExternalID: opts.ExternalID})
+						require.NoError(t, err)
+						require.Equal(t, tt.event.GetLabel().GetName(), meta.Command.Label)
+						w.Write(mock.MustMarshal(github.CheckRun{ID: github.Int64(int64(len(checkRunNames)))}))
+					}),
+				),
+			)
+			srv.repoConfig = config
+			err := OnPullRequestEventLabelBuild(srv)("1234", "pull_request", tt.event)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantCheckRuns, checkRunNames)
+			require.Len(t, *srv.tryBotRuns, len(tt.wantCheckRuns))
+			for _, run := range *srv.tryBotRuns {
+				require.Contains(t, run, "--property=command_trigger=label")
+				require.Contains(t, run, "--property=command_label="+tt.event.GetLabel().GetName())
+			}
+			if tt.wantComment == "" {
+				require.Empty(t, comments)
+			} else {
+				require.Len(t, comments, 1)
+				require.Contains(t, comments[0], tt.wantComment)
+			}
+			require.Equal(t, tt.wantCancelled, *srv.cancelledCheckRuns)
+		})
+	}
+}
@@ -19,6 +19,27 @@ func main() {
 	// This is where we're going to handle /buildbot comments made on github
 	srv.GithubEventHandler.OnIssueCommentEventAny(OnIssueCommentEventAny(srv))
 
+	// Pull requests that are opened, pushed to, reopened or marked as ready
+	// for review are built automatically if the repository wants that.
+	srv.GithubEventHandler.OnPullRequestEventOpened(OnPullRequestEventAutoBuild(srv))
+	srv.GithubEventHandler.OnPullRequestEventReopened(OnPullRequestEventAutoBuild(srv))
+	srv.GithubEventHandler.OnPullRequestEventReadyForReview(OnPullRequestEventAutoBuild(srv))
+
+	// Pull requests that get a label of a build are built and removing the
+	// label cancels the build.
+	srv.GithubEventHandler.OnPullRequestEventLabeled(OnPullRequestEventLabelBuild(srv))
+	srv.GithubEventHandler.OnPullRequestEventUnlabeled(OnPullRequestEventLabelBuild(srv))
+
+	// Builds of old head SHAs, of closed pull requests and of pull requests
+	// that were converted to a draft are cancelled.
+	srv.GithubEventHandler.OnPullRequestEventSynchronize(OnPullRequestEventSynchronize(srv))
+	srv.GithubEventHandler.OnPullRequestEventClosed(OnPullRequestEventCancelBuilds(srv))
+	srv.GithubEventHandler.OnPullRequestEventConvertedToDraft(OnPullRequestEventCancelBuilds(srv))
+
+	// Pushes to branches like main or release/* are built if the repository
+	// wants that.
+	srv.GithubEventHandler.OnPushEventAny(OnPushEventBranchBuild(srv))
+
 	// When buildbot wants to talk to the Github App it can use this endpoint
 	srv.Mux.HandleFunc("/buildbot-hook", srv.HandleBuildBotHook())
 	srv.Mux.HandleFunc("/buildbot-status-hook", srv.HandleBuildBotStatusHook())
@@ -31,9 +52,12 @@ func main() {
 
 	// This gets called when you have a check run with an action and someone
 	// clicks on the button in the github check run page.
-	// TODO(kwk): Think if this could be useful.
-	srv.GithubEventHandler.OnCheckRunEventRequestAction(srv.OnCheckRunEventRequestAction())
-	srv.GithubEventHandler.OnCheckRunEventReRequested(srv.OnCheckRunEventReRequested())
+	srv.GithubEventHandler.OnCheckRunEventRequestAction(OnCheckRunEventRequestAction(srv))
+
+	// When someone uses GitHub's own links to re-run a check run or all
+	// (failed) check runs of a check suite.
+	srv.GithubEventHandler.OnCheckRunEventReRequested(OnCheckRunEventReRequested(srv))
+	srv.GithubEventHandler.OnCheckSuiteEventReRequested(OnCheckSuiteEventReRequested(srv))
 
 	// This is the entrypoint for Webhooks coming from Github
 	// NOTE: Make sure to have setup the GithubEventHandler beforehand
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+)
+
+// MatrixBuildRequest expands a command like
+// "/buildbot os=linux,macos arch=x86_64,aarch64" into one build per
+// combination of attribute values. Every combination gets its own check run
+// and all of them log to one build log comment that shows a BuildMatrix.
+type MatrixBuildRequest struct {
+	Server            Server
+	Github            *github.Client
+	AppInstallationID int64
+	Target            *BuildTarget
+	Command           *command.Command
+	// ThankYouComment is put in front of every comment that we write in
+	// response to this request.
+	ThankYouComment string
+}
+
+// Run resolves the builders of every combination, writes the build log
+// comment with the matrix and then runs a BuildRequest per combination.
+// Combinations without a matching builder or with an existing check run for
+// the target's head SHA (unless forced) are shown in the matrix but not
+// built.
+func (r MatrixBuildRequest) Run() error {
+	gh := r.Github
+	target := r.Target
+	repoOwner := target.BaseRepoOwner
+	repoName := target.BaseRepoName
+
+	inventory, err := r.Server.GetBuilderInventory()
+	if err != nil {
+		return fmt.Errorf("failed to get builder inventory: %w", err)
+	}
+	config, err := r.Server.GetRepoConfig(r.AppInstallationID, repoOwner, repoName)
+	if err != nil {
+		return fmt.Errorf("failed to get repository configuration: %w", err)
+	}
+	inventory = config.FilterInventory(inventory)
+	reporter, err := newReporter(r.Server, r.AppInstallationID, config)
+	if err != nil {
+		return err
+	}
+	checkRuns, err := reporter.ExistingBuilds(gh, r.AppInstallationID, target)
+	if err != nil {
+		return fmt.Errorf("failed to get all check runs for build target: %w", err)
+	}
+	existingCheckRuns := map[string]*github.CheckRun{}
+	for _, checkRun := range checkRuns {
+		existingCheckRuns[checkRun.GetName()] = checkRun
+	}
+
+	cells := r.Command.Matrix()
+	matrix := NewBuildMatrix(cells, "requested")
+	buildable := []*command.Command{}
+	for _, cell := range cells {
+		if checkRun, ok := existingCheckRuns[cell.ToGithubCheckNameString()]; ok && !cell.Force {
+			matrix.Set(cell.Selectors(), fmt.Sprintf("[already requested](%s)", checkRun.GetHTMLURL()))
+			continue
+		}
+		var selectorErr *command.SelectorError
+		if err := cell.ResolveBuilderNames(inventory); errors.As(err, &selectorErr) {
+			matrix.Set(cell.Selectors(), "no matching builder")
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to resolve builder names: %w", err)
+		}
+		buildable = append(buildable, cell)
+	}
+
+	table, err := matrix.Markdown()
+	if err != nil {
+		return err
+	}
+
+	if r.Command.DryRun {
+		return r.replyWithDryRun(table, buildable, existingCheckRuns)
+	}
+
+	// Without a build log comment, the combinations that aren't built are
+	// only shown in the log. Builds that aren't for a pull request (e.g. of a
+	// merge group) have nowhere to write a comment.
+	var buildLogCommentID int64
+	if target.IsPullRequest() && config.writesBuildLogComment(r.Command) {
+		buildLogComment, _, err := gh.Issues.CreateComment(context.Background(), repoOwner, repoName, target.Number, &github.IssueComment{
+			Body: github.String(fmt.Sprintf("%s%s\n\n%s\n", r.ThankYouComment, buildLogIntro(reporter), table)),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create build-log comment: %w", err)
+		}
+		buildLogCommentID = buildLogComment.GetID()
+	} else {
+		log.Printf("build matrix of comment %d:\n%s", r.Command.CommentID, table)
+	}
+
+	// Every combination is its own request. An error in one doesn't stop the
+	// others from being built.
+	errs := []string{}
+	for _, cell := range buildable {
+		err := BuildRequest{
+			Server:            r.Server,
+			Github:            gh,
+			AppInstallationID: r.AppInstallationID,
+			Target:            target,
+			Command:           cell,
+			ThankYouComment:   r.ThankYouComment,
+			BuildLogCommentID: buildLogCommentID,
+			Reporter:          reporter,
+		}.Run()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", cell.ToGithubCheckNameString(), err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to build %d of %d combination(s): %s", len(errs), len(buildable), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// replyWithDryRun writes a comment with the matrix and a preview of every
+// build that would be run (see BuildRequest.dryRunPreview).
+func (r MatrixBuildRequest) replyWithDryRun(table string, buildable []*command.Command, existingCheckRuns map[string]*github.CheckRun) error {
+	target := r.Target
+	var sb strings.Builder
+	sb.WriteString(r.ThankYouComment + "This is a dry run, so nothing was built and no check run was created.\n\n")
+	sb.WriteString(table + "\n")
+	for _, cell := range buildable {
+		fmt.Fprintf(&sb, "\n#### `%s`\n\n", selectorLabel(cell.Selectors()))
+		sb.WriteString(BuildRequest{
+			Github:            r.Github,
+			AppInstallationID: r.AppInstallationID,
+			Target:            target,
+			Command:           cell,
+		}.dryRunPreview(existingCheckRuns[cell.ToGithubCheckNameString()]))
+	}
+	_, _, err := r.Github.Issues.CreateComment(context.Background(), target.BaseRepoOwner, target.BaseRepoName, target.Number, &github.IssueComment{
+		Body: github.String(sb.String()),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write dry-run comment: %w", err)
+	}
+	return nil
+}
+
+// selectorLabel returns the selectors in the order of
+// command.SelectorAttributes (e.g. "os=linux arch=aarch64").
+func selectorLabel(selectors map[string]string) string {
+	labels := []string{}
+	for _, attr := range command.SelectorAttributes {
+		if value, ok := selectors[attr]; ok {
+			labels = append(labels, fmt.Sprintf("%s=%s", attr, value))
+		}
+	}
+	return strings.Join(labels, " ")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+)
+
+// tag::merge_queue[]
+// MergeQueueConfig tells the app to build the merge groups of GitHub's merge
+// queue, so that mandatory builds can gate the queue.
+type MergeQueueConfig struct {
+	// Build merge groups
+	Enabled bool `yaml:"enabled"`
+	// The options of the build (e.g. "preset=full")
+	Command BuildOptions `yaml:"command"`
+}
+
+// end::merge_queue[]
+
+// MergeGroupEventHandleFunc handles a merge_group event like the handlers of
+// githubevents.EventHandler handle the events that it knows about.
+type MergeGroupEventHandleFunc func(deliveryID string, eventName string, event *github.MergeGroupEvent) error
+
+// handleMergeGroupEventRequest validates and parses a merge_group webhook
+// request and passes the event to the handler. githubevents.EventHandler
+// silently drops merge_group events because it doesn't know them.
+func handleMergeGroupEventRequest(req *http.Request, webhookSecret string, handler MergeGroupEventHandleFunc) error {
+	payload, err := github.ValidatePayload(req, []byte(webhookSecret))
+	if err != nil {
+		return fmt.Errorf("could not validate webhook payload: %w", err)
+	}
+	event, err := github.ParseWebHook(github.WebHookType(req), payload)
+	if err != nil {
+		return fmt.Errorf("could not parse webhook: %w", err)
+	}
+	mergeGroupEvent, ok := event.(*github.MergeGroupEvent)
+	if !ok {
+		return fmt.Errorf("unexpected event type %T", event)
+	}
+	return handler(github.DeliveryID(req), github.WebHookType(req), mergeGroupEvent)
+}
+
+// OnMergeGroupEvent builds the merge group's head SHA with the repository's
+// MergeQueue command when GitHub requests the checks of the group and cancels
+// the builds when the group is destroyed (e.g. because it was merged or a pull
+// request was removed from the queue).
+//
+// The authorization policy, approvals and rate limits deliberately don't apply:
+// GitHub only lets users with write access put pull requests into the merge
+// queue, which is a stronger check than the policy, and the merge queue waits
+// for the required check run, so a denied, parked or rate-limited build would
+// block the queue until the merge group times out.
+func OnMergeGroupEvent(srv Server) MergeGroupEventHandleFunc {
+	return func(deliveryID string, eventName string, event *github.MergeGroupEvent) error {
+		if event == nil || event.MergeGroup == nil {
+			return nil
+		}
+		action := event.GetAction()
+		if action != "checks_requested" && action != "destroyed" {
+			return nil
+		}
+		appInstallationID := event.GetInstallation().GetID()
+		repoOwner := event.GetRepo().GetOwner().GetLogin()
+		repoName := event.GetRepo().GetName()
+		headSHA := event.GetMergeGroup().GetHeadSHA()
+
+		config, err := srv.GetRepoConfig(appInstallationID, repoOwner, repoName)
+		if err != nil {
+			return fmt.Errorf("failed to get repository configuration: %w", err)
+		}
+		if !config.MergeQueue.Enabled {
+			return nil
+		}
+		gh, err := srv.NewGithubClient(appInstallationID)
+		if err != nil {
+			return fmt.Errorf("error creating github client: %w", err)
+		}
+
+		if action == "destroyed" {
+			_, err := cancelCheckRunsOfRef(srv, gh, appInstallationID, repoOwner, repoName, headSHA, "Cancelled because the merge group was destroyed.", func(meta *CheckRunMeta) bool {
+				return meta.Command.Trigger == command.TriggerMergeGroup
+			})
+			return err
+		}
+
+		// No authorization, approval or rate limit here on purpose, see above.
+		cmd, err := configuredBuildCommand(srv, config, config.MergeQueue.Command)
+		if err != nil {
+			return fmt.Errorf("merge queue: %w", err)
+		}
+		cmd.CommentAuthor = event.GetSender().GetLogin()
+		cmd.Trigger = command.TriggerMergeGroup
+		log.Printf("building merge group %s of %s/%s", headSHA, repoOwner, repoName)
+		return runBuild(srv, gh, appInstallationID, mergeGroupBuildTarget(event), cmd, "")
+	}
+}
+
+// mergeGroupBuildTarget returns the target of a build of the merge group.
+// There is no pull request to write comments to, so builds of the group don't
+// have build log comments.
+func mergeGroupBuildTarget(event *github.MergeGroupEvent) *BuildTarget {
+	group := event.GetMergeGroup()
+	return &BuildTarget{
+		BaseRepoName:  event.GetRepo().GetName(),
+		BaseRepoOwner: event.GetRepo().GetOwner().GetLogin(),
+		BaseRef:       strings.TrimPrefix(group.GetBaseRef(), "refs/heads/"),
+		BaseSHA:       group.GetBaseSHA(),
+		HeadRef:       strings.TrimPrefix(group.GetHeadRef(), "refs/heads/"),
+		HeadSHA:       group.GetHeadSHA(),
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+	"github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func mergeGroupEventOK(action string) *github.MergeGroupEvent {
+	return &github.MergeGroupEvent{
+		Action: github.String(action),
+		MergeGroup: &github.MergeGroup{
+			HeadSHA: github.String("ec26c3e57ca3a959ca5aad62de7213c562f8c821"),
+			HeadRef: github.String("refs/heads/gh-readonly-queue/main/pr-123-1ea3fd4f1b3f2b58a7a2f2c2a25d1ecf0c8ff40b"),
+			BaseSHA: github.String("1ea3fd4f1b3f2b58a7a2f2c2a25d1ecf0c8ff40b"),
+			BaseRef: github.String("refs/heads/main"),
+		},
+		Installation: &github.Installation{ID: github.Int64(1234)},
+		Repo: &github.Repository{
+			Owner: &github.User{Login: github.String("janedoe")},
+			Name:  github.String("examplerepo"),
+		},
+		Sender: &github.User{Login: github.String("johndoe")},
+	}
+}
+
+func TestHandleMergeGroupEventRequest(t *testing.T) {
+	payload, err := json.Marshal(mergeGroupEventOK("checks_requested"))
+	require.NoError(t, err)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(payload)
+	newRequest := func(signature string) *http.Request {
+		req, err := http.NewRequest(http.MethodPost, "/github-hook", bytes.NewReader(payload))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-GitHub-Event", "merge_group")
+		req.Header.Set("X-GitHub-Delivery", "1234")
+		req.Header.Set("X-Hub-Signature-256", "sha256="+signature)
+		return req
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		var got *github.MergeGroupEvent
+		err := handleMergeGroupEventRequest(newRequest(hex.EncodeToString(mac.Sum(nil))), "secret", func(deliveryID string, eventName string, event *github.MergeGroupEvent) error {
+			require.Equal(t, "1234", deliveryID)
+			require.Equal(t, "merge_group", eventName)
+			got = event
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, "ec26c3e57ca3a959ca5aad62de7213c562f8c821", got.GetMergeGroup().GetHeadSHA())
+	})
+	t.Run("invalid signature", func(t *testing.T) {
+		err := handleMergeGroupEventRequest(newRequest("0000"), "secret", func(deliveryID string, eventName string, event *github.MergeGroupEvent) error {
+			t.Fatal("handler must not be called")
+			return nil
+		})
+		require.ErrorContains(t, err, "could not validate webhook payload")
+	})
+}
+
+func TestOnMergeGroupEvent(t *testing.T) {
+	mergeGroupCheckRun := func(id int64, trigger string) *github.CheckRun {
+		cmd := commandOK("linux")
+		cmd.Trigger = trigger
+		return checkRunOK(id, "in_progress", "", CheckRunMeta{Command: cmd})
+	}
+	enabled := &RepoConfig{
+		DefaultBuilders: []string{"linux"},
+		MergeQueue:      MergeQueueConfig{Enabled: true},
+	}
+
+	tests := []struct {
+		name          string
+		event         *github.MergeGroupEvent
+		config        *RepoConfig
+		wantCheckRuns []string
+		wantCancelled []int64
+	}{
+		{"checks requested", mergeGroupEventOK("checks_requested"), enabled, []string{"[merge_group] /buildbot mandatory=true force=false builder=[linux]"}, []int64{}},
+		{"disabled", mergeGroupEventOK("checks_requested"), &RepoConfig{DefaultBuilders: []string{"linux"}}, []string{}, []int64{}},
+		{"destroyed", mergeGroupEventOK("destroyed"), enabled, []string{}, []int64{1}},
+		{"other action", mergeGroupEventOK("unknown"), enabled, []string{}, []int64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkRunNames := []string{}
+			comments := []string{}
+			srv := NewMockServer(
+				mock.WithRequestMatchHandler(
+					mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						require.Equal(t, "/repos/janedoe/examplerepo/commits/ec26c3e57ca3a959ca5aad62de7213c562f8c821/check-runs", r.URL.Path)
+						checkRuns := []*github.CheckRun{mergeGroupCheckRun(1, command.TriggerMergeGroup), mergeGroupCheckRun(2, command.TriggerAutomatic)}
+						if tt.event.GetAction() != "destroyed" {
+							checkRuns = nil
+						}
+						w.Write(mock.MustMarshal(github.ListCheckRunsResults{Total: github.Int(len(checkRuns)), CheckRuns: checkRuns}))
+					}),
+				),
+				recordComments(t, &comments),
+				recordCheckRunUpdates(t, &[]github.UpdateCheckRunOptions{}),
+				mock.WithRequestMatchHandler(
+					mock.PostReposCheckRunsByOwnerByRepo,
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						opts := github.CreateCheckRunOptions{}
+						require.NoError(t, json.NewDecoder(r.Body).Decode(&opts))
+						require.Equal(t, "ec26c3e57ca3a959ca5aad62de7213c562f8c821", opts.HeadSHA)
+						checkRunNames = append(checkRunNames, opts.Name)
+						w.Write(mock.MustMarshal(github.CheckRun{ID: github.Int64(int64(len(checkRunNames)))}))
+					}),
+				),
+			)
+			srv.repoConfig = tt.config
+			err := OnMergeGroupEvent(srv)("1234", "merge_group", tt.event)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantCheckRuns, checkRunNames)
+			require.Len(t, *srv.tryBotRuns, len(tt.wantCheckRuns))
+			for _, run := range *srv.tryBotRuns {
+				require.Contains(t, run, "--property=command_trigger=merge_group")
+				require.Contains(t, run, "--property=github_pull_request_base_ref=main")
+				require.Contains(t, run, "--property=github_pull_request_head_sha=ec26c3e57ca3a959ca5aad62de7213c562f8c821")
+			}
+			// There is no pull request to comment on
+			require.Empty(t, comments)
+			require.Equal(t, tt.wantCancelled, *srv.cancelledCheckRuns)
+		})
+	}
+}
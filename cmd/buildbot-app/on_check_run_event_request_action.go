@@ -7,8 +7,23 @@ import (
 	"github.com/google/go-github/v50/github"
 )
 
-func (srv *AppServer) OnCheckRunEventRequestAction() githubevents.CheckRunEventHandleFunc {
+// OnCheckRunEventRequestAction handles clicks on the buttons of our check runs
+// (see CheckRunActions and ApprovalCheckRunActions).
+func OnCheckRunEventRequestAction(srv Server) githubevents.CheckRunEventHandleFunc {
 	return func(deliveryID string, eventName string, event *github.CheckRunEvent) error {
+		if event == nil || event.RequestedAction == nil {
+			return nil
+		}
+		switch event.RequestedAction.Identifier {
+		case CheckRunActionApproveBuild:
+			return onApproveBuildAction(srv, event)
+		case CheckRunActionMakeMandatory:
+			return onMakeMandatoryAction(srv, event, true)
+		case CheckRunActionMakeOptional:
+			return onMakeMandatoryAction(srv, event, false)
+		case CheckRunActionReRunCheck:
+			return onReRunCheckAction(srv, event)
+		}
 		log.Printf("NOT IMPLEMENTED: OnCheckRunEventRequestAction with this requested action identifier: %s\n", event.RequestedAction.Identifier)
 		return nil
 	}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+	"github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func checkRunEventOK(checkRun *github.CheckRun, identifier string, sender string) *github.CheckRunEvent {
+	return &github.CheckRunEvent{
+		Action:   github.String("requested_action"),
+		CheckRun: checkRun,
+		RequestedAction: &github.RequestedAction{
+			Identifier: identifier,
+		},
+		Installation: &github.Installation{
+			ID: github.Int64(1234),
+		},
+		Repo: &github.Repository{
+			Owner: &github.User{
+				Login: github.String("janedoe"),
+			},
+			Name: github.String("examplerepo"),
+		},
+		Sender: &github.User{
+			Login: github.String(sender),
+		},
+	}
+}
+
+// outsiderOK mocks the GitHub API calls that find out that a user is neither
+// a member of the organization nor a collaborator of the repository.
+func outsiderOK() []mock.MockBackendOption {
+	return []mock.MockBackendOption{
+		mock.WithRequestMatchHandler(
+			mock.GetOrgsMembersByOrgByUsername,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+			}),
+		),
+		mock.WithRequestMatch(
+			mock.GetReposCollaboratorsPermissionByOwnerByRepoByUsername,
+			github.RepositoryPermissionLevel{Permission: github.String("read")},
+		),
+	}
+}
+
+func TestOnCheckRunEventRequestAction(t *testing.T) {
+	pr := prOK()
+	pr.Number = github.Int(123)
+	pr.Base.Ref = github.String("main")
+	pr.Base.SHA = github.String("1ea3fd4f1b3f2b58a7a2f2c2a25d1ecf0c8ff40b")
+	pr.Head.Ref = github.String("feature")
+
+	t.Run("event nil", func(t *testing.T) {
+		err := OnCheckRunEventRequestAction(NewMockServer())("1234", "check_run", nil)
+		require.NoError(t, err)
+	})
+
+	t.Run("approve build", func(t *testing.T) {
+		tests := []struct {
+			name           string
+			sender         string
+			headSHA        string
+			isMember       bool
+			permission     string
+			wantTryBot     bool
+			wantComment    string
+			wantUpdates    int
+			wantConclusion CheckRunConclusion
+		}{
+			{"by owner", "janedoe", pr.GetHead().GetSHA(), false, "admin", true, buildLogCommentIntro, 1, CheckRunConclusionNeutral},
+			{"by organization member", "maintainer", pr.GetHead().GetSHA(), true, "read", true, buildLogCommentIntro, 1, CheckRunConclusionNeutral},
+			{"by collaborator", "maintainer", pr.GetHead().GetSHA(), false, "write", true, buildLogCommentIntro, 1, CheckRunConclusionNeutral},
+			{"by outsider", "newbie", pr.GetHead().GetSHA(), false, "read", false, "Sorry @newbie, but only OWNER, MEMBER or COLLABORATOR may approve builds.", 0, ""},
+			{"for an old SHA", "janedoe", "0000000000000000000000000000000000000000", false, "admin", false, "Approvals only apply to the SHA that a build was requested for", 0, ""},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				comments := []string{}
+				updates := []github.UpdateCheckRunOptions{}
+				srv := NewMockServer(
+					mock.WithRequestMatch(
+						mock.GetReposPullsByOwnerByRepoByPullNumber,
+						pr,
+					),
+					mock.WithRequestMatchHandler(
+						mock.GetOrgsMembersByOrgByUsername,
+						http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+							if tt.isMember {
+								w.WriteHeader(http.StatusNoContent)
+								return
+							}
+							w.WriteHeader(http.StatusNotFound)
+						}),
+					),
+					mock.WithRequestMatch(
+						mock.GetReposCollaboratorsPermissionByOwnerByRepoByUsername,
+						github.RepositoryPermissionLevel{Permission: github.String(tt.permission)},
+					),
+					mock.WithRequestMatch(
+						mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+						github.ListCheckRunsResults{Total: github.Int(0)},
+					),
+					mock.WithRequestMatch(
+						mock.PostReposCheckRunsByOwnerByRepo,
+						github.CheckRun{ID: github.Int64(2)},
+					),
+					recordCheckRunUpdates(t, &updates),
+					recordComments(t, &comments),
+				)
+				parked := checkRunOK(1, "completed", "action_required", parkedMetaOK("foo"))
+				parked.HeadSHA = github.String(tt.headSHA)
+				event := checkRunEventOK(parked, CheckRunActionApproveBuild, tt.sender)
+				err := OnCheckRunEventRequestAction(srv)("1234", "check_run", event)
+				require.NoError(t, err)
+				if tt.wantTryBot {
+					require.Len(t, *srv.tryBotRuns, 1)
+				} else {
+					require.Empty(t, *srv.tryBotRuns)
+				}
+				require.Len(t, comments, 1)
+				require.Contains(t, comments[0], tt.wantComment)
+				require.Len(t, updates, tt.wantUpdates)
+				if tt.wantUpdates > 0 {
+					require.Equal(t, string(tt.wantConclusion), updates[0].GetConclusion())
+				}
+			})
+		}
+	})
+
+	t.Run("already approved", func(t *testing.T) {
+		checkRun := checkRunOK(1, "completed", "neutral", CheckRunMeta{})
+		err := OnCheckRunEventRequestAction(NewMockServer())("1234", "check_run", checkRunEventOK(checkRun, CheckRunActionApproveBuild, "janedoe"))
+		require.NoError(t, err)
+	})
+
+	t.Run("make mandatory or optional", func(t *testing.T) {
+		optional := command.New()
+		optional.IsMandatory = false
+		optional.BuilderNames = []string{"foo"}
+		tests := []struct {
+			name               string
+			checkRun           *github.CheckRun
+			identifier         string
+			sender             string
+			wantConclusion     string
+			wantTitle          string
+			wantMandatory      bool
+			wantBuildConlusion CheckRunConclusion
+			wantComment        string
+		}{
+			{
+				"failure becomes neutral",
+				checkRunOK(1, "completed", "failure", CheckRunMeta{PullRequestNumber: 123}),
+				CheckRunActionMakeOptional, "janedoe",
+				"neutral", "Buildbot Status Log (check is optional)", false, CheckRunConclusionFailure, "",
+			},
+			{
+				"neutral becomes failure",
+				checkRunOK(1, "completed", "neutral", CheckRunMeta{Command: optional, PullRequestNumber: 123, BuildConclusion: CheckRunConclusionFailure}),
+				CheckRunActionMakeMandatory, "janedoe",
+				"failure", "Buildbot Status Log", true, CheckRunConclusionFailure, "",
+			},
+			{
+				"neutral without build conclusion stays neutral",
+				checkRunOK(1, "completed", "neutral", CheckRunMeta{Command: optional, PullRequestNumber: 123}),
+				CheckRunActionMakeMandatory, "janedoe",
+				"neutral", "Buildbot Status Log", true, CheckRunConclusionNeutral, "",
+			},
+			{
+				"in progress",
+				checkRunOK(1, "in_progress", "", CheckRunMeta{PullRequestNumber: 123}),
+				CheckRunActionMakeOptional, "janedoe",
+				"", "Buildbot Status Log (check is optional)", false, "", "",
+			},
+			{
+				"already optional",
+				checkRunOK(1, "completed", "neutral", CheckRunMeta{Command: optional, PullRequestNumber: 123}),
+				CheckRunActionMakeOptional, "janedoe",
+				"", "", false, "", "",
+			},
+			{
+				"not allowed",
+				checkRunOK(1, "completed", "failure", CheckRunMeta{PullRequestNumber: 123}),
+				CheckRunActionMakeOptional, "newbie",
+				"", "", false, "", "Sorry @newbie, but you are not allowed to make optional",
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				comments := []string{}
+				updates := []github.UpdateCheckRunOptions{}
+				srv := NewMockServer(append(outsiderOK(),
+					recordCheckRunUpdates(t, &updates),
+					recordComments(t, &comments),
+				)...)
+				srv.repoConfig = &RepoConfig{Authorization: AuthorizationPolicy{Rules: []AuthorizationRule{
+					{Option: "mandatory", Value: "no", Associations: []string{"OWNER", "MEMBER"}},
+				}}}
+				err := OnCheckRunEventRequestAction(srv)("1234", "check_run", checkRunEventOK(tt.checkRun, tt.identifier, tt.sender))
+				require.NoError(t, err)
+				if tt.wantComment != "" {
+					require.Len(t, comments, 1)
+					require.Contains(t, comments[0], tt.wantComment)
+				} else {
+					require.Empty(t, comments)
+				}
+				if tt.wantTitle == "" {
+					require.Empty(t, updates)
+					return
+				}
+				require.Len(t, updates, 1)
+				require.Equal(t, tt.wantConclusion, updates[0].GetConclusion())
+				require.Equal(t, tt.wantTitle, updates[0].GetOutput().GetTitle())
+				require.Contains(t, updates[0].GetOutput().GetSummary(), "@janedoe made this check")
+				meta := CheckRunMeta{}
+				require.NoError(t, json.Unmarshal([]byte(updates[0].GetExternalID()), &meta))
+				require.Equal(t, tt.wantMandatory, meta.Command.IsMandatory)
+				require.Equal(t, tt.wantBuildConlusion, meta.BuildConclusion)
+				require.Equal(t, 123, meta.PullRequestNumber)
+			})
+		}
+	})
+
+	t.Run("rerun check", func(t *testing.T) {
+		t.Run("ok", func(t *testing.T) {
+			comments := []string{}
+			checkRunNames := []string{}
+			srv := NewMockServer(
+				mock.WithRequestMatch(
+					mock.GetReposPullsByOwnerByRepoByPullNumber,
+					pr,
+				),
+				mock.WithRequestMatch(
+					mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+					github.ListCheckRunsResults{Total: github.Int(0)},
+				),
+				mock.WithRequestMatchHandler(
+					mock.PostReposCheckRunsByOwnerByRepo,
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						opts := github.CreateCheckRunOptions{}
+						require.NoError(t, json.NewDecoder(r.Body).Decode(&opts))
+						checkRunNames = append(checkRunNames, opts.Name)
+						w.Write(mock.MustMarshal(github.CheckRun{ID: github.Int64(2)}))
+					}),
+				),
+				recordComments(t, &comments),
+			)
+			checkRun := checkRunOK(1, "completed", "failure", CheckRunMeta{PullRequestNumber: 123})
+			err := OnCheckRunEventRequestAction(srv)("1234", "check_run", checkRunEventOK(checkRun, CheckRunActionReRunCheck, "janedoe"))
+			require.NoError(t, err)
+			require.Len(t, *srv.tryBotRuns, 1)
+			require.Contains(t, (*srv.tryBotRuns)[0], "--property=command_force=true")
+			require.Equal(t, []string{"@janedoe /buildbot mandatory=true force=true builder=[foo]"}, checkRunNames)
+			require.Len(t, comments, 1)
+			require.Contains(t, comments[0], "Thank you @janedoe for rerunning <code>@johndoe /buildbot mandatory=true force=false builder=[foo]</code>!")
+		})
+		t.Run("needs approval", func(t *testing.T) {
+			comments := []string{}
+			srv := NewMockServer(append(outsiderOK(), recordComments(t, &comments))...)
+			checkRun := checkRunOK(1, "completed", "failure", CheckRunMeta{PullRequestNumber: 123})
+			err := OnCheckRunEventRequestAction(srv)("1234", "check_run", checkRunEventOK(checkRun, CheckRunActionReRunCheck, "newbie"))
+			require.NoError(t, err)
+			require.Empty(t, *srv.tryBotRuns)
+			require.Len(t, comments, 1)
+			require.Contains(t, comments[0], "Sorry @newbie, but builds of first-time and external contributors")
+		})
+		t.Run("not allowed", func(t *testing.T) {
+			comments := []string{}
+			srv := NewMockServer(append(outsiderOK(), recordComments(t, &comments))...)
+			srv.repoConfig = &RepoConfig{Authorization: AuthorizationPolicy{DenyUsers: []string{"newbie"}}}
+			checkRun := checkRunOK(1, "completed", "failure", CheckRunMeta{PullRequestNumber: 123})
+			err := OnCheckRunEventRequestAction(srv)("1234", "check_run", checkRunEventOK(checkRun, CheckRunActionReRunCheck, "newbie"))
+			require.NoError(t, err)
+			require.Empty(t, *srv.tryBotRuns)
+			require.Len(t, comments, 1)
+			require.Contains(t, comments[0], "Sorry @newbie, but you are not allowed to rerun")
+		})
+		t.Run("option not allowed", func(t *testing.T) {
+			comments := []string{}
+			srv := NewMockServer(append(outsiderOK(), recordComments(t, &comments))...)
+			srv.repoConfig = &RepoConfig{Authorization: AuthorizationPolicy{Rules: []AuthorizationRule{
+				{Option: command.CommandOptionPriority, Users: []string{"johndoe"}},
+			}}}
+			cmd := command.New()
+			cmd.BuilderNames = []string{"foo"}
+			cmd.CommentAuthor = "johndoe"
+			cmd.Priority = command.PriorityHigh
+			checkRun := checkRunOK(1, "completed", "failure", CheckRunMeta{Command: cmd, PullRequestNumber: 123})
+			err := OnCheckRunEventRequestAction(srv)("1234", "check_run", checkRunEventOK(checkRun, CheckRunActionReRunCheck, "janedoe"))
+			require.NoError(t, err)
+			require.Empty(t, *srv.tryBotRuns)
+			require.Len(t, comments, 1)
+			require.Contains(t, comments[0], "Sorry @janedoe, but you are not allowed to rerun")
+		})
+		t.Run("push", func(t *testing.T) {
+			srv := NewMockServer(
+				mock.WithRequestMatch(
+					mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+					github.ListCheckRunsResults{Total: github.Int(0)},
+				),
+				mock.WithRequestMatch(
+					mock.PostReposCheckRunsByOwnerByRepo,
+					github.CheckRun{ID: github.Int64(2)},
+				),
+			)
+			cmd := commandOK("foo")
+			cmd.Trigger = command.TriggerPush
+			checkRun := checkRunOK(1, "completed", "failure", CheckRunMeta{Command: cmd})
+			checkRun.HeadSHA = github.String("def")
+			checkRun.CheckSuite = &github.CheckSuite{HeadBranch: github.String("main"), BeforeSHA: github.String("abc")}
+			err := OnCheckRunEventRequestAction(srv)("1234", "check_run", checkRunEventOK(checkRun, CheckRunActionReRunCheck, "janedoe"))
+			require.NoError(t, err)
+			// The commit of the push is built again without a pull request
+			require.Len(t, *srv.tryBotRuns, 1)
+			for _, property := range []string{
+				"--property=github_pull_request_number=0",
+				"--property=github_pull_request_base_ref=main",
+				"--property=github_pull_request_base_sha=abc",
+				"--property=github_pull_request_head_ref=main",
+				"--property=github_pull_request_head_sha=def",
+			} {
+				require.Contains(t, (*srv.tryBotRuns)[0], property)
+			}
+		})
+		t.Run("merge group", func(t *testing.T) {
+			updates := []github.UpdateCheckRunOptions{}
+			srv := NewMockServer(recordCheckRunUpdates(t, &updates))
+			cmd := commandOK("foo")
+			cmd.Trigger = command.TriggerMergeGroup
+			checkRun := checkRunOK(1, "completed", "failure", CheckRunMeta{Command: cmd})
+			err := OnCheckRunEventRequestAction(srv)("1234", "check_run", checkRunEventOK(checkRun, CheckRunActionReRunCheck, "janedoe"))
+			require.NoError(t, err)
+			require.Empty(t, *srv.tryBotRuns)
+			// Without a pull request, the check run explains why
+			require.Len(t, updates, 1)
+			require.Equal(t, checkRun.GetName(), updates[0].Name)
+			require.Contains(t, updates[0].Output.GetSummary(), "[Builder: foo]: build successful")
+			require.Contains(t, updates[0].Output.GetSummary(), "builds of a merge group can't be rerun")
+		})
+	})
+}
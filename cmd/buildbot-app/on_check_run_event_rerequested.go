@@ -1,15 +1,18 @@
 package main
 
 import (
-	"log"
-
 	"github.com/cbrgm/githubevents/githubevents"
 	"github.com/google/go-github/v50/github"
 )
 
-func (srv *AppServer) OnCheckRunEventReRequested() githubevents.CheckRunEventHandleFunc {
+// OnCheckRunEventReRequested handles GitHub's own "Re-run" link of a check run.
+// Like the "Rerun check" button, it builds the command of the check run again
+// in a new check run.
+func OnCheckRunEventReRequested(srv Server) githubevents.CheckRunEventHandleFunc {
 	return func(deliveryID string, eventName string, event *github.CheckRunEvent) error {
-		log.Printf("NOT IMPLEMENTED: OnCheckRunEventReRequested not implemented yet")
-		return nil
+		if event == nil || event.CheckRun == nil {
+			return nil
+		}
+		return onReRunCheckAction(srv, event)
 	}
 }
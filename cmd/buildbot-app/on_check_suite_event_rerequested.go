@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/cbrgm/githubevents/githubevents"
+	"github.com/google/go-github/v50/github"
+)
+
+// OnCheckSuiteEventReRequested handles GitHub's "Re-run all checks" and
+// "Re-run failed checks" links. Only builds whose latest check run in the
+// suite ended as failure, cancelled or timed out are built again, each in a
+// new check run.
+func OnCheckSuiteEventReRequested(srv Server) githubevents.CheckSuiteEventHandleFunc {
+	return func(deliveryID string, eventName string, event *github.CheckSuiteEvent) error {
+		if event == nil || event.CheckSuite == nil {
+			return nil
+		}
+		r, err := newSenderRequest(srv, event.GetInstallation().GetID(), event.GetRepo(), event.GetSender())
+		if err != nil {
+			return err
+		}
+		checkRuns, err := getLatestCheckRunsOfSuite(r.gh, r.repoOwner, r.repoName, event.GetCheckSuite().GetID())
+		if err != nil {
+			return err
+		}
+		rerun := map[string]bool{}
+		for _, checkRun := range checkRuns {
+			if !CheckRunNeedsRetry(checkRun) || rerun[checkRun.GetName()] || !r.forCheckRun(checkRun) {
+				continue
+			}
+			rerun[checkRun.GetName()] = true
+			if err := r.rerun(); err != nil {
+				return err
+			}
+		}
+		if len(rerun) == 0 {
+			log.Printf("check suite %d has no failed, cancelled or timed out builds to rerun", event.GetCheckSuite().GetID())
+		}
+		return nil
+	}
+}
+
+// getLatestCheckRunsOfSuite returns the most recent check run of every name in
+// the check suite.
+func getLatestCheckRunsOfSuite(gh *github.Client, repoOwner string, repoName string, checkSuiteID int64) ([]*github.CheckRun, error) {
+	pagesRemaining := true
+	pages := []*github.CheckRun{}
+	listOpts := &github.ListCheckRunsOptions{
+		Filter: github.String("latest"),
+		ListOptions: github.ListOptions{
+			Page:    1,
+			PerPage: 30,
+		},
+	}
+	for pagesRemaining {
+		checkRunResults, resp, err := gh.Checks.ListCheckRunsCheckSuite(context.Background(), repoOwner, repoName, checkSuiteID, listOpts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list check runs of check suite %d: %w", checkSuiteID, err)
+		}
+		if resp.NextPage == 0 {
+			pagesRemaining = false
+		} else {
+			listOpts.ListOptions.Page = resp.NextPage
+		}
+		pages = append(pages, checkRunResults.CheckRuns...)
+	}
+	return pages, nil
+}
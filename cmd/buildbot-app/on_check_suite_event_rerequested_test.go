@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnCheckSuiteEventReRequested(t *testing.T) {
+	pr := prOK()
+	pr.Number = github.Int(123)
+	pr.Base.Ref = github.String("main")
+	pr.Base.SHA = github.String("1ea3fd4f1b3f2b58a7a2f2c2a25d1ecf0c8ff40b")
+	pr.Head.Ref = github.String("feature")
+	approval := checkRunOK(5, "completed", "action_required", CheckRunMeta{Command: commandOK("waiting"), PullRequestNumber: 123})
+	approval.ExternalID = github.String(`{"approval":{"pull_request_number":123}}`)
+	checkRuns := []*github.CheckRun{
+		checkRunOK(1, "completed", "failure", CheckRunMeta{Command: commandOK("failed"), PullRequestNumber: 123}),
+		checkRunOK(2, "completed", "success", CheckRunMeta{Command: commandOK("succeeded"), PullRequestNumber: 123}),
+		checkRunOK(3, "completed", "timed_out", CheckRunMeta{Command: commandOK("timedout"), PullRequestNumber: 123}),
+		checkRunOK(4, "in_progress", "", CheckRunMeta{Command: commandOK("running"), PullRequestNumber: 123}),
+		approval,
+		{ID: github.Int64(6), Name: github.String("not ours"), Status: github.String("completed"), Conclusion: github.String("failure")},
+	}
+	event := &github.CheckSuiteEvent{
+		Action:       github.String("rerequested"),
+		CheckSuite:   &github.CheckSuite{ID: github.Int64(42)},
+		Installation: &github.Installation{ID: github.Int64(1234)},
+		Repo: &github.Repository{
+			Owner: &github.User{Login: github.String("janedoe")},
+			Name:  github.String("examplerepo"),
+		},
+		Sender: &github.User{Login: github.String("janedoe")},
+	}
+
+	comments := []string{}
+	checkRunNames := []string{}
+	srv := NewMockServer(
+		mock.WithRequestMatchHandler(
+			mock.GetReposCheckSuitesCheckRunsByOwnerByRepoByCheckSuiteId,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "latest", r.URL.Query().Get("filter"))
+				w.Write(mock.MustMarshal(github.ListCheckRunsResults{Total: github.Int(len(checkRuns)), CheckRuns: checkRuns}))
+			}),
+		),
+		mock.WithRequestMatch(
+			mock.GetReposPullsByOwnerByRepoByPullNumber,
+			pr,
+			pr,
+		),
+		mock.WithRequestMatch(
+			mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+			github.ListCheckRunsResults{Total: github.Int(0)},
+			github.ListCheckRunsResults{Total: github.Int(0)},
+		),
+		mock.WithRequestMatchHandler(
+			mock.PostReposCheckRunsByOwnerByRepo,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				opts := github.CreateCheckRunOptions{}
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&opts))
+				checkRunNames = append(checkRunNames, opts.Name)
+				w.Write(mock.MustMarshal(github.CheckRun{ID: github.Int64(int64(10 + len(checkRunNames)))}))
+			}),
+		),
+		recordComments(t, &comments),
+	)
+	err := OnCheckSuiteEventReRequested(srv)("1234", "check_suite", event)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"@janedoe /buildbot mandatory=true force=true builder=[failed]",
+		"@janedoe /buildbot mandatory=true force=true builder=[timedout]",
+	}, checkRunNames)
+	require.Len(t, *srv.tryBotRuns, 2)
+	require.Len(t, comments, 2)
+}
@@ -15,6 +15,8 @@ func (srv *AppServer) OnInstallationRepositoriesEventRemoved() githubevents.Inst
 			html_urls[i] = *event.RepositoriesRemoved[i].HTMLURL
 		}
 		log.Printf("%s uninstalled app from %s", *event.Sender.Login, strings.Join(html_urls, ","))
+		srv.repoConfigs.Forget(event.GetInstallation().GetID())
+		srv.permissions.Forget(event.GetInstallation().GetID())
 		return nil
 	}
 }
@@ -2,8 +2,11 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/cbrgm/githubevents/githubevents"
@@ -20,203 +23,473 @@ func OnIssueCommentEventAny(srv Server) githubevents.IssueCommentEventHandleFunc
 		if !event.Issue.IsPullRequest() {
 			return nil
 		}
-		// Only handle new comments or edited ones
+		comment := event.Comment
+		if comment == nil {
+			return nil
+		}
+		if comment.Body == nil {
+			return nil
+		}
+		// Find all /buildbot commands in the comment body. Of an edited
+		// comment, only the commands that the edit changed are of interest.
+		// The builds of a deleted comment are cancelled.
+		commandLines := command.ExtractCommandLines(*comment.Body)
+		var removedLines []command.CommandLine
 		switch event.GetAction() {
-		case "edited":
-			break
 		case "created":
 			break
+		case "edited":
+			previousLines := command.ExtractCommandLines(event.GetChanges().GetBody().GetFrom())
+			commandLines, removedLines = changedCommandLines(commandLines, previousLines)
+		case "deleted":
+			removedLines = commandLines
+			commandLines = nil
 		default:
 			return nil
 		}
-		comment := event.Comment
-		if comment == nil {
+		if len(commandLines) == 0 && len(removedLines) == 0 {
 			return nil
 		}
-		if comment.Body == nil {
-			return nil
+		log.Printf("/buildbot was used %d time(s) and removed %d time(s)", len(commandLines), len(removedLines))
+
+		// Create a github client based for this app's installation
+		appInstallationID := *event.GetInstallation().ID
+		gh, err := srv.NewGithubClient(appInstallationID)
+		if err != nil {
+			err = fmt.Errorf("error creating github client: %w", err)
+			log.Println(err)
+			return err
 		}
-		// Check if comment body can be parsed as a /buildbot
-		if !command.StringIsCommand(*comment.Body) {
+
+		log.Printf("%s (%s) commented here %s", *comment.User.Login, comment.GetAuthorAssociation(), *event.Comment.HTMLURL)
+
+		repoOwner := *event.Repo.Owner.Login
+		repoName := *event.Repo.Name
+		prNumber := *event.Issue.Number
+
+		// Read the repository's configuration. If it is invalid, the pull
+		// request on which the command was written is the best place to say so.
+		config, err := srv.GetRepoConfig(appInstallationID, repoOwner, repoName)
+		var configErr *RepoConfigError
+		if errors.As(err, &configErr) {
+			_, _, err := gh.Issues.CreateComment(context.Background(), repoOwner, repoName, prNumber, &github.IssueComment{
+				Body: github.String(repoConfigErrorMessage(configErr)),
+			})
+			if err != nil {
+				return fmt.Errorf("failed to write comment about invalid repository configuration: %w", err)
+			}
 			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to get repository configuration: %w", err)
+		}
+
+		// Show right away that we've seen the comment. Its builds don't depend
+		// on the reaction, so we only log a failure.
+		if config.Reactions.Enabled && event.GetAction() != "deleted" {
+			if err := setCommentReaction(gh, repoOwner, repoName, comment.GetID(), ReactionReceived); err != nil {
+				log.Println(err)
+			}
 		}
-		log.Printf("/buildbot was used")
 
 		// tag::thank_you[]
 		// This comment will be used all over the place
 		thankYouComment := fmt.Sprintf(
-			`Thank you @%s for using the <a href="todo:link-to-documentation-here"><code>%s</code></a> command <a href="%s">here</a>! `,
-			command.BuildbotCommand,
+			`Thank you @%s for using the <a href="%s"><code>%s</code></a> command <a href="%s">here</a>! `,
 			*event.Comment.User.Login,
+			config.docsURL(),
+			command.BuildbotCommand,
 			*event.Comment.HTMLURL,
 		)
 
 		// end::thank_you[]
 
-		cmd, err := command.FromString(*comment.Body)
+		// tag::get_pr[]
+		pr, _, err := gh.PullRequests.Get(context.Background(), repoOwner, repoName, prNumber)
+		// end::get_pr[]
 		if err != nil {
-			return fmt.Errorf("failed to parse command: %w", err)
-			// TODO(kwk): Maybe tell the user that we didn't understand the request
+			return fmt.Errorf("failed to get pull request: %w", err)
 		}
-		cmd.CommentAuthor = *comment.User.Login
 
-		// Create a github client based for this app's installation
-		appInstallationID := *event.GetInstallation().ID
-		gh, err := srv.NewGithubClient(appInstallationID)
-		if err != nil {
-			err = fmt.Errorf("error creating github client: %w", err)
-			log.Println(err)
+		r := commentRequest{
+			srv:               srv,
+			gh:                gh,
+			appInstallationID: appInstallationID,
+			repoOwner:         repoOwner,
+			repoName:          repoName,
+			prNumber:          prNumber,
+			pr:                pr,
+			commenter: Commenter{
+				Login:             comment.GetUser().GetLogin(),
+				AuthorAssociation: comment.GetAuthorAssociation(),
+			},
+			commentID:       comment.GetID(),
+			commentURL:      comment.GetHTMLURL(),
+			config:          config,
+			thankYouComment: thankYouComment,
+		}
+
+		if event.GetAction() == "deleted" {
+			reason := fmt.Sprintf("Cancelled because @%s deleted the comment that requested it.", event.GetSender().GetLogin())
+			_, err := r.cancelCommentBuilds(nil, reason)
 			return err
 		}
+		if len(removedLines) > 0 {
+			r.onlyBuilders, err = r.reconcileEdit(commandLines, removedLines)
+			if err != nil {
+				return fmt.Errorf("failed to reconcile edited comment: %w", err)
+			}
+		}
+
+		// Every command is handled as its own request. An error in one command
+		// doesn't stop the others from being handled.
+		errs := []string{}
+		for _, commandLine := range commandLines {
+			if err := r.handleCommandLine(commandLine); err != nil {
+				log.Printf("failed to handle command in line %d: %v", commandLine.Number, err)
+				errs = append(errs, fmt.Sprintf("line %d: %v", commandLine.Number, err))
+			}
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("failed to handle %d of %d command(s): %s", len(errs), len(commandLines), strings.Join(errs, "; "))
+		}
+		return nil
+	}
+}
 
-		log.Printf("%s commented here %s", *comment.User.Login, *event.Comment.HTMLURL)
+// commentRequest bundles everything that is needed to handle the /buildbot
+// commands of a single pull request comment. Automatic builds go through the
+// same steps with a command that the app wrote on its own.
+type commentRequest struct {
+	srv               Server
+	gh                *github.Client
+	appInstallationID int64
+	repoOwner         string
+	repoName          string
+	prNumber          int
+	pr                *github.PullRequest
+	// The author of the comment or the user who triggered an automatic build
+	commenter Commenter
+	// The comment with the commands. Zero and empty for automatic builds.
+	commentID  int64
+	commentURL string
+	// Builds only run on these builders if it isn't nil. After an edit of
+	// the comment, these are the builders that the edit added.
+	onlyBuilders []string
+	// What started the commands if they weren't written in a comment (see
+	// command.Command.Trigger)
+	trigger string
+	// The label that started the commands if they were started by one (see
+	// command.Command.Label)
+	label  string
+	config *RepoConfig
+	// Reports the builds of the commands (see newReporter)
+	reporter Reporter
+	// thankYouComment is put in front of every comment that we write in
+	// response to the comment.
+	thankYouComment string
+}
 
-		// TODO(kwk): Check for event.Comment.AuthorAssociation == FIRST_TIME_CONTRIBUTOR
-		// // Possible values are "COLLABORATOR", "CONTRIBUTOR", "FIRST_TIMER", "FIRST_TIME_CONTRIBUTOR", "MEMBER", "OWNER", or "NONE".
+// reply writes a comment on the pull request that starts with the thank you
+// message.
+func (r commentRequest) reply(msg string) error {
+	_, _, err := r.gh.Issues.CreateComment(context.Background(), r.repoOwner, r.repoName, r.prNumber, &github.IssueComment{
+		Body: github.String(r.thankYouComment + msg),
+	})
+	return err
+}
 
-		// tag::get_pr[]
-		commentUser := *event.Comment.User.Login
-		repoOwner := *event.Repo.Owner.Login
-		repoName := *event.Repo.Name
-		prNumber := *event.Issue.Number
-		pr, _, err := gh.PullRequests.Get(context.Background(), repoOwner, repoName, prNumber)
-		// end::get_pr[]
-		if err != nil {
-			return fmt.Errorf("failed to get pull request: %w", err)
+// handleCommandLine parses and runs a single /buildbot command of the comment.
+func (r commentRequest) handleCommandLine(commandLine command.CommandLine) error {
+	srv := r.srv
+	gh := r.gh
+	pr := r.pr
+	appInstallationID := r.appInstallationID
+	thankYouComment := r.thankYouComment
+
+	commandLine.Text = r.config.ExpandAliases(commandLine.Text)
+	cmd, err := command.FromStringWithPresets(commandLine.Text, r.config.Presets)
+	var parseErr *command.ParseError
+	if errors.As(err, &parseErr) {
+		if err := r.reply(parseErrorMessage(commandLine, parseErr)); err != nil {
+			return fmt.Errorf("failed to write comment about invalid command: %w", err)
 		}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to parse command: %w", err)
+	}
+	cmd.CommentAuthor = r.commenter.Login
+	cmd.CommentID = r.commentID
+	cmd.Trigger = r.trigger
+	cmd.Label = r.label
 
-		// tag::check_mergable[]
-		if !pr.GetMergeable() {
-			// end::check_mergable[]
-			_, _, err1 := gh.Issues.CreateComment(context.Background(), repoOwner, repoName, prNumber, &github.IssueComment{
-				Body: github.String(thankYouComment + "Sorry, but this pull request is currently not mergable."),
-			})
-			if err1 != nil {
-				err1 = fmt.Errorf("failed to write comment aobut mergability: %w", err1)
-				return fmt.Errorf("pr is not mergable: %w", err1)
+	if cmd.Subcommand == command.SubcommandHelp {
+		if err := r.reply("Here's how to use it:\n\n" + command.HelpText()); err != nil {
+			return fmt.Errorf("failed to write help comment: %w", err)
+		}
+		return nil
+	}
+
+	r.reporter, err = newReporter(srv, appInstallationID, r.config)
+	if err != nil {
+		return err
+	}
+	// Everything but new builds has to find the builds of earlier commands
+	if cmd.Subcommand != command.SubcommandBuild && !r.reporter.TracksBuilds() {
+		if err := r.reply(untrackedBuildsMessage(cmd.Subcommand)); err != nil {
+			return fmt.Errorf("failed to write comment about untracked builds: %w", err)
+		}
+		return nil
+	}
+
+	// A bare /buildbot runs on the repository's default builders
+	if cmd.Subcommand == command.SubcommandBuild && len(cmd.BuilderNames) == 0 && len(cmd.Selectors()) == 0 {
+		cmd.BuilderNames = append([]string{}, r.config.DefaultBuilders...)
+		sort.Strings(cmd.BuilderNames)
+	}
+
+	// Custom properties have to be allowed for the repository
+	if len(cmd.Properties) > 0 {
+		allowed := r.config.AllowedProperties
+		if disallowed := disallowedProperties(cmd.Properties, allowed); len(disallowed) > 0 {
+			if err := r.reply(disallowedPropertiesMessage(disallowed, allowed)); err != nil {
+				return fmt.Errorf("failed to write comment about disallowed properties: %w", err)
 			}
-			// TODO(kwk): Do we just want to return?
-			return fmt.Errorf("pr is not mergable")
-			// tag::check_mergable[]
+			return nil
 		}
-		// end::check_mergable[]
+	}
+
+	// A build for a matrix like os=linux,macos arch=x86_64,aarch64 resolves
+	// the builders of every combination on its own.
+	isMatrixBuild := cmd.Subcommand == command.SubcommandBuild && cmd.IsMatrix()
 
-		// If there already is a check run for the same HEAD and the force
-		// option is no, then say: Sorry, no can't do.
-		// ----
-		checkRuns, err := GetAllCheckRunsForPullRequest(gh, appInstallationID, pr)
+	// Turn attribute selectors like os=linux into builder names
+	if !isMatrixBuild {
+		inventory, err := srv.GetBuilderInventory()
 		if err != nil {
-			return fmt.Errorf("failed to get all check runs for pull request: %w", err)
+			return fmt.Errorf("failed to get builder inventory: %w", err)
 		}
-		currentCheckRunName := cmd.ToGithubCheckNameString()
-		for _, checkRun := range checkRuns {
-			if *checkRun.Name != currentCheckRunName {
-				continue
-			}
-			// The requested check has already been run for the given PR. Let's see if a build is forced this time.
-			if cmd.Force {
-				// Break because we will continue with the build as planned
-				break
+		var selectorErr *command.SelectorError
+		if err := cmd.ResolveBuilderNames(r.config.FilterInventory(inventory)); errors.As(err, &selectorErr) {
+			if err := r.reply(selectorErrorMessage(selectorErr)); err != nil {
+				return fmt.Errorf("failed to write comment about unsatisfiable selectors: %w", err)
 			}
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to resolve builder names: %w", err)
+		}
+	}
 
-			msg := fmt.Sprintf(thankYouComment+`
-The same build request exists for this pull request's SHA (%s) <a href="%s">here</a>.
-Consider specifying the <code>%s=true</code> option to enforce a new build.
-			`, *pr.Head.SHA, *checkRun.HTMLURL, command.CommandOptionForce)
-			_, _, err := gh.Issues.CreateComment(context.Background(), repoOwner, repoName, prNumber, &github.IssueComment{
-				Body: github.String(msg),
-			})
-			if err != nil {
-				return fmt.Errorf("failed to create comment: %w", err)
+	// An edited command only builds on the builders that the edit added
+	if r.onlyBuilders != nil && cmd.Subcommand == command.SubcommandBuild && !isMatrixBuild {
+		builderNames := []string{}
+		for _, name := range cmd.BuilderNames {
+			if containsString(r.onlyBuilders, name) {
+				builderNames = append(builderNames, name)
 			}
-			// We return here because the force option was false
+		}
+		if len(builderNames) == 0 {
+			log.Printf("edited command in line %d adds no builders", commandLine.Number)
 			return nil
 		}
+		cmd.BuilderNames = builderNames
+	}
 
-		// ----
+	// Only builders that are allowed in the repository can be built on
+	if cmd.Subcommand == command.SubcommandBuild {
+		if disallowed := r.config.DisallowedBuilders(cmd.BuilderNames); len(disallowed) > 0 {
+			if err := r.reply(disallowedBuildersMessage(disallowed, r.config.AllowedBuilders)); err != nil {
+				return fmt.Errorf("failed to write comment about disallowed builders: %w", err)
+			}
+			return nil
+		}
+	}
 
-		buildLogCommentID := int64(0)
-		// tag::thank_you[]
-		newComment, _, err := gh.Issues.CreateComment(context.Background(), repoOwner, repoName, prNumber, &github.IssueComment{
-			Body: github.String(thankYouComment +
-				`<sub>This very comment will be used to continously log build state changes for your request. We decided to do this in addition to using Github's Check Runs below so you can inspect previous check runs better.</sub>`,
-			),
-		})
-		// end::thank_you[]
+	// Everybody may look at the status but the repository's authorization
+	// policy decides who may do anything else.
+	policy := r.config.Authorization
+	commenter := r.commenter
+	if cmd.Subcommand != command.SubcommandStatus {
+		reasons, err := policy.Authorize(cmd, commenter, githubTeamMembership(gh))
 		if err != nil {
-			return fmt.Errorf("failed to create build-log comment: %w", err)
-		}
-		if newComment != nil {
-			buildLogCommentID = newComment.GetID()
-		}
-
-		// IDEA: We could set up one try-builder for all jobs and have that
-		// try-builder trigger other jobs depending on the given properties. The
-		// try builder would need to have a Trigger build step.
-		// (http://docs.buildbot.net/current/manual/configuration/steps/trigger.html)
-		//
-		// Or we could have one try-builder fast workers and one try-builder for
-		// slowers non-mandatory workers.
-
-		//---------------------------------------------------------------------
-		// Lets add a check for the try bot run
-		// NOTE: It is important to first create the check so that we can pass
-		//       its ID to buildbot. This way whenever buildbot tells us
-		//       anything about a build we know how to reflect this in the
-		//       check run on github.
-		//---------------------------------------------------------------------
-		opts := github.CreateCheckRunOptions{
-			Name:    cmd.ToGithubCheckNameString(),
-			HeadSHA: *pr.Head.SHA,
-			Status:  github.String(string(CheckRunStateQueued)),
-			Output: &github.CheckRunOutput{
-				Title:   github.String("Buildbot Status Log"),
-				Summary: github.String(WrapMsgWithTimePrefix("We're about to forward your request to buildbot.", time.Now())),
-				Text:    github.String("Please wait for the URL to your buildbot job to appear here."),
-				Images:  nil,
-			},
+			return fmt.Errorf("failed to authorize command: %w", err)
 		}
-		optActions := []*github.CheckRunAction{
-			{
-				Label:       "Make check required",
-				Description: "Make check required to pass",
-				Identifier:  "MakeMandatory",
-			},
-			{
-				Label:       "Make check optional",
-				Description: "This check is optional",
-				Identifier:  "MakeOptional",
-			},
-			{
-				Label:       "Rerun check",
-				Description: "Reruns the check",
-				Identifier:  "ReRunCheck",
-			},
+		if len(reasons) > 0 {
+			log.Printf("denied command of %s: %s", commenter.Login, strings.Join(reasons, "; "))
+			return r.denyCommand(cmd, reasons)
 		}
-		opts.Actions = optActions
-		checkRunTryBot, _, err := gh.Checks.CreateCheckRun(context.Background(), repoOwner, repoName, opts)
-		if err != nil {
-			return fmt.Errorf("failed to create try bot check run: %w", err)
+	}
+
+	switch cmd.Subcommand {
+	case command.SubcommandStatus:
+		return replyWithStatus(gh, appInstallationID, pr, thankYouComment)
+	case command.SubcommandCancel:
+		return cancelBuilds(srv, gh, appInstallationID, pr, cmd, thankYouComment)
+	}
+
+	// GitHub finds out whether a pull request is mergeable in the background,
+	// so right after a push it is often unknown. Automatic builds don't wait
+	// for it.
+	mergeableUnknown := r.trigger != "" && pr.Mergeable == nil
+	// tag::check_mergable[]
+	if !pr.GetMergeable() && !mergeableUnknown {
+		// end::check_mergable[]
+		err1 := r.reply("Sorry, but this pull request is currently not mergable.")
+		if err1 != nil {
+			err1 = fmt.Errorf("failed to write comment aobut mergability: %w", err1)
+			return fmt.Errorf("pr is not mergable: %w", err1)
 		}
+		// TODO(kwk): Do we just want to return?
+		return fmt.Errorf("pr is not mergable")
+		// tag::check_mergable[]
+	}
+	// end::check_mergable[]
 
-		// To simulate latency
-		// log.Printf("Sleep for 10 seconds before sending request to buildbot")
-		// time.Sleep(10 * time.Second)
+	// A retry doesn't wait for approval, so first-time and external
+	// contributors have to request new builds instead, just like with the
+	// check run's Rerun button.
+	if cmd.Subcommand == command.SubcommandRetry && policy.RequiresApproval(commenter) {
+		return r.reply(fmt.Sprintf("Sorry @%s, but builds of first-time and external contributors have to be requested with a <code>%s</code> comment and approved by a maintainer.", commenter.Login, command.BuildbotCommand))
+	}
 
-		// Make a buildbot try call with an empty diff (!!!)
-		props := NewGithubPullRequest(pr).ToTryBotPropertyArray()
-		props = append(props, fmt.Sprintf("--property=github_check_run_id=%d", *checkRunTryBot.ID))
-		props = append(props, fmt.Sprintf("--property=github_app_installation_id=%d", appInstallationID))
-		props = append(props, fmt.Sprintf("--property=github_build_log_comment_id=%d", buildLogCommentID))
-		props = append(props, cmd.ToTryBotPropertyArray()...)
-		combinedOutput, err := srv.RunTryBot(commentUser, repoOwner, repoName, props...)
-		if err != nil {
-			return fmt.Errorf("failed to run trybot: %s: %w", combinedOutput, err)
+	if cmd.Subcommand == command.SubcommandApprove {
+		return r.approve(policy)
+	}
+
+	// Builds of first-time and external contributors wait for a maintainer.
+	// They count against the rate limits once they are approved.
+	if cmd.Subcommand == command.SubcommandBuild && !cmd.DryRun && policy.RequiresApproval(commenter) {
+		return r.parkForApproval(cmd, policy)
+	}
+
+	// Builds and retries count against the rate limits of the repository
+	if !cmd.DryRun && (cmd.Subcommand == command.SubcommandBuild || cmd.Subcommand == command.SubcommandRetry) {
+		var rateLimitErr *RateLimitError
+		if err := srv.TakeBuildToken(r.repoOwner, r.repoName, commenter, r.config.RateLimits); errors.As(err, &rateLimitErr) {
+			log.Printf("rate limited command of %s: %v", commenter.Login, rateLimitErr)
+			if err := r.reply(rateLimitMessage(commenter.Login, rateLimitErr, time.Now())); err != nil {
+				return fmt.Errorf("failed to write comment about rate limit: %w", err)
+			}
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to check rate limits: %w", err)
 		}
-		log.Printf("trybot command executed: %s", combinedOutput)
+	}
+
+	if cmd.Subcommand == command.SubcommandRetry {
+		return retryBuilds(srv, gh, appInstallationID, pr, cmd, policy, commenter, thankYouComment)
+	}
+
+	return runBuild(srv, gh, appInstallationID, NewBuildTarget(pr), cmd, thankYouComment)
+}
+
+// runBuild runs the build of the given command for the target or a matrix
+// build if the command has selectors with lists of values.
+func runBuild(srv Server, gh *github.Client, appInstallationID int64, target *BuildTarget, cmd *command.Command, thankYouComment string) error {
+	if cmd.IsMatrix() {
+		return MatrixBuildRequest{
+			Server:            srv,
+			Github:            gh,
+			AppInstallationID: appInstallationID,
+			Target:            target,
+			Command:           cmd,
+			ThankYouComment:   thankYouComment,
+		}.Run()
+	}
+
+	return BuildRequest{
+		Server:            srv,
+		Github:            gh,
+		AppInstallationID: appInstallationID,
+		Target:            target,
+		Command:           cmd,
+		ThankYouComment:   thankYouComment,
+	}.Run()
+}
 
+// denyCommand explains to the commenter why the command was not run. A denied
+// build also gets a check run that requires action, so that it shows up on
+// the pull request next to the other builds, unless the builds are reported
+// with commit statuses.
+func (r commentRequest) denyCommand(cmd *command.Command, reasons []string) error {
+	msg := unauthorizedMessage(reasons)
+	if cmd.Subcommand != command.SubcommandBuild || !r.reporter.TracksBuilds() {
+		if err := r.reply(msg); err != nil {
+			return fmt.Errorf("failed to write comment about unauthorized command: %w", err)
+		}
 		return nil
 	}
+	return r.replyWithActionRequired(CheckRunMeta{Command: cmd}, "Not authorized", msg, nil)
+}
+
+// replyWithActionRequired writes a comment with the given message and creates
+// a completed check run for the command in the meta data that requires
+// action. The check run links to the comment.
+func (r commentRequest) replyWithActionRequired(meta CheckRunMeta, title string, msg string, actions []*github.CheckRunAction) error {
+	comment, _, err := r.gh.Issues.CreateComment(context.Background(), r.repoOwner, r.repoName, r.prNumber, &github.IssueComment{
+		Body: github.String(r.thankYouComment + msg),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write comment: %w", err)
+	}
+	externalID, err := meta.ExternalID()
+	if err != nil {
+		return err
+	}
+	_, _, err = r.gh.Checks.CreateCheckRun(context.Background(), r.repoOwner, r.repoName, github.CreateCheckRunOptions{
+		Name:       meta.Command.ToGithubCheckNameString(),
+		HeadSHA:    r.pr.GetHead().GetSHA(),
+		ExternalID: github.String(externalID),
+		// GitHub wants a page with details for check runs that require action
+		DetailsURL: github.String(comment.GetHTMLURL()),
+		Status:     github.String(string(CheckRunStateCompleted)),
+		Conclusion: github.String(string(CheckRunConclusionActionRequired)),
+		Output: &github.CheckRunOutput{
+			Title:   github.String(title),
+			Summary: github.String(WrapMsgWithTimePrefix(msg, time.Now())),
+		},
+		Actions: actions,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create check run that requires action: %w", err)
+	}
+	return nil
+}
+
+// disallowedBuildersMessage explains which builders cannot be used in the
+// repository and which ones can.
+func disallowedBuildersMessage(disallowed []string, allowed []string) string {
+	sorted := append([]string{}, allowed...)
+	sort.Strings(sorted)
+	return fmt.Sprintf("Sorry, but these builders are not allowed in this repository: <code>%s</code>. These are allowed: <code>%s</code>.", strings.Join(disallowed, "</code>, <code>"), strings.Join(sorted, "</code>, <code>"))
+}
+
+// parseErrorMessage explains to the user why the command could not be parsed
+// and points at the offending part of the command.
+func parseErrorMessage(commandLine command.CommandLine, err *command.ParseError) string {
+	msg := fmt.Sprintf("Sorry, but I didn't understand your command in line %d:\n\n```\n%s\n```\n\n%s.", commandLine.Number, err.Annotate(commandLine.Text), err.Message)
+	if err.Suggestion != "" {
+		msg += fmt.Sprintf(" Did you mean <code>%s</code>?", err.Suggestion)
+	}
+	return msg + fmt.Sprintf(" Write <code>%s %s</code> to see what is possible.", command.BuildbotCommand, command.SubcommandHelp)
+}
+
+// selectorErrorMessage explains why no builder matched the selectors of a
+// command and lists the attribute values that can be used instead.
+func selectorErrorMessage(err *command.SelectorError) string {
+	msg := fmt.Sprintf(`Sorry, but no builder matches <code>%s</code>. These attribute values are available:
+
+| Attribute | Values |
+|-----------|--------|
+`, selectorLabel(err.Selectors))
+	for _, attr := range command.SelectorAttributes {
+		values := err.Available[attr]
+		if len(values) == 0 {
+			msg += fmt.Sprintf("| `%s` | _none_ |\n", attr)
+			continue
+		}
+		msg += fmt.Sprintf("| `%s` | `%s` |\n", attr, strings.Join(values, "`, `"))
+	}
+	return msg
 }
 
 func GetAllCheckRunsForPullRequest(gh *github.Client, appInstallID int64, pr *github.PullRequest) ([]*github.CheckRun, error) {
@@ -227,6 +500,12 @@ func GetAllCheckRunsForPullRequest(gh *github.Client, appInstallID int64, pr *gi
 	if pr == nil {
 		return nil, fmt.Errorf("pull request object is nil")
 	}
+	return GetAllCheckRunsForRef(gh, appInstallID, *pr.Base.Repo.Owner.Login, *pr.Base.Repo.Name, *pr.Head.SHA)
+}
+
+// GetAllCheckRunsForRef returns the check runs of all pages for the given
+// ref (e.g. a head SHA).
+func GetAllCheckRunsForRef(gh *github.Client, appInstallID int64, repoOwner string, repoName string, ref string) ([]*github.CheckRun, error) {
 	pagesRemaining := true
 	pages := []*github.CheckRun{}
 	listOpts := &github.ListCheckRunsOptions{
@@ -237,7 +516,7 @@ func GetAllCheckRunsForPullRequest(gh *github.Client, appInstallID int64, pr *gi
 		},
 	}
 	for pagesRemaining {
-		checkRunResults, resp, err := gh.Checks.ListCheckRunsForRef(context.Background(), *pr.Base.Repo.Owner.Login, *pr.Base.Repo.Name, *pr.Head.SHA, listOpts)
+		checkRunResults, resp, err := gh.Checks.ListCheckRunsForRef(context.Background(), repoOwner, repoName, ref, listOpts)
 		if err != nil {
 			return nil, fmt.Errorf("failed to list check runs: %w", err)
 		}
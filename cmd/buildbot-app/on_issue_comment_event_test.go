@@ -1,10 +1,14 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
 	"github.com/migueleliasweb/go-github-mock/src/mock"
 	"github.com/stretchr/testify/require"
 )
@@ -12,12 +16,35 @@ import (
 // MockServer implements Server
 type MockServer struct {
 	mockOptions []mock.MockBackendOption
+	inventory   command.Inventory
+	// IDs of the check runs for which builds have been cancelled
+	cancelledCheckRuns *[]int64
+	// Properties of all "buildbot try" calls
+	tryBotRuns *[][]string
+	// Timeouts of the check runs whose builds are watched
+	buildTimeouts map[int64]time.Duration
+	// Configuration of any repository (nil means an empty configuration)
+	repoConfig *RepoConfig
+	// Error to return instead of a repository configuration
+	repoConfigErr error
+	// Rate limits of all repositories
+	limiter *rateLimiter
+	// Check runs of pull requests whose builds may still be running
+	prBuilds *pullRequestBuilds
+	// Permissions of any installation (nil means that the app may write
+	// check runs)
+	permissions *github.InstallationPermissions
 }
 
 // NewMockServer returns a new MockServer object with the given options
 func NewMockServer(options ...mock.MockBackendOption) *MockServer {
 	return &MockServer{
-		mockOptions: options,
+		mockOptions:        options,
+		cancelledCheckRuns: &[]int64{},
+		tryBotRuns:         &[][]string{},
+		buildTimeouts:      map[int64]time.Duration{},
+		limiter:            newRateLimiter(),
+		prBuilds:           newPullRequestBuilds(),
 	}
 }
 
@@ -26,8 +53,45 @@ func (srv MockServer) NewGithubClient(appInstallationID int64) (*github.Client,
 	return github.NewClient(mockedHTTPClient), nil
 }
 func (srv MockServer) RunTryBot(responsibleGithubLogin string, githubRepoOwner string, githubRepoName string, properties ...string) (string, error) {
+	*srv.tryBotRuns = append(*srv.tryBotRuns, properties)
 	return "", nil
 }
+func (srv MockServer) GetBuilderInventory() (command.Inventory, error) {
+	return srv.inventory, nil
+}
+func (srv MockServer) WatchBuildTimeout(appInstallationID int64, repoOwner string, repoName string, checkRunID int64, timeout time.Duration) {
+	srv.buildTimeouts[checkRunID] = timeout
+}
+func (srv MockServer) GetInstallationPermissions(appInstallationID int64) (*github.InstallationPermissions, error) {
+	if srv.permissions == nil {
+		return &github.InstallationPermissions{Checks: github.String("write")}, nil
+	}
+	return srv.permissions, nil
+}
+func (srv MockServer) GetRepoConfig(appInstallationID int64, repoOwner string, repoName string) (*RepoConfig, error) {
+	if srv.repoConfigErr != nil {
+		return nil, srv.repoConfigErr
+	}
+	if srv.repoConfig == nil {
+		return &RepoConfig{}, nil
+	}
+	return srv.repoConfig, nil
+}
+func (srv MockServer) TakeBuildToken(repoOwner string, repoName string, commenter Commenter, limits RateLimits) error {
+	return srv.limiter.Take(repoOwner, repoName, commenter, limits)
+}
+func (srv MockServer) TrackCheckRun(repoOwner string, repoName string, prNumber int, run inFlightCheckRun) {
+	srv.prBuilds.Add(repoOwner, repoName, prNumber, run)
+}
+func (srv MockServer) TakeSupersededCheckRuns(repoOwner string, repoName string, prNumber int, headSHA string) []inFlightCheckRun {
+	return srv.prBuilds.TakeIf(repoOwner, repoName, prNumber, func(run inFlightCheckRun) bool {
+		return headSHA == "" || run.HeadSHA != headSHA
+	})
+}
+func (srv MockServer) CancelBuilds(checkRunID int64, reason string) error {
+	*srv.cancelledCheckRuns = append(*srv.cancelledCheckRuns, checkRunID)
+	return nil
+}
 
 func issueCommentEventOK() *github.IssueCommentEvent {
 	return &github.IssueCommentEvent{
@@ -57,6 +121,7 @@ func issueCommentEventOK() *github.IssueCommentEvent {
 
 func prOK() github.PullRequest {
 	return github.PullRequest{
+		Number:    github.Int(123),
 		Mergeable: github.Bool(true),
 		Base: &github.PullRequestBranch{
 			Repo: &github.Repository{
@@ -72,6 +137,79 @@ func prOK() github.PullRequest {
 	}
 }
 
+// commandOK returns a build command of johndoe for the given builders.
+func commandOK(builderNames ...string) *command.Command {
+	cmd := command.New()
+	cmd.BuilderNames = builderNames
+	cmd.CommentAuthor = "johndoe"
+	return cmd
+}
+
+// checkRunOK returns a check run that was created by the app for a build
+// with the given meta data. It is named after the build's command. Without a
+// command, the build is one of commandOK("foo"). The check run belongs to the
+// head SHA of prOK().
+func checkRunOK(id int64, status string, conclusion string, meta CheckRunMeta) *github.CheckRun {
+	if meta.Command == nil {
+		meta.Command = commandOK("foo")
+	}
+	externalID, err := meta.ExternalID()
+	if err != nil {
+		panic(err)
+	}
+	checkRun := &github.CheckRun{
+		ID:         github.Int64(id),
+		Name:       github.String(meta.Command.ToGithubCheckNameString()),
+		HTMLURL:    github.String(fmt.Sprintf("http://github.com/check-runs/%d", id)),
+		HeadSHA:    prOK().Head.SHA,
+		Status:     github.String(status),
+		ExternalID: github.String(externalID),
+		Output: &github.CheckRunOutput{
+			Summary: github.String("[Builder: foo]: build successful"),
+		},
+	}
+	if conclusion != "" {
+		checkRun.Conclusion = github.String(conclusion)
+	}
+	return checkRun
+}
+
+// parkedMetaOK returns the meta data of a build of newbie for the given
+// builder that waits for a maintainer's approval.
+func parkedMetaOK(builderName string) CheckRunMeta {
+	cmd := commandOK(builderName)
+	cmd.CommentAuthor = "newbie"
+	return CheckRunMeta{Command: cmd, Approval: &Approval{PullRequestNumber: 123, AuthorAssociation: "FIRST_TIME_CONTRIBUTOR"}}
+}
+
+// recordCheckRunUpdates returns a mock option that records all check run
+// updates.
+func recordCheckRunUpdates(t *testing.T, updates *[]github.UpdateCheckRunOptions) mock.MockBackendOption {
+	return mock.WithRequestMatchHandler(
+		mock.PatchReposCheckRunsByOwnerByRepoByCheckRunId,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			opts := github.UpdateCheckRunOptions{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&opts))
+			*updates = append(*updates, opts)
+			w.Write(mock.MustMarshal(github.CheckRun{}))
+		}),
+	)
+}
+
+// recordComments returns a mock option that records the bodies of all
+// comments that are created.
+func recordComments(t *testing.T, bodies *[]string) mock.MockBackendOption {
+	return mock.WithRequestMatchHandler(
+		mock.PostReposIssuesCommentsByOwnerByRepoByIssueNumber,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			comment := github.IssueComment{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&comment))
+			*bodies = append(*bodies, comment.GetBody())
+			w.Write(mock.MustMarshal(comment))
+		}),
+	)
+}
+
 // tag::test_pr_not_mergable[]
 func TestOnIssueCommentEventAny(t *testing.T) {
 	// end::test_pr_not_mergable[]
@@ -89,10 +227,11 @@ func TestOnIssueCommentEventAny(t *testing.T) {
 			err := fn("1234", "created", event)
 			require.NoError(t, err)
 		})
-		t.Run("deleted event", func(t *testing.T) {
+		t.Run("deleted event without commands", func(t *testing.T) {
 			fn := OnIssueCommentEventAny(NewMockServer())
 			event := issueCommentEventOK()
 			event.Action = github.String("deleted")
+			event.Comment.Body = github.String("Just a comment")
 			err := fn("1234", "deleted", event)
 			require.NoError(t, err)
 		})
@@ -177,6 +316,781 @@ func TestOnIssueCommentEventAny(t *testing.T) {
 		// tag::test_pr_not_mergable[]
 	})
 	// end::test_pr_not_mergable[]
+	t.Run("unsatisfiable selectors", func(t *testing.T) {
+		comments := []string{}
+		srv := NewMockServer(
+			mock.WithRequestMatch(
+				mock.GetReposPullsByOwnerByRepoByPullNumber,
+				prOK(),
+			),
+			recordComments(t, &comments),
+		)
+		srv.inventory = command.Inventory{
+			{Name: "linux-x86_64", Os: "linux", Arch: "x86_64"},
+			{Name: "macos-aarch64", Os: "macos", Arch: "aarch64"},
+		}
+		event := issueCommentEventOK()
+		event.Comment.Body = github.String("/buildbot os=linux arch=aarch64")
+		fn := OnIssueCommentEventAny(srv)
+		err := fn("1234", "created", event)
+		require.NoError(t, err)
+		require.Len(t, comments, 1)
+		require.Contains(t, comments[0], "no builder matches <code>os=linux arch=aarch64</code>")
+		require.Contains(t, comments[0], "| `os` | `linux`, `macos` |")
+		require.Contains(t, comments[0], "| `arch` | `aarch64`, `x86_64` |")
+	})
+	t.Run("invalid command", func(t *testing.T) {
+		comments := []string{}
+		srv := NewMockServer(
+			mock.WithRequestMatch(
+				mock.GetReposPullsByOwnerByRepoByPullNumber,
+				prOK(),
+			),
+			recordComments(t, &comments),
+		)
+		event := issueCommentEventOK()
+		event.Comment.Body = github.String("/buildbot forse=yes")
+		err := OnIssueCommentEventAny(srv)("1234", "created", event)
+		require.NoError(t, err)
+		require.Len(t, comments, 1)
+		require.Contains(t, comments[0], "command in line 1")
+		require.Contains(t, comments[0], "```\n/buildbot forse=yes\n          ^\n```")
+		require.Contains(t, comments[0], `unknown option "forse". Did you mean <code>force</code>?`)
+	})
+	t.Run("multiple commands", func(t *testing.T) {
+		comments := []string{}
+		checkRunNames := []string{}
+		pr := prOK()
+		pr.Base.Ref = github.String("main")
+		pr.Base.SHA = github.String("1ea3fd4f1b3f2b58a7a2f2c2a25d1ecf0c8ff40b")
+		pr.Head.Ref = github.String("feature")
+		srv := NewMockServer(
+			mock.WithRequestMatch(
+				mock.GetReposPullsByOwnerByRepoByPullNumber,
+				pr,
+			),
+			mock.WithRequestMatch(
+				mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+				github.ListCheckRunsResults{Total: github.Int(0)},
+				github.ListCheckRunsResults{Total: github.Int(0)},
+			),
+			mock.WithRequestMatchHandler(
+				mock.PostReposCheckRunsByOwnerByRepo,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					opts := github.CreateCheckRunOptions{}
+					require.NoError(t, json.NewDecoder(r.Body).Decode(&opts))
+					checkRunNames = append(checkRunNames, opts.Name)
+					w.Write(mock.MustMarshal(github.CheckRun{ID: github.Int64(int64(len(checkRunNames)))}))
+				}),
+			),
+			recordComments(t, &comments),
+		)
+		event := issueCommentEventOK()
+		event.Comment.Body = github.String(`Looks good, let's build this on two builders.
+
+/buildbot builder=foo
+/buildbot builder=bar mandatory=no
+
+> /buildbot builder=quoted
+
+` + "```" + `
+/buildbot builder=fenced
+` + "```" + `
+`)
+		err := OnIssueCommentEventAny(srv)("1234", "created", event)
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"@johndoe /buildbot mandatory=true force=false builder=[foo]",
+			"@johndoe /buildbot mandatory=false force=false builder=[bar]",
+		}, checkRunNames)
+		require.Len(t, *srv.tryBotRuns, 2)
+		require.Contains(t, (*srv.tryBotRuns)[0], "--property=github_check_run_id=1")
+		require.Contains(t, (*srv.tryBotRuns)[1], "--property=github_check_run_id=2")
+		// One build log comment per command
+		require.Len(t, comments, 2)
+	})
+	t.Run("matrix", func(t *testing.T) {
+		comments := []string{}
+		checkRunNames := []string{}
+		pr := prOK()
+		pr.Base.Ref = github.String("main")
+		pr.Base.SHA = github.String("1ea3fd4f1b3f2b58a7a2f2c2a25d1ecf0c8ff40b")
+		pr.Head.Ref = github.String("feature")
+		srv := NewMockServer(
+			mock.WithRequestMatch(
+				mock.GetReposPullsByOwnerByRepoByPullNumber,
+				pr,
+			),
+			mock.WithRequestMatch(
+				mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+				// Once for the matrix and once for each of the three builds
+				github.ListCheckRunsResults{Total: github.Int(0)},
+				github.ListCheckRunsResults{Total: github.Int(0)},
+				github.ListCheckRunsResults{Total: github.Int(0)},
+				github.ListCheckRunsResults{Total: github.Int(0)},
+			),
+			mock.WithRequestMatchHandler(
+				mock.PostReposCheckRunsByOwnerByRepo,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					opts := github.CreateCheckRunOptions{}
+					require.NoError(t, json.NewDecoder(r.Body).Decode(&opts))
+					checkRunNames = append(checkRunNames, opts.Name)
+					w.Write(mock.MustMarshal(github.CheckRun{ID: github.Int64(int64(len(checkRunNames)))}))
+				}),
+			),
+			mock.WithRequestMatchHandler(
+				mock.PostReposIssuesCommentsByOwnerByRepoByIssueNumber,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					comment := github.IssueComment{}
+					require.NoError(t, json.NewDecoder(r.Body).Decode(&comment))
+					comments = append(comments, comment.GetBody())
+					comment.ID = github.Int64(42)
+					w.Write(mock.MustMarshal(comment))
+				}),
+			),
+		)
+		srv.inventory = command.Inventory{
+			{Name: "linux-x86_64", Os: "linux", Arch: "x86_64"},
+			{Name: "linux-aarch64", Os: "linux", Arch: "aarch64"},
+			{Name: "macos-aarch64", Os: "macos", Arch: "aarch64"},
+		}
+		event := issueCommentEventOK()
+		event.Comment.Body = github.String("/buildbot os=linux,macos arch=x86_64,aarch64")
+		err := OnIssueCommentEventAny(srv)("1234", "created", event)
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"@johndoe /buildbot mandatory=true force=false os=linux arch=x86_64",
+			"@johndoe /buildbot mandatory=true force=false os=linux arch=aarch64",
+			"@johndoe /buildbot mandatory=true force=false os=macos arch=aarch64",
+		}, checkRunNames)
+		// All builds log to the one comment with the matrix
+		require.Len(t, comments, 1)
+		require.Contains(t, comments[0], "| `os=linux` | requested | requested |")
+		require.Contains(t, comments[0], "| `os=macos` | no matching builder | requested |")
+		require.Len(t, *srv.tryBotRuns, 3)
+		for _, props := range *srv.tryBotRuns {
+			require.Contains(t, props, "--property=github_build_log_comment_id=42")
+		}
+		require.Contains(t, (*srv.tryBotRuns)[2], "--property=command_builders=macos-aarch64")
+	})
+	t.Run("dry run", func(t *testing.T) {
+		pr := prOK()
+		pr.Base.Ref = github.String("main")
+		pr.Base.SHA = github.String("1ea3fd4f1b3f2b58a7a2f2c2a25d1ecf0c8ff40b")
+		pr.Head.Ref = github.String("feature")
+		tests := []struct {
+			name          string
+			body          string
+			existingName  string
+			wantDuplicate string
+		}{
+			{"not blocked", "/buildbot dry-run=yes builder=bar", "@johndoe /buildbot mandatory=true force=false builder=[foo]", "No check run for this pull request's SHA"},
+			{"blocked", "/buildbot dry-run=yes builder=foo", "@johndoe /buildbot mandatory=true force=false builder=[foo]", "blocks this build. Specify <code>force=true</code>"},
+			{"forced", "/buildbot dry-run=yes builder=foo force=yes", "@johndoe /buildbot mandatory=true force=true builder=[foo]", "it would be built again"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				existing := checkRunOK(1, "completed", "success", CheckRunMeta{})
+				existing.Name = github.String(tt.existingName)
+				comments := []string{}
+				srv := NewMockServer(
+					mock.WithRequestMatch(
+						mock.GetReposPullsByOwnerByRepoByPullNumber,
+						pr,
+					),
+					mock.WithRequestMatch(
+						mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+						github.ListCheckRunsResults{
+							Total:     github.Int(1),
+							CheckRuns: []*github.CheckRun{existing},
+						},
+					),
+					mock.WithRequestMatchHandler(
+						mock.PostReposCheckRunsByOwnerByRepo,
+						http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+							t.Error("a dry run must not create a check run")
+						}),
+					),
+					recordComments(t, &comments),
+				)
+				event := issueCommentEventOK()
+				event.Comment.Body = github.String(tt.body)
+				err := OnIssueCommentEventAny(srv)("1234", "created", event)
+				require.NoError(t, err)
+				require.Empty(t, *srv.tryBotRuns)
+				require.Len(t, comments, 1)
+				require.Contains(t, comments[0], "This is a dry run")
+				require.Contains(t, comments[0], tt.wantDuplicate)
+				require.Contains(t, comments[0], "--property=github_pull_request_head_sha=5da7cf6468aabc181b3c7c662539cd3e70526c1b\n")
+				require.Contains(t, comments[0], "--property=command_author=johndoe\n")
+			})
+		}
+		t.Run("matrix", func(t *testing.T) {
+			comments := []string{}
+			srv := NewMockServer(
+				mock.WithRequestMatch(
+					mock.GetReposPullsByOwnerByRepoByPullNumber,
+					pr,
+				),
+				mock.WithRequestMatch(
+					mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+					github.ListCheckRunsResults{Total: github.Int(0)},
+				),
+				recordComments(t, &comments),
+			)
+			srv.inventory = command.Inventory{
+				{Name: "linux-x86_64", Os: "linux", Arch: "x86_64"},
+				{Name: "linux-aarch64", Os: "linux", Arch: "aarch64"},
+			}
+			event := issueCommentEventOK()
+			event.Comment.Body = github.String("/buildbot dry-run=yes os=linux arch=x86_64,aarch64")
+			err := OnIssueCommentEventAny(srv)("1234", "created", event)
+			require.NoError(t, err)
+			require.Empty(t, *srv.tryBotRuns)
+			require.Len(t, comments, 1)
+			require.Contains(t, comments[0], "#### `os=linux arch=x86_64`")
+			require.Contains(t, comments[0], "**Builders:** `linux-aarch64`")
+		})
+	})
+	t.Run("custom properties", func(t *testing.T) {
+		pr := prOK()
+		pr.Base.Ref = github.String("main")
+		pr.Base.SHA = github.String("1ea3fd4f1b3f2b58a7a2f2c2a25d1ecf0c8ff40b")
+		pr.Head.Ref = github.String("feature")
+		t.Run("allowed", func(t *testing.T) {
+			summaries := []string{}
+			srv := NewMockServer(
+				mock.WithRequestMatch(
+					mock.GetReposPullsByOwnerByRepoByPullNumber,
+					pr,
+				),
+				mock.WithRequestMatch(
+					mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+					github.ListCheckRunsResults{Total: github.Int(0)},
+				),
+				mock.WithRequestMatchHandler(
+					mock.PostReposCheckRunsByOwnerByRepo,
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						opts := github.CreateCheckRunOptions{}
+						require.NoError(t, json.NewDecoder(r.Body).Decode(&opts))
+						summaries = append(summaries, opts.GetOutput().GetSummary())
+						w.Write(mock.MustMarshal(github.CheckRun{ID: github.Int64(1)}))
+					}),
+				),
+				recordComments(t, &[]string{}),
+			)
+			srv.repoConfig = &RepoConfig{AllowedProperties: []string{"test_filter"}}
+			event := issueCommentEventOK()
+			event.Comment.Body = github.String("/buildbot builder=foo prop:test_filter=bar")
+			err := OnIssueCommentEventAny(srv)("1234", "created", event)
+			require.NoError(t, err)
+			require.Len(t, *srv.tryBotRuns, 1)
+			require.Contains(t, (*srv.tryBotRuns)[0], "--property=user_test_filter=bar")
+			require.Len(t, summaries, 1)
+			require.Contains(t, summaries[0], "| `user_test_filter` | `bar` |")
+		})
+		t.Run("not allowed", func(t *testing.T) {
+			comments := []string{}
+			srv := NewMockServer(
+				mock.WithRequestMatch(
+					mock.GetReposPullsByOwnerByRepoByPullNumber,
+					pr,
+				),
+				recordComments(t, &comments),
+			)
+			srv.repoConfig = &RepoConfig{AllowedProperties: []string{"test_filter"}}
+			event := issueCommentEventOK()
+			event.Comment.Body = github.String("/buildbot builder=foo prop:test_filter=bar prop:secret=baz")
+			err := OnIssueCommentEventAny(srv)("1234", "created", event)
+			require.NoError(t, err)
+			require.Empty(t, *srv.tryBotRuns)
+			require.Len(t, comments, 1)
+			require.Contains(t, comments[0], "not allowed in this repository: <code>secret</code>. These are allowed: <code>test_filter</code>.")
+		})
+	})
+	t.Run("timeout and priority", func(t *testing.T) {
+		pr := prOK()
+		pr.Base.Ref = github.String("main")
+		pr.Base.SHA = github.String("1ea3fd4f1b3f2b58a7a2f2c2a25d1ecf0c8ff40b")
+		pr.Head.Ref = github.String("feature")
+		srv := NewMockServer(
+			mock.WithRequestMatch(
+				mock.GetReposPullsByOwnerByRepoByPullNumber,
+				pr,
+			),
+			mock.WithRequestMatch(
+				mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+				github.ListCheckRunsResults{Total: github.Int(0)},
+			),
+			mock.WithRequestMatch(
+				mock.PostReposCheckRunsByOwnerByRepo,
+				github.CheckRun{ID: github.Int64(42)},
+			),
+			recordComments(t, &[]string{}),
+		)
+		event := issueCommentEventOK()
+		event.Comment.Body = github.String("/buildbot builder=foo timeout=2h priority=high")
+		err := OnIssueCommentEventAny(srv)("1234", "created", event)
+		require.NoError(t, err)
+		require.Len(t, *srv.tryBotRuns, 1)
+		require.Contains(t, (*srv.tryBotRuns)[0], "--property=build_request_priority=10")
+		require.Contains(t, (*srv.tryBotRuns)[0], "--property=command_priority=high")
+		require.Contains(t, (*srv.tryBotRuns)[0], "--property=command_timeout=2h0m0s")
+		require.Equal(t, map[int64]time.Duration{42: 2 * time.Hour}, srv.buildTimeouts)
+	})
+	t.Run("authorization", func(t *testing.T) {
+		pr := prOK()
+		pr.Base.Ref = github.String("main")
+		pr.Base.SHA = github.String("1ea3fd4f1b3f2b58a7a2f2c2a25d1ecf0c8ff40b")
+		pr.Head.Ref = github.String("feature")
+		policy := AuthorizationPolicy{
+			Rules: []AuthorizationRule{
+				{Option: command.CommandOptionForce, Value: "yes", Associations: []string{"OWNER", "MEMBER"}},
+			},
+		}
+		tests := []struct {
+			name              string
+			authorAssociation string
+			wantConclusion    CheckRunConclusion
+			wantTryBotRuns    int
+			wantComment       string
+		}{
+			{"denied", "CONTRIBUTOR", CheckRunConclusionActionRequired, 0, "Sorry, but you are not allowed to run this command:\n\n* <code>force=yes</code> may only be used by OWNER or MEMBER"},
+			{"allowed", "MEMBER", "", 1, buildLogCommentIntro},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				comments := []string{}
+				checkRuns := []github.CreateCheckRunOptions{}
+				srv := NewMockServer(
+					mock.WithRequestMatch(
+						mock.GetReposPullsByOwnerByRepoByPullNumber,
+						pr,
+					),
+					mock.WithRequestMatch(
+						mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+						github.ListCheckRunsResults{Total: github.Int(0)},
+					),
+					mock.WithRequestMatchHandler(
+						mock.PostReposCheckRunsByOwnerByRepo,
+						http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+							opts := github.CreateCheckRunOptions{}
+							require.NoError(t, json.NewDecoder(r.Body).Decode(&opts))
+							checkRuns = append(checkRuns, opts)
+							w.Write(mock.MustMarshal(github.CheckRun{ID: github.Int64(1)}))
+						}),
+					),
+					recordComments(t, &comments),
+				)
+				srv.repoConfig = &RepoConfig{Authorization: policy}
+				event := issueCommentEventOK()
+				event.Comment.Body = github.String("/buildbot builder=foo force=yes")
+				event.Comment.AuthorAssociation = github.String(tt.authorAssociation)
+				err := OnIssueCommentEventAny(srv)("1234", "created", event)
+				require.NoError(t, err)
+				require.Len(t, *srv.tryBotRuns, tt.wantTryBotRuns)
+				require.Len(t, comments, 1)
+				require.Contains(t, comments[0], tt.wantComment)
+				require.Len(t, checkRuns, 1)
+				require.Equal(t, "@johndoe /buildbot mandatory=true force=true builder=[foo]", checkRuns[0].Name)
+				require.Equal(t, string(tt.wantConclusion), checkRuns[0].GetConclusion())
+			})
+		}
+		t.Run("retry", func(t *testing.T) {
+			comments := []string{}
+			checkRunNames := []string{}
+			failedCheckRuns := github.ListCheckRunsResults{
+				Total: github.Int(2),
+				CheckRuns: []*github.CheckRun{
+					checkRunOK(1, "completed", "failure", CheckRunMeta{}),
+					checkRunOK(2, "completed", "failure", CheckRunMeta{Command: commandOK("bar")}),
+				},
+			}
+			srv := NewMockServer(
+				mock.WithRequestMatch(
+					mock.GetReposPullsByOwnerByRepoByPullNumber,
+					pr,
+				),
+				mock.WithRequestMatch(
+					mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+					failedCheckRuns,
+					failedCheckRuns,
+				),
+				mock.WithRequestMatchHandler(
+					mock.PostReposCheckRunsByOwnerByRepo,
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						opts := github.CreateCheckRunOptions{}
+						require.NoError(t, json.NewDecoder(r.Body).Decode(&opts))
+						checkRunNames = append(checkRunNames, opts.Name)
+						w.Write(mock.MustMarshal(github.CheckRun{ID: github.Int64(3)}))
+					}),
+				),
+				recordComments(t, &comments),
+			)
+			srv.repoConfig = &RepoConfig{Authorization: AuthorizationPolicy{Rules: []AuthorizationRule{
+				{Option: command.CommandOptionBuilder, Value: "foo", Associations: []string{"OWNER", "MEMBER"}},
+			}}}
+			event := issueCommentEventOK()
+			event.Comment.Body = github.String("/buildbot retry")
+			event.Comment.AuthorAssociation = github.String("CONTRIBUTOR")
+			err := OnIssueCommentEventAny(srv)("1234", "created", event)
+			require.NoError(t, err)
+			// Only the build of the builder that the commenter may use is retried
+			require.Equal(t, []string{"@johndoe /buildbot mandatory=true force=true builder=[bar]"}, checkRunNames)
+			require.Len(t, *srv.tryBotRuns, 1)
+			require.Contains(t, comments[len(comments)-1], "Sorry @johndoe, but you are not allowed to retry these builds:\n\n* <code>@johndoe /buildbot mandatory=true force=false builder=[foo]</code>: <code>builder=foo</code> may only be used by OWNER or MEMBER")
+		})
+	})
+	t.Run("approval", func(t *testing.T) {
+		pr := prOK()
+		pr.Base.Ref = github.String("main")
+		pr.Base.SHA = github.String("1ea3fd4f1b3f2b58a7a2f2c2a25d1ecf0c8ff40b")
+		pr.Head.Ref = github.String("feature")
+		t.Run("parked", func(t *testing.T) {
+			comments := []string{}
+			checkRuns := []github.CreateCheckRunOptions{}
+			srv := NewMockServer(
+				mock.WithRequestMatch(
+					mock.GetReposPullsByOwnerByRepoByPullNumber,
+					pr,
+				),
+				mock.WithRequestMatchHandler(
+					mock.PostReposCheckRunsByOwnerByRepo,
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						opts := github.CreateCheckRunOptions{}
+						require.NoError(t, json.NewDecoder(r.Body).Decode(&opts))
+						checkRuns = append(checkRuns, opts)
+						w.Write(mock.MustMarshal(github.CheckRun{ID: github.Int64(1)}))
+					}),
+				),
+				recordComments(t, &comments),
+			)
+			limits := RateLimits{User: RateLimit{Builds: 1, Per: time.Hour}}
+			srv.repoConfig = &RepoConfig{RateLimits: limits}
+			event := issueCommentEventOK()
+			event.Comment.Body = github.String("/buildbot builder=foo")
+			event.Comment.AuthorAssociation = github.String("FIRST_TIME_CONTRIBUTOR")
+			err := OnIssueCommentEventAny(srv)("1234", "created", event)
+			require.NoError(t, err)
+			require.Empty(t, *srv.tryBotRuns)
+			require.Len(t, comments, 1)
+			require.Contains(t, comments[0], "have to be approved by a maintainer (OWNER, MEMBER or COLLABORATOR)")
+			require.Len(t, checkRuns, 1)
+			require.Equal(t, string(CheckRunConclusionActionRequired), checkRuns[0].GetConclusion())
+			require.Equal(t, CheckRunActionApproveBuild, checkRuns[0].Actions[0].Identifier)
+			meta, err := CheckRunMetaFromCheckRun(&github.CheckRun{ExternalID: checkRuns[0].ExternalID})
+			require.NoError(t, err)
+			require.Equal(t, &Approval{PullRequestNumber: 123, AuthorAssociation: "FIRST_TIME_CONTRIBUTOR"}, meta.Approval)
+			// The build only counts against the rate limits once it is approved
+			require.NoError(t, srv.TakeBuildToken("janedoe", "examplerepo", Commenter{Login: "johndoe", AuthorAssociation: "FIRST_TIME_CONTRIBUTOR"}, limits))
+		})
+		t.Run("approved", func(t *testing.T) {
+			comments := []string{}
+			updates := []github.UpdateCheckRunOptions{}
+			checkRuns := github.ListCheckRunsResults{
+				Total: github.Int(2),
+				CheckRuns: []*github.CheckRun{
+					checkRunOK(1, "completed", "action_required", parkedMetaOK("foo")),
+					checkRunOK(2, "completed", "success", CheckRunMeta{Command: commandOK("bar")}),
+				},
+			}
+			srv := NewMockServer(
+				mock.WithRequestMatch(
+					mock.GetReposPullsByOwnerByRepoByPullNumber,
+					pr,
+				),
+				mock.WithRequestMatch(
+					mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+					checkRuns,
+					checkRuns,
+				),
+				mock.WithRequestMatch(
+					mock.PostReposCheckRunsByOwnerByRepo,
+					github.CheckRun{ID: github.Int64(3)},
+				),
+				recordCheckRunUpdates(t, &updates),
+				recordComments(t, &comments),
+			)
+			event := issueCommentEventOK()
+			event.Comment.Body = github.String("/buildbot approve")
+			event.Comment.AuthorAssociation = github.String("MEMBER")
+			err := OnIssueCommentEventAny(srv)("1234", "created", event)
+			require.NoError(t, err)
+			require.Len(t, *srv.tryBotRuns, 1)
+			require.Contains(t, (*srv.tryBotRuns)[0], "--property=command_author=newbie")
+			require.Len(t, updates, 1)
+			require.Equal(t, string(CheckRunConclusionNeutral), updates[0].GetConclusion())
+			require.Contains(t, updates[0].GetExternalID(), `"approved_by":"johndoe"`)
+			// The build log comment and the approval comment
+			require.Len(t, comments, 2)
+			require.Contains(t, comments[1], "These builds were approved and started:\n\n* @newbie /buildbot")
+		})
+		t.Run("approved over rate limit", func(t *testing.T) {
+			comments := []string{}
+			updates := []github.UpdateCheckRunOptions{}
+			srv := NewMockServer(
+				mock.WithRequestMatch(
+					mock.GetReposPullsByOwnerByRepoByPullNumber,
+					pr,
+				),
+				mock.WithRequestMatch(
+					mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+					github.ListCheckRunsResults{
+						Total:     github.Int(1),
+						CheckRuns: []*github.CheckRun{checkRunOK(1, "completed", "action_required", parkedMetaOK("foo"))},
+					},
+				),
+				recordCheckRunUpdates(t, &updates),
+				recordComments(t, &comments),
+			)
+			limits := RateLimits{User: RateLimit{Builds: 1, Per: time.Hour}}
+			srv.repoConfig = &RepoConfig{RateLimits: limits}
+			// The requester already used up the limit with another build
+			require.NoError(t, srv.TakeBuildToken("janedoe", "examplerepo", Commenter{Login: "newbie", AuthorAssociation: "FIRST_TIME_CONTRIBUTOR"}, limits))
+			event := issueCommentEventOK()
+			event.Comment.Body = github.String("/buildbot approve")
+			event.Comment.AuthorAssociation = github.String("MEMBER")
+			err := OnIssueCommentEventAny(srv)("1234", "created", event)
+			require.NoError(t, err)
+			require.Empty(t, *srv.tryBotRuns)
+			// The check run keeps waiting for approval
+			require.Empty(t, updates)
+			require.Len(t, comments, 1)
+			require.Contains(t, comments[0], "These builds were not started because of rate limits and still wait for approval:\n\n* @newbie /buildbot mandatory=true force=false builder=[foo]: Sorry @newbie, but you requested more than 1 builds in 1h0m0s.")
+		})
+		t.Run("retry needs approval", func(t *testing.T) {
+			comments := []string{}
+			srv := NewMockServer(
+				mock.WithRequestMatch(
+					mock.GetReposPullsByOwnerByRepoByPullNumber,
+					pr,
+				),
+				mock.WithRequestMatch(
+					mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+					github.ListCheckRunsResults{
+						Total:     github.Int(1),
+						CheckRuns: []*github.CheckRun{checkRunOK(1, "completed", "failure", CheckRunMeta{})},
+					},
+				),
+				mock.WithRequestMatch(
+					mock.PostReposCheckRunsByOwnerByRepo,
+					github.CheckRun{ID: github.Int64(2)},
+				),
+				recordComments(t, &comments),
+			)
+			event := issueCommentEventOK()
+			event.Comment.Body = github.String("/buildbot retry")
+			event.Comment.AuthorAssociation = github.String("FIRST_TIME_CONTRIBUTOR")
+			err := OnIssueCommentEventAny(srv)("1234", "created", event)
+			require.NoError(t, err)
+			require.Empty(t, *srv.tryBotRuns)
+			require.Len(t, comments, 1)
+			require.Contains(t, comments[0], "Sorry @johndoe, but builds of first-time and external contributors have to be requested with a <code>/buildbot</code> comment and approved by a maintainer.")
+		})
+		t.Run("not a maintainer", func(t *testing.T) {
+			comments := []string{}
+			srv := NewMockServer(
+				mock.WithRequestMatch(
+					mock.GetReposPullsByOwnerByRepoByPullNumber,
+					pr,
+				),
+				recordComments(t, &comments),
+			)
+			event := issueCommentEventOK()
+			event.Comment.Body = github.String("/buildbot approve")
+			event.Comment.AuthorAssociation = github.String("CONTRIBUTOR")
+			err := OnIssueCommentEventAny(srv)("1234", "created", event)
+			require.NoError(t, err)
+			require.Empty(t, *srv.tryBotRuns)
+			require.Len(t, comments, 1)
+			require.Contains(t, comments[0], "Sorry, but only OWNER, MEMBER or COLLABORATOR may approve builds.")
+		})
+	})
+	t.Run("repository configuration", func(t *testing.T) {
+		pr := prOK()
+		pr.Base.Ref = github.String("main")
+		pr.Base.SHA = github.String("1ea3fd4f1b3f2b58a7a2f2c2a25d1ecf0c8ff40b")
+		pr.Head.Ref = github.String("feature")
+		t.Run("invalid", func(t *testing.T) {
+			comments := []string{}
+			srv := NewMockServer(recordComments(t, &comments))
+			srv.repoConfigErr = &RepoConfigError{Err: fmt.Errorf("unknown field \"allowed_builder\"")}
+			event := issueCommentEventOK()
+			event.Comment.Body = github.String("/buildbot builder=foo")
+			err := OnIssueCommentEventAny(srv)("1234", "created", event)
+			require.NoError(t, err)
+			require.Empty(t, *srv.tryBotRuns)
+			require.Len(t, comments, 1)
+			require.Contains(t, comments[0], "<code>.github/buildbot-app.yaml</code> on the default branch is invalid")
+			require.Contains(t, comments[0], `unknown field "allowed_builder"`)
+		})
+		tests := []struct {
+			name         string
+			config       RepoConfig
+			body         string
+			wantBuilders string
+		}{
+			{"default builders", RepoConfig{DefaultBuilders: []string{"linux", "macos"}}, "/buildbot", "--property=command_builders=linux;macos"},
+			{"alias", RepoConfig{Aliases: map[string]string{"full": "builder=linux builder=macos"}}, "/buildbot FULL force=yes", "--property=command_builders=linux;macos"},
+			{"preset", RepoConfig{Presets: command.Presets{"full": {Builders: []string{"macos", "linux"}}}}, "/buildbot preset=full", "--property=command_builders=linux;macos"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				srv := NewMockServer(
+					mock.WithRequestMatch(
+						mock.GetReposPullsByOwnerByRepoByPullNumber,
+						pr,
+					),
+					mock.WithRequestMatch(
+						mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+						github.ListCheckRunsResults{Total: github.Int(0)},
+					),
+					mock.WithRequestMatch(
+						mock.PostReposCheckRunsByOwnerByRepo,
+						github.CheckRun{ID: github.Int64(1)},
+					),
+					recordComments(t, &[]string{}),
+				)
+				srv.repoConfig = &tt.config
+				event := issueCommentEventOK()
+				event.Comment.Body = github.String(tt.body)
+				err := OnIssueCommentEventAny(srv)("1234", "created", event)
+				require.NoError(t, err)
+				require.Len(t, *srv.tryBotRuns, 1)
+				require.Contains(t, (*srv.tryBotRuns)[0], tt.wantBuilders)
+			})
+		}
+		t.Run("disallowed builder", func(t *testing.T) {
+			comments := []string{}
+			srv := NewMockServer(
+				mock.WithRequestMatch(
+					mock.GetReposPullsByOwnerByRepoByPullNumber,
+					pr,
+				),
+				recordComments(t, &comments),
+			)
+			srv.repoConfig = &RepoConfig{AllowedBuilders: []string{"linux", "macos"}}
+			event := issueCommentEventOK()
+			event.Comment.Body = github.String("/buildbot builder=linux builder=windows")
+			err := OnIssueCommentEventAny(srv)("1234", "created", event)
+			require.NoError(t, err)
+			require.Empty(t, *srv.tryBotRuns)
+			require.Len(t, comments, 1)
+			require.Contains(t, comments[0], "not allowed in this repository: <code>windows</code>. These are allowed: <code>linux</code>, <code>macos</code>.")
+		})
+	})
+	t.Run("rate limits", func(t *testing.T) {
+		pr := prOK()
+		pr.Base.Ref = github.String("main")
+		pr.Base.SHA = github.String("1ea3fd4f1b3f2b58a7a2f2c2a25d1ecf0c8ff40b")
+		pr.Head.Ref = github.String("feature")
+		comments := []string{}
+		srv := NewMockServer(
+			mock.WithRequestMatch(
+				mock.GetReposPullsByOwnerByRepoByPullNumber,
+				pr,
+				pr,
+			),
+			mock.WithRequestMatch(
+				mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+				github.ListCheckRunsResults{Total: github.Int(0)},
+			),
+			mock.WithRequestMatch(
+				mock.PostReposCheckRunsByOwnerByRepo,
+				github.CheckRun{ID: github.Int64(1)},
+			),
+			recordComments(t, &comments),
+		)
+		srv.repoConfig = &RepoConfig{RateLimits: RateLimits{User: RateLimit{Builds: 1, Per: time.Hour}}}
+		event := issueCommentEventOK()
+		event.Comment.Body = github.String("/buildbot builder=foo force=yes")
+		require.NoError(t, OnIssueCommentEventAny(srv)("1234", "created", event))
+		require.NoError(t, OnIssueCommentEventAny(srv)("1234", "created", event))
+		require.Len(t, *srv.tryBotRuns, 1)
+		require.Contains(t, comments[len(comments)-1], "Sorry @johndoe, but you requested more than 1 builds in 1h0m0s. Please try again after ")
+	})
+	t.Run("subcommands", func(t *testing.T) {
+		t.Run("help", func(t *testing.T) {
+			comments := []string{}
+			srv := NewMockServer(
+				mock.WithRequestMatch(
+					mock.GetReposPullsByOwnerByRepoByPullNumber,
+					prOK(),
+				),
+				recordComments(t, &comments),
+			)
+			event := issueCommentEventOK()
+			event.Comment.Body = github.String("/buildbot help")
+			err := OnIssueCommentEventAny(srv)("1234", "created", event)
+			require.NoError(t, err)
+			require.Len(t, comments, 1)
+			require.Contains(t, comments[0], command.HelpText())
+		})
+		t.Run("status", func(t *testing.T) {
+			comments := []string{}
+			srv := NewMockServer(
+				mock.WithRequestMatch(
+					mock.GetReposPullsByOwnerByRepoByPullNumber,
+					prOK(),
+				),
+				mock.WithRequestMatch(
+					mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+					github.ListCheckRunsResults{
+						Total: github.Int(1),
+						CheckRuns: []*github.CheckRun{
+							checkRunOK(1, "queued", "", CheckRunMeta{}),
+						},
+					},
+				),
+				recordComments(t, &comments),
+			)
+			event := issueCommentEventOK()
+			event.Comment.Body = github.String("/buildbot status")
+			err := OnIssueCommentEventAny(srv)("1234", "created", event)
+			require.NoError(t, err)
+			require.Len(t, comments, 1)
+			require.Contains(t, comments[0], "| [@johndoe /buildbot mandatory=true force=false builder=[foo]](http://github.com/check-runs/1) | queued | - |")
+		})
+		t.Run("cancel", func(t *testing.T) {
+			comments := []string{}
+			updatedCheckRuns := []string{}
+			srv := NewMockServer(
+				mock.WithRequestMatch(
+					mock.GetReposPullsByOwnerByRepoByPullNumber,
+					prOK(),
+				),
+				mock.WithRequestMatch(
+					mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+					github.ListCheckRunsResults{
+						Total: github.Int(3),
+						CheckRuns: []*github.CheckRun{
+							checkRunOK(1, "in_progress", "", CheckRunMeta{}),
+							checkRunOK(2, "in_progress", "", CheckRunMeta{Command: commandOK("bar")}),
+							checkRunOK(3, "completed", "success", CheckRunMeta{}),
+						},
+					},
+				),
+				mock.WithRequestMatchHandler(
+					mock.PatchReposCheckRunsByOwnerByRepoByCheckRunId,
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						opts := github.UpdateCheckRunOptions{}
+						require.NoError(t, json.NewDecoder(r.Body).Decode(&opts))
+						updatedCheckRuns = append(updatedCheckRuns, opts.GetConclusion())
+						w.Write(mock.MustMarshal(github.CheckRun{}))
+					}),
+				),
+				recordComments(t, &comments),
+			)
+			event := issueCommentEventOK()
+			event.Comment.Body = github.String("/buildbot cancel builder=foo")
+			err := OnIssueCommentEventAny(srv)("1234", "created", event)
+			require.NoError(t, err)
+			require.Equal(t, []int64{1}, *srv.cancelledCheckRuns)
+			require.Equal(t, []string{"cancelled"}, updatedCheckRuns)
+			require.Len(t, comments, 1)
+			require.Contains(t, comments[0], "* [@johndoe /buildbot mandatory=true force=false builder=[foo]](http://github.com/check-runs/1)")
+		})
+	})
 	// t.Run("ok", func(t *testing.T) {
 	// 	pr := prOK()
 	// 	srv := NewMockServer(
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// disallowedProperties returns the sorted names of the given custom
+// properties that are not in the list of allowed properties.
+func disallowedProperties(properties map[string]string, allowed []string) []string {
+	disallowed := []string{}
+	for name := range properties {
+		if !containsString(allowed, name) {
+			disallowed = append(disallowed, name)
+		}
+	}
+	sort.Strings(disallowed)
+	return disallowed
+}
+
+// disallowedPropertiesMessage explains which custom properties cannot be used
+// in the repository and which ones can.
+func disallowedPropertiesMessage(disallowed []string, allowed []string) string {
+	msg := fmt.Sprintf("Sorry, but these properties are not allowed in this repository: <code>%s</code>. ", strings.Join(disallowed, "</code>, <code>"))
+	if len(allowed) == 0 {
+		return msg + "No custom properties are allowed here."
+	}
+	sorted := append([]string{}, allowed...)
+	sort.Strings(sorted)
+	return msg + fmt.Sprintf("These are allowed: <code>%s</code>.", strings.Join(sorted, "</code>, <code>"))
+}
+
+// customPropertiesSummary returns a markdown table of the custom properties
+// that are passed to the builders or an empty string if there are none.
+func customPropertiesSummary(properties map[string]string) string {
+	if len(properties) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	sb.WriteString("Custom properties:\n\n| Property | Value |\n|----------|-------|\n")
+	for _, name := range names {
+		fmt.Fprintf(&sb, "| `user_%s` | `%s` |\n", name, properties[name])
+	}
+	return sb.String()
+}
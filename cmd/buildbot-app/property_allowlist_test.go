@@ -0,0 +1,13 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisallowedProperties(t *testing.T) {
+	properties := map[string]string{"test_filter": "foo", "secret": "bar", "another": "baz"}
+	require.Equal(t, []string{"another", "secret"}, disallowedProperties(properties, []string{"test_filter"}))
+	require.Empty(t, disallowedProperties(properties, []string{"test_filter", "secret", "another"}))
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/buildbot_http_status_push"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+)
+
+// tag::rate_limits[]
+// RateLimits protect the workers from commenters and repositories that
+// request more builds than their share. Every build or retry command takes one
+// token from the commenter's and from the repository's bucket. On top of
+// that, the time that builds spend on workers is summed up per day (UTC). A
+// command is rejected if a bucket is empty or a quota is used up.
+type RateLimits struct {
+	// Limits of every commenter
+	User RateLimit `yaml:"user"`
+	// Limits of commenters with one of the MemberAssociations. Limits that
+	// aren't given are taken from User.
+	Members RateLimit `yaml:"members"`
+	// Author associations that get the Members limits. If not given, OWNER,
+	// MEMBER and COLLABORATOR do.
+	MemberAssociations []string `yaml:"member_associations"`
+	// Limits of the repository as a whole
+	Repo RateLimit `yaml:"repo"`
+}
+
+// A RateLimit is a token bucket that holds up to Builds tokens and that is
+// refilled completely within Per, plus a daily quota of worker time. Zero
+// values mean no limit.
+type RateLimit struct {
+	Builds     int           `yaml:"builds"`
+	Per        time.Duration `yaml:"per"`
+	WorkerTime time.Duration `yaml:"worker_time"`
+}
+
+// end::rate_limits[]
+
+var defaultMemberAssociations = []string{"OWNER", "MEMBER", "COLLABORATOR"}
+
+// Validate returns an error if a limit is negative or if a bucket has no
+// refill interval.
+func (l RateLimits) Validate() error {
+	limits := []struct {
+		name  string
+		limit RateLimit
+	}{
+		{"user", l.User},
+		{"members", l.Members},
+		{"repo", l.Repo},
+	}
+	for _, c := range limits {
+		if c.limit.Builds < 0 || c.limit.Per < 0 || c.limit.WorkerTime < 0 {
+			return fmt.Errorf("%s: limits must not be negative", c.name)
+		}
+		if c.limit.Builds > 0 && c.limit.Per == 0 {
+			return fmt.Errorf("%s: builds need a duration in which they are refilled (per)", c.name)
+		}
+	}
+	return nil
+}
+
+// forCommenter returns the limits that apply to the commenter.
+func (l RateLimits) forCommenter(commenter Commenter) RateLimit {
+	associations := l.MemberAssociations
+	if associations == nil {
+		associations = defaultMemberAssociations
+	}
+	if !containsFold(associations, commenter.AuthorAssociation) {
+		return l.User
+	}
+	limit := l.Members
+	if limit.Builds == 0 {
+		limit.Builds, limit.Per = l.User.Builds, l.User.Per
+	}
+	if limit.WorkerTime == 0 {
+		limit.WorkerTime = l.User.WorkerTime
+	}
+	return limit
+}
+
+// A RateLimitError tells that a command was rejected because of a rate limit
+// and when it may be tried again.
+type RateLimitError struct {
+	Reason  string
+	RetryAt time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s (retry at %s)", e.Reason, e.RetryAt.Format(time.RFC1123Z))
+}
+
+// rateLimiter keeps the token buckets and the used worker time of commenters
+// and repositories.
+type rateLimiter struct {
+	mu  sync.Mutex
+	now func() time.Time
+	// "owner/name" or "owner/name@login" -> bucket
+	buckets map[string]*tokenBucket
+	// "owner/name" or "owner/name@login" -> worker time of the day
+	workerTime map[string]*workerTimeUsage
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+type workerTimeUsage struct {
+	day  string
+	used time.Duration
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		now:        time.Now,
+		buckets:    map[string]*tokenBucket{},
+		workerTime: map[string]*workerTimeUsage{},
+	}
+}
+
+// Take takes a token for a build of the commenter in the repository. If a
+// limit is exceeded, no token is taken and a *RateLimitError is returned.
+func (l *rateLimiter) Take(repoOwner string, repoName string, commenter Commenter, limits RateLimits) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	repoKey := repoOwner + "/" + repoName
+	userKey := repoKey + "@" + commenter.Login
+	userLimit := limits.forCommenter(commenter)
+
+	checks := []struct {
+		key   string
+		limit RateLimit
+		who   string
+	}{
+		{userKey, userLimit, "you"},
+		{repoKey, limits.Repo, "this repository"},
+	}
+	for _, c := range checks {
+		if c.limit.WorkerTime > 0 && l.usedWorkerTime(c.key, now) >= c.limit.WorkerTime {
+			return &RateLimitError{
+				Reason:  fmt.Sprintf("%s used up the worker time of %s for today", c.who, c.limit.WorkerTime),
+				RetryAt: nextDay(now),
+			}
+		}
+		if c.limit.Builds > 0 {
+			if b := l.bucket(c.key, c.limit, now); b.tokens < 1 {
+				return &RateLimitError{
+					Reason:  fmt.Sprintf("%s requested more than %d builds in %s", c.who, c.limit.Builds, c.limit.Per),
+					RetryAt: now.Add(time.Duration((1 - b.tokens) * float64(c.limit.Per) / float64(c.limit.Builds))),
+				}
+			}
+		}
+	}
+	for _, c := range checks {
+		if c.limit.Builds > 0 {
+			l.bucket(c.key, c.limit, now).tokens--
+		}
+	}
+	return nil
+}
+
+// bucket returns the refilled bucket for the key.
+func (l *rateLimiter) bucket(key string, limit RateLimit, now time.Time) *tokenBucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(limit.Builds), updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.updated).Seconds() * float64(limit.Builds) / limit.Per.Seconds()
+	if b.tokens > float64(limit.Builds) {
+		b.tokens = float64(limit.Builds)
+	}
+	b.updated = now
+	return b
+}
+
+// AddWorkerTime adds the time that a build of the commenter spent on a worker
+// to the commenter's and the repository's worker time of the day.
+func (l *rateLimiter) AddWorkerTime(repoOwner string, repoName string, login string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	repoKey := repoOwner + "/" + repoName
+	for _, key := range []string{repoKey, repoKey + "@" + login} {
+		l.usedWorkerTime(key, now)
+		l.workerTime[key].used += d
+	}
+}
+
+// usedWorkerTime returns the worker time of the key for the day of now. The
+// usage of previous days is dropped.
+func (l *rateLimiter) usedWorkerTime(key string, now time.Time) time.Duration {
+	day := now.UTC().Format("2006-01-02")
+	usage, ok := l.workerTime[key]
+	if !ok || usage.day != day {
+		usage = &workerTimeUsage{day: day}
+		l.workerTime[key] = usage
+	}
+	return usage.used
+}
+
+// nextDay returns the start of the day (UTC) after now.
+func nextDay(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+// buildWorkerTime returns how long a completed build spent on its worker
+// based on the timestamps (in seconds) that Buildbot reports.
+func buildWorkerTime(startedAt int, completeAt int) time.Duration {
+	if startedAt <= 0 || completeAt < startedAt {
+		return 0
+	}
+	return time.Duration(completeAt-startedAt) * time.Second
+}
+
+// chargedWorkerTime returns the worker time of a completed build that counts
+// against the quotas. A build of the delegationBuilder only waits for the
+// builds that it triggered, which are charged on their own.
+func chargedWorkerTime(status buildbot_http_status_push.Data) time.Duration {
+	if status.Builder.Name == delegationBuilderName {
+		return 0
+	}
+	return buildWorkerTime(status.StartedAt, status.CompleteAt)
+}
+
+// rateLimitMessage explains why a command was rejected and when it may be
+// tried again.
+func rateLimitMessage(login string, err *RateLimitError, now time.Time) string {
+	return fmt.Sprintf(
+		"Sorry @%s, but %s. Please try again after %s (in %s). <code>%s help</code> and <code>%s status</code> can be used at any time.",
+		login,
+		err.Reason,
+		err.RetryAt.UTC().Format(time.RFC1123Z),
+		err.RetryAt.Sub(now).Round(time.Second),
+		command.BuildbotCommand,
+		command.BuildbotCommand,
+	)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/buildbot_http_status_push"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimits_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  RateLimits
+		wantErr string
+	}{
+		{"empty", RateLimits{}, ""},
+		{"ok", RateLimits{User: RateLimit{Builds: 5, Per: time.Hour, WorkerTime: 4 * time.Hour}}, ""},
+		{"negative", RateLimits{Repo: RateLimit{WorkerTime: -time.Hour}}, "repo: limits must not be negative"},
+		{"builds without per", RateLimits{Members: RateLimit{Builds: 5}}, "members: builds need a duration in which they are refilled (per)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.limits.Validate()
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRateLimits_forCommenter(t *testing.T) {
+	limits := RateLimits{
+		User:    RateLimit{Builds: 2, Per: time.Hour, WorkerTime: time.Hour},
+		Members: RateLimit{Builds: 20, Per: time.Hour},
+	}
+	require.Equal(t, limits.User, limits.forCommenter(Commenter{"newbie", "CONTRIBUTOR"}))
+	require.Equal(t, RateLimit{Builds: 20, Per: time.Hour, WorkerTime: time.Hour}, limits.forCommenter(Commenter{"janedoe", "MEMBER"}))
+
+	limits.MemberAssociations = []string{"OWNER"}
+	require.Equal(t, limits.User, limits.forCommenter(Commenter{"janedoe", "MEMBER"}))
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2023, 4, 1, 23, 0, 0, 0, time.UTC)
+	newLimiter := func() *rateLimiter {
+		l := newRateLimiter()
+		l.now = func() time.Time { return now }
+		return l
+	}
+	johndoe := Commenter{"johndoe", "CONTRIBUTOR"}
+	janedoe := Commenter{"janedoe", "MEMBER"}
+
+	t.Run("no limits", func(t *testing.T) {
+		l := newLimiter()
+		for i := 0; i < 100; i++ {
+			require.NoError(t, l.Take("kwk", "buildbot-app", johndoe, RateLimits{}))
+		}
+	})
+	t.Run("user bucket", func(t *testing.T) {
+		l := newLimiter()
+		limits := RateLimits{
+			User:    RateLimit{Builds: 2, Per: time.Hour},
+			Members: RateLimit{Builds: 10, Per: time.Hour},
+		}
+		require.NoError(t, l.Take("kwk", "buildbot-app", johndoe, limits))
+		require.NoError(t, l.Take("kwk", "buildbot-app", johndoe, limits))
+		err := l.Take("kwk", "buildbot-app", johndoe, limits)
+		var rateLimitErr *RateLimitError
+		require.True(t, errors.As(err, &rateLimitErr))
+		require.Equal(t, "you requested more than 2 builds in 1h0m0s", rateLimitErr.Reason)
+		// One token is back after half an hour
+		require.Equal(t, now.Add(30*time.Minute), rateLimitErr.RetryAt)
+
+		// Members have higher limits and other repositories aren't affected
+		require.NoError(t, l.Take("kwk", "buildbot-app", janedoe, limits))
+		require.NoError(t, l.Take("kwk", "other", johndoe, limits))
+
+		now = now.Add(30 * time.Minute)
+		require.NoError(t, l.Take("kwk", "buildbot-app", johndoe, limits))
+		require.Error(t, l.Take("kwk", "buildbot-app", johndoe, limits))
+	})
+	t.Run("repo bucket", func(t *testing.T) {
+		l := newLimiter()
+		limits := RateLimits{
+			User: RateLimit{Builds: 2, Per: time.Hour},
+			Repo: RateLimit{Builds: 1, Per: time.Hour},
+		}
+		require.NoError(t, l.Take("kwk", "buildbot-app", johndoe, limits))
+		err := l.Take("kwk", "buildbot-app", janedoe, limits)
+		require.ErrorContains(t, err, "this repository requested more than 1 builds in 1h0m0s")
+		// A rejected command doesn't take a token from the user's bucket
+		require.Equal(t, 2.0, l.buckets["kwk/buildbot-app@janedoe"].tokens)
+	})
+	t.Run("worker time", func(t *testing.T) {
+		l := newLimiter()
+		limits := RateLimits{User: RateLimit{WorkerTime: time.Hour}}
+		require.NoError(t, l.Take("kwk", "buildbot-app", johndoe, limits))
+		l.AddWorkerTime("kwk", "buildbot-app", "johndoe", buildWorkerTime(1680386400, 1680390000))
+		err := l.Take("kwk", "buildbot-app", johndoe, limits)
+		var rateLimitErr *RateLimitError
+		require.True(t, errors.As(err, &rateLimitErr))
+		require.Equal(t, "you used up the worker time of 1h0m0s for today", rateLimitErr.Reason)
+		require.Equal(t, time.Date(2023, 4, 2, 0, 0, 0, 0, time.UTC), rateLimitErr.RetryAt)
+		require.NoError(t, l.Take("kwk", "buildbot-app", janedoe, limits))
+
+		// The quota starts over the next day
+		now = rateLimitErr.RetryAt
+		require.NoError(t, l.Take("kwk", "buildbot-app", johndoe, limits))
+	})
+}
+
+func TestBuildWorkerTime(t *testing.T) {
+	require.Equal(t, 90*time.Second, buildWorkerTime(1680386400, 1680386490))
+	require.Equal(t, time.Duration(0), buildWorkerTime(0, 1680386490))
+	require.Equal(t, time.Duration(0), buildWorkerTime(1680386490, 1680386400))
+}
+
+func TestChargedWorkerTime(t *testing.T) {
+	status := buildbot_http_status_push.Data{
+		StartedAt:  1680386400,
+		CompleteAt: 1680386490,
+		Builder:    buildbot_http_status_push.Builder{Name: "linux"},
+	}
+	require.Equal(t, 90*time.Second, chargedWorkerTime(status))
+	// The delegating build only waits for the builds it triggered
+	status.Builder.Name = delegationBuilderName
+	require.Equal(t, time.Duration(0), chargedWorkerTime(status))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+)
+
+// tag::reactions[]
+// ReactionsConfig lets the app acknowledge a /buildbot comment with a reaction
+// on the comment that changes as the builds progress. By default, every build
+// gets its own build log comment instead.
+type ReactionsConfig struct {
+	// React on /buildbot comments
+	Enabled bool `yaml:"enabled"`
+	// Write build log comments even though the app reacts on the comments
+	BuildLogComment bool `yaml:"build_log_comment"`
+}
+
+// The reactions that show how far the builds of a /buildbot comment are.
+// GitHub only knows a few reactions (see
+// https://docs.github.com/en/rest/reactions), so a thumbs up and down stand
+// for success and failure.
+const (
+	// The app received the comment
+	ReactionReceived = "eyes"
+	// Buildbot accepted the builds
+	ReactionAccepted = "rocket"
+	// All builds of the comment finished and none of them failed
+	ReactionSucceeded = "+1"
+	// All builds of the comment finished and at least one of them failed
+	ReactionFailed = "-1"
+)
+
+// end::reactions[]
+
+// progressReactions are the reactions of which a comment only shows one at a
+// time.
+var progressReactions = []string{ReactionReceived, ReactionAccepted, ReactionSucceeded, ReactionFailed}
+
+// reactsOn returns true if the progress of the command's builds is shown with
+// reactions on the comment that requested them. Commands that weren't written
+// in a comment (e.g. automatic builds) have nothing to react on.
+func (c RepoConfig) reactsOn(cmd *command.Command) bool {
+	return c.Reactions.Enabled && cmd.CommentID != 0
+}
+
+// writesBuildLogComment returns false if the builds of the command are only
+// acknowledged with reactions.
+func (c RepoConfig) writesBuildLogComment(cmd *command.Command) bool {
+	return !c.reactsOn(cmd) || c.Reactions.BuildLogComment
+}
+
+// setCommentReaction adds the reaction to the comment and removes the other
+// progress reactions that the app added before, so that the comment only
+// shows the current state of its builds.
+func setCommentReaction(gh *github.Client, repoOwner string, repoName string, commentID int64, content string) error {
+	// If the app already reacted with the same content, GitHub returns the
+	// existing reaction. Either way its user is the app's bot user, which
+	// tells us which of the other reactions are ours.
+	reaction, _, err := gh.Reactions.CreateIssueCommentReaction(context.Background(), repoOwner, repoName, commentID, content)
+	if err != nil {
+		return fmt.Errorf("failed to add %s reaction to comment %d: %w", content, commentID, err)
+	}
+	reactions, err := getAllCommentReactions(gh, repoOwner, repoName, commentID)
+	if err != nil {
+		return err
+	}
+	for _, other := range reactions {
+		if other.GetUser().GetID() != reaction.GetUser().GetID() || other.GetContent() == content || !containsString(progressReactions, other.GetContent()) {
+			continue
+		}
+		_, err := gh.Reactions.DeleteIssueCommentReaction(context.Background(), repoOwner, repoName, commentID, other.GetID())
+		if err != nil {
+			return fmt.Errorf("failed to remove %s reaction from comment %d: %w", other.GetContent(), commentID, err)
+		}
+	}
+	return nil
+}
+
+// getAllCommentReactions returns the reactions of all pages of the comment.
+func getAllCommentReactions(gh *github.Client, repoOwner string, repoName string, commentID int64) ([]*github.Reaction, error) {
+	pagesRemaining := true
+	reactions := []*github.Reaction{}
+	listOpts := &github.ListOptions{
+		Page:    1,
+		PerPage: 100,
+	}
+	for pagesRemaining {
+		page, resp, err := gh.Reactions.ListIssueCommentReactions(context.Background(), repoOwner, repoName, commentID, listOpts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list reactions of comment %d: %w", commentID, err)
+		}
+		if resp.NextPage == 0 {
+			pagesRemaining = false
+		} else {
+			listOpts.Page = resp.NextPage
+		}
+		reactions = append(reactions, page...)
+	}
+	return reactions, nil
+}
+
+// reactToFinishedBuilds reacts with ReactionSucceeded or ReactionFailed on the
+// comment once all check runs that it requested for the head SHA are
+// completed. The check run that was just completed may not be listed as such
+// yet, which is why its conclusion is passed in. Optional builds that failed
+// are neutral and don't count as failures.
+func reactToFinishedBuilds(gh *github.Client, appInstallationID int64, repoOwner string, repoName string, headSHA string, commentID int64, checkRunID int64, conclusion CheckRunConclusion) error {
+	checkRuns, err := GetAllCheckRunsForRef(gh, appInstallationID, repoOwner, repoName, headSHA)
+	if err != nil {
+		return fmt.Errorf("failed to get all check runs for %s: %w", headSHA, err)
+	}
+	failed := !conclusionIsSuccessful(conclusion)
+	for _, checkRun := range checkRuns {
+		meta, ok := buildCheckRunMeta(checkRun)
+		if !ok || meta.Command.CommentID != commentID || checkRun.GetID() == checkRunID {
+			continue
+		}
+		if checkRun.GetStatus() != string(CheckRunStateCompleted) {
+			log.Printf("comment %d still has builds in check run %d", commentID, checkRun.GetID())
+			return nil
+		}
+		if !conclusionIsSuccessful(CheckRunConclusion(checkRun.GetConclusion())) {
+			failed = true
+		}
+	}
+	if failed {
+		return setCommentReaction(gh, repoOwner, repoName, commentID, ReactionFailed)
+	}
+	return setCommentReaction(gh, repoOwner, repoName, commentID, ReactionSucceeded)
+}
+
+// conclusionIsSuccessful returns true if the conclusion doesn't block the
+// pull request.
+func conclusionIsSuccessful(conclusion CheckRunConclusion) bool {
+	switch conclusion {
+	case CheckRunConclusionSuccess, CheckRunConclusionNeutral, CheckRunConclusionSkipped:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// recordReactions returns mock options that record the contents of all
+// reactions that are created and the IDs of all reactions that are deleted.
+// Created reactions belong to the user with ID 1 and the comment has the
+// given reactions.
+func recordReactions(t *testing.T, created *[]string, deleted *[]int64, existing ...*github.Reaction) []mock.MockBackendOption {
+	return []mock.MockBackendOption{
+		mock.WithRequestMatchHandler(
+			mock.PostReposIssuesCommentsReactionsByOwnerByRepoByCommentId,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reaction := github.Reaction{}
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&reaction))
+				*created = append(*created, reaction.GetContent())
+				reaction.User = &github.User{ID: github.Int64(1)}
+				w.Write(mock.MustMarshal(reaction))
+			}),
+		),
+		mock.WithRequestMatchHandler(
+			mock.GetReposIssuesCommentsReactionsByOwnerByRepoByCommentId,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write(mock.MustMarshal(existing))
+			}),
+		),
+		mock.WithRequestMatchHandler(
+			mock.DeleteReposIssuesCommentsReactionsByOwnerByRepoByCommentIdByReactionId,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				id, err := strconv.ParseInt(path.Base(r.URL.Path), 10, 64)
+				require.NoError(t, err)
+				*deleted = append(*deleted, id)
+				w.WriteHeader(http.StatusNoContent)
+			}),
+		),
+	}
+}
+
+func reactionOK(id int64, userID int64, content string) *github.Reaction {
+	return &github.Reaction{
+		ID:      github.Int64(id),
+		User:    &github.User{ID: github.Int64(userID)},
+		Content: github.String(content),
+	}
+}
+
+func TestSetCommentReaction(t *testing.T) {
+	created := []string{}
+	deleted := []int64{}
+	srv := NewMockServer(recordReactions(t, &created, &deleted,
+		reactionOK(10, 1, ReactionReceived),
+		reactionOK(11, 2, ReactionReceived),
+		reactionOK(12, 1, ReactionAccepted),
+		reactionOK(13, 1, "heart"),
+	)...)
+	gh, err := srv.NewGithubClient(1234)
+	require.NoError(t, err)
+	err = setCommentReaction(gh, "janedoe", "examplerepo", 99, ReactionAccepted)
+	require.NoError(t, err)
+	require.Equal(t, []string{ReactionAccepted}, created)
+	// Only our previous progress reaction is removed
+	require.Equal(t, []int64{10}, deleted)
+}
+
+func TestReactToFinishedBuilds(t *testing.T) {
+	completed := func(id int64, commentID int64, conclusion CheckRunConclusion) *github.CheckRun {
+		checkRun := checkRunOK(id, "in_progress", "", commentMetaOK(commentID, "foo"))
+		checkRun.Status = github.String(string(CheckRunStateCompleted))
+		checkRun.Conclusion = github.String(string(conclusion))
+		return checkRun
+	}
+	tests := []struct {
+		name         string
+		conclusion   CheckRunConclusion
+		checkRuns    []*github.CheckRun
+		wantReaction []string
+	}{
+		{"other build still running", CheckRunConclusionSuccess, []*github.CheckRun{checkRunOK(2, "in_progress", "", commentMetaOK(99, "bar"))}, []string{}},
+		{"all succeeded", CheckRunConclusionSuccess, []*github.CheckRun{completed(2, 99, CheckRunConclusionNeutral)}, []string{ReactionSucceeded}},
+		{"other build failed", CheckRunConclusionSuccess, []*github.CheckRun{completed(2, 99, CheckRunConclusionFailure)}, []string{ReactionFailed}},
+		{"this build failed", CheckRunConclusionFailure, []*github.CheckRun{completed(2, 99, CheckRunConclusionSuccess)}, []string{ReactionFailed}},
+		{"other comment's build running", CheckRunConclusionSuccess, []*github.CheckRun{checkRunOK(2, "in_progress", "", commentMetaOK(98, "bar"))}, []string{ReactionSucceeded}},
+		// The list may still show the completed check run in progress
+		{"this build listed as running", CheckRunConclusionSuccess, []*github.CheckRun{checkRunOK(1, "in_progress", "", commentMetaOK(99, "foo"))}, []string{ReactionSucceeded}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			created := []string{}
+			deleted := []int64{}
+			options := append(recordReactions(t, &created, &deleted), mock.WithRequestMatch(
+				mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+				github.ListCheckRunsResults{Total: github.Int(len(tt.checkRuns)), CheckRuns: tt.checkRuns},
+			))
+			gh, err := NewMockServer(options...).NewGithubClient(1234)
+			require.NoError(t, err)
+			err = reactToFinishedBuilds(gh, 1234, "janedoe", "examplerepo", "5da7cf6468aabc181b3c7c662539cd3e70526c1b", 99, 1, tt.conclusion)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantReaction, created)
+		})
+	}
+}
+
+func TestOnIssueCommentEventReactions(t *testing.T) {
+	pr := prOK()
+	pr.Number = github.Int(123)
+	pr.Base.Ref = github.String("main")
+	pr.Base.SHA = github.String("1ea3fd4f1b3f2b58a7a2f2c2a25d1ecf0c8ff40b")
+	pr.Head.Ref = github.String("feature")
+	tests := []struct {
+		name            string
+		buildLogComment bool
+		wantComments    int
+	}{
+		{"reactions only", false, 0},
+		{"reactions and build log comment", true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comments := []string{}
+			created := []string{}
+			deleted := []int64{}
+			options := append(recordReactions(t, &created, &deleted),
+				mock.WithRequestMatch(
+					mock.GetReposPullsByOwnerByRepoByPullNumber,
+					pr,
+				),
+				mock.WithRequestMatch(
+					mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+					github.ListCheckRunsResults{Total: github.Int(0)},
+				),
+				mock.WithRequestMatch(
+					mock.PostReposCheckRunsByOwnerByRepo,
+					github.CheckRun{ID: github.Int64(1)},
+				),
+				recordComments(t, &comments),
+			)
+			srv := NewMockServer(options...)
+			srv.repoConfig = &RepoConfig{Reactions: ReactionsConfig{Enabled: true, BuildLogComment: tt.buildLogComment}}
+			event := issueCommentEventOK()
+			event.Comment.ID = github.Int64(99)
+			event.Comment.Body = github.String("/buildbot builder=foo")
+			err := OnIssueCommentEventAny(srv)("1234", "created", event)
+			require.NoError(t, err)
+			require.Equal(t, []string{ReactionReceived, ReactionAccepted}, created)
+			require.Len(t, comments, tt.wantComments)
+			require.Len(t, *srv.tryBotRuns, 1)
+		})
+	}
+}
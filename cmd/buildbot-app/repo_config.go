@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// RepoConfigPath is where we look for the configuration of a repository
+	// on its default branch.
+	RepoConfigPath = ".github/buildbot-app.yaml"
+
+	// defaultDocsURL is what the thank-you comment links to if a repository
+	// doesn't configure its own documentation.
+	defaultDocsURL = "https://github.com/kwk/buildbot-app"
+
+	// repoConfigCacheTTL is how long a repository's configuration is used
+	// before it is read again.
+	repoConfigCacheTTL = 5 * time.Minute
+)
+
+// tag::repo_config[]
+// RepoConfig is the configuration of the app for a single repository. It is
+// read from RepoConfigPath on the repository's default branch. A repository
+// without the file gets the zero value.
+type RepoConfig struct {
+	// Builders that can be used in the repository. Empty means all builders.
+	AllowedBuilders []string `yaml:"allowed_builders"`
+	// Builders that a /buildbot without builders and selectors runs on
+	DefaultBuilders []string `yaml:"default_builders"`
+	// Custom properties that can be passed to the builders with
+	// prop:<name>=<value>. Empty means none.
+	AllowedProperties []string `yaml:"allowed_properties"`
+	// Documentation that the thank-you comment links to
+	DocsURL string `yaml:"docs_url"`
+	// Who may do what (see AuthorizationPolicy)
+	Authorization AuthorizationPolicy `yaml:"authorization"`
+	// Aliases map a word to the subcommand and options that it stands for.
+	// For example, with the alias `full: builder=linux builder=macos` the
+	// command "/buildbot full force=yes" becomes
+	// "/buildbot builder=linux builder=macos force=yes".
+	Aliases map[string]string `yaml:"aliases"`
+	// Presets that commands can refer to with preset=<name> (see
+	// command.Preset)
+	Presets command.Presets `yaml:"presets"`
+	// How many builds commenters and the repository may request (see
+	// RateLimits)
+	RateLimits RateLimits `yaml:"rate_limits"`
+	// Builds that start without a /buildbot comment (see AutoBuildRule)
+	AutoBuilds []AutoBuildRule `yaml:"auto_builds"`
+	// Labels that start a build when they are added to a pull request (see
+	// LabelBuilds)
+	LabelBuilds LabelBuilds `yaml:"label_builds"`
+	// Builds of pushes to branches (see BranchBuildRule)
+	BranchBuilds []BranchBuildRule `yaml:"branch_builds"`
+	// Builds of the merge groups of GitHub's merge queue (see
+	// MergeQueueConfig)
+	MergeQueue MergeQueueConfig `yaml:"merge_queue"`
+	// How builds are reported on GitHub (see Reporting)
+	Reporting Reporting `yaml:"reporting"`
+	// Whether the app reacts on /buildbot comments instead of writing a build
+	// log comment for every build (see ReactionsConfig)
+	Reactions ReactionsConfig `yaml:"reactions"`
+}
+
+// end::repo_config[]
+
+// A RepoConfigError tells that a repository's configuration file is invalid.
+type RepoConfigError struct {
+	Err error
+}
+
+func (e *RepoConfigError) Error() string {
+	return fmt.Sprintf("invalid %s: %v", RepoConfigPath, e.Err)
+}
+
+func (e *RepoConfigError) Unwrap() error {
+	return e.Err
+}
+
+// RepoConfigFromYAML parses and validates a repository configuration. Unknown
+// fields are an error so that typos don't go unnoticed.
+func RepoConfigFromYAML(data []byte) (*RepoConfig, error) {
+	config := &RepoConfig{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse repository configuration: %w", err)
+	}
+	for i, name := range config.AllowedProperties {
+		config.AllowedProperties[i] = strings.ToLower(name)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Validate returns an error if the configuration contradicts itself or refers
+// to unknown subcommands and options.
+func (c RepoConfig) Validate() error {
+	if disallowed := c.DisallowedBuilders(c.DefaultBuilders); len(disallowed) > 0 {
+		return fmt.Errorf("default builders are not allowed: %s", strings.Join(disallowed, ", "))
+	}
+	if err := c.Authorization.Validate(); err != nil {
+		return fmt.Errorf("invalid authorization: %w", err)
+	}
+	if err := c.RateLimits.Validate(); err != nil {
+		return fmt.Errorf("invalid rate limits: %w", err)
+	}
+	if err := c.Reporting.Validate(); err != nil {
+		return fmt.Errorf("invalid reporting: %w", err)
+	}
+	if err := c.Presets.Validate(); err != nil {
+		return fmt.Errorf("invalid presets: %w", err)
+	}
+	presetNames := make([]string, 0, len(c.Presets))
+	for name := range c.Presets {
+		presetNames = append(presetNames, name)
+	}
+	sort.Strings(presetNames)
+	for _, name := range presetNames {
+		if disallowed := c.DisallowedBuilders(c.Presets[name].Builders); len(disallowed) > 0 {
+			return fmt.Errorf("builders of preset %q are not allowed: %s", name, strings.Join(disallowed, ", "))
+		}
+	}
+	for name, expansion := range c.Aliases {
+		if name == "" || strings.ContainsAny(name, " \t=:\"") {
+			return fmt.Errorf("alias %q must be a single word", name)
+		}
+		if command.Subcommand(strings.ToLower(name)).Definition() != nil || command.OptionByName(strings.ToLower(name)) != nil {
+			return fmt.Errorf("alias %q hides a subcommand or option of the same name", name)
+		}
+		if _, err := command.FromStringWithPresets(command.BuildbotCommand+" "+expansion, c.Presets); err != nil {
+			return fmt.Errorf("alias %q: %w", name, err)
+		}
+	}
+	for i, rule := range c.AutoBuilds {
+		if err := rule.validate(c); err != nil {
+			return fmt.Errorf("auto build %d: %w", i+1, err)
+		}
+	}
+	if err := c.LabelBuilds.Validate(c); err != nil {
+		return fmt.Errorf("invalid label builds: %w", err)
+	}
+	for i, rule := range c.BranchBuilds {
+		if err := rule.validate(c); err != nil {
+			return fmt.Errorf("branch build %d: %w", i+1, err)
+		}
+	}
+	if c.MergeQueue.Enabled {
+		if err := c.validateBuildCommand(c.MergeQueue.Command.commandLine()); err != nil {
+			return fmt.Errorf("invalid merge queue: %w", err)
+		}
+	}
+	return nil
+}
+
+// DisallowedBuilders returns the sorted names of the given builders that are
+// not allowed in the repository.
+func (c RepoConfig) DisallowedBuilders(builderNames []string) []string {
+	disallowed := []string{}
+	if len(c.AllowedBuilders) == 0 {
+		return disallowed
+	}
+	for _, name := range builderNames {
+		if !containsString(c.AllowedBuilders, name) {
+			disallowed = append(disallowed, name)
+		}
+	}
+	sort.Strings(disallowed)
+	return disallowed
+}
+
+// FilterInventory returns the builders of the inventory that are allowed in
+// the repository, so that selectors never resolve to other builders.
+func (c RepoConfig) FilterInventory(inventory command.Inventory) command.Inventory {
+	if len(c.AllowedBuilders) == 0 {
+		return inventory
+	}
+	filtered := command.Inventory{}
+	for _, b := range inventory {
+		if containsString(c.AllowedBuilders, b.Name) {
+			filtered = append(filtered, b)
+		}
+	}
+	return filtered
+}
+
+// ExpandAliases replaces every word of the command line that is an alias with
+// what it stands for. Command lines without aliases are returned unchanged.
+func (c RepoConfig) ExpandAliases(line string) string {
+	if len(c.Aliases) == 0 {
+		return line
+	}
+	fields := strings.Fields(line)
+	expanded := false
+	for i := 1; i < len(fields); i++ {
+		for name, expansion := range c.Aliases {
+			if strings.EqualFold(fields[i], name) {
+				fields[i] = expansion
+				expanded = true
+				break
+			}
+		}
+	}
+	if !expanded {
+		return line
+	}
+	return strings.Join(fields, " ")
+}
+
+// docsURL returns the documentation that the thank-you comment links to.
+func (c RepoConfig) docsURL() string {
+	if c.DocsURL == "" {
+		return defaultDocsURL
+	}
+	return c.DocsURL
+}
+
+// loadRepoConfig reads the configuration of the given repository from
+// RepoConfigPath on its default branch. A *RepoConfigError is returned if the
+// file is invalid.
+func loadRepoConfig(gh *github.Client, repoOwner string, repoName string) (*RepoConfig, error) {
+	repo, _, err := gh.Repositories.Get(context.Background(), repoOwner, repoName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repository: %w", err)
+	}
+	file, _, resp, err := gh.Repositories.GetContents(context.Background(), repoOwner, repoName, RepoConfigPath, &github.RepositoryContentGetOptions{
+		Ref: repo.GetDefaultBranch(),
+	})
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return &RepoConfig{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", RepoConfigPath, err)
+	}
+	if file == nil {
+		return nil, &RepoConfigError{Err: fmt.Errorf("not a file")}
+	}
+	content, err := file.GetContent()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", RepoConfigPath, err)
+	}
+	config, err := RepoConfigFromYAML([]byte(content))
+	if err != nil {
+		return nil, &RepoConfigError{Err: err}
+	}
+	return config, nil
+}
+
+// repoConfigCache keeps the configurations of repositories per installation
+// for a while, so that we don't have to read them for every event.
+type repoConfigCache struct {
+	mu  sync.Mutex
+	ttl time.Duration
+	// installation ID -> "owner/name" -> entry
+	entries map[int64]map[string]repoConfigCacheEntry
+}
+
+type repoConfigCacheEntry struct {
+	config *RepoConfig
+	loaded time.Time
+}
+
+func newRepoConfigCache(ttl time.Duration) *repoConfigCache {
+	return &repoConfigCache{
+		ttl:     ttl,
+		entries: map[int64]map[string]repoConfigCacheEntry{},
+	}
+}
+
+// Get returns the cached configuration of the repository if it isn't older
+// than the cache's TTL.
+func (c *repoConfigCache) Get(appInstallationID int64, repoOwner string, repoName string) (*RepoConfig, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[appInstallationID][repoOwner+"/"+repoName]
+	if !ok || time.Since(entry.loaded) > c.ttl {
+		return nil, false
+	}
+	return entry.config, true
+}
+
+// Put caches the configuration of the repository.
+func (c *repoConfigCache) Put(appInstallationID int64, repoOwner string, repoName string, config *RepoConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[appInstallationID]; !ok {
+		c.entries[appInstallationID] = map[string]repoConfigCacheEntry{}
+	}
+	c.entries[appInstallationID][repoOwner+"/"+repoName] = repoConfigCacheEntry{config: config, loaded: time.Now()}
+}
+
+// Forget drops all cached configurations of the installation.
+func (c *repoConfigCache) Forget(appInstallationID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, appInstallationID)
+}
+
+// repoConfigErrorMessage explains that the repository's configuration is
+// invalid.
+func repoConfigErrorMessage(err *RepoConfigError) string {
+	return fmt.Sprintf("Sorry, but I can't handle <code>%s</code> commands in this repository because <code>%s</code> on the default branch is invalid:\n\n```\n%v\n```\n\nPlease fix the file and try again.", command.BuildbotCommand, RepoConfigPath, err.Err)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+	"github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepoConfigFromYAML(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		config, err := RepoConfigFromYAML([]byte(`
+allowed_builders: [linux, macos]
+default_builders: [linux]
+allowed_properties: [TEST_FILTER]
+docs_url: https://example.com/ci
+authorization:
+  deny_users: [spammer]
+  rules:
+    - option: force
+      value: "yes"
+      associations: [MEMBER]
+aliases:
+  full: builder=linux builder=macos
+rate_limits:
+  user:
+    builds: 5
+    per: 1h
+    worker_time: 4h
+reactions:
+  enabled: true
+`))
+		require.NoError(t, err)
+		require.Equal(t, []string{"linux", "macos"}, config.AllowedBuilders)
+		require.Equal(t, []string{"test_filter"}, config.AllowedProperties)
+		require.Equal(t, "https://example.com/ci", config.docsURL())
+		require.Equal(t, []string{"spammer"}, config.Authorization.DenyUsers)
+		require.Equal(t, "force", config.Authorization.Rules[0].Option)
+		require.Nil(t, config.Authorization.ApprovalRequiredFor)
+		require.Equal(t, RateLimit{Builds: 5, Per: time.Hour, WorkerTime: 4 * time.Hour}, config.RateLimits.User)
+		require.Equal(t, ReactionsConfig{Enabled: true}, config.Reactions)
+	})
+	t.Run("empty", func(t *testing.T) {
+		config, err := RepoConfigFromYAML([]byte(""))
+		require.NoError(t, err)
+		require.Equal(t, &RepoConfig{}, config)
+		require.Equal(t, defaultDocsURL, config.docsURL())
+	})
+	t.Run("approvals turned off", func(t *testing.T) {
+		config, err := RepoConfigFromYAML([]byte("authorization:\n  approval_required_for: []\n"))
+		require.NoError(t, err)
+		require.NotNil(t, config.Authorization.ApprovalRequiredFor)
+		require.False(t, config.Authorization.RequiresApproval(Commenter{"newbie", "NONE"}))
+	})
+	t.Run("unknown field", func(t *testing.T) {
+		_, err := RepoConfigFromYAML([]byte("allowed_builder: [linux]\n"))
+		require.ErrorContains(t, err, "field allowed_builder not found")
+	})
+}
+
+func TestRepoConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  RepoConfig
+		wantErr string
+	}{
+		{"empty", RepoConfig{}, ""},
+		{"allowed default builders", RepoConfig{AllowedBuilders: []string{"linux"}, DefaultBuilders: []string{"linux"}}, ""},
+		{"disallowed default builders", RepoConfig{AllowedBuilders: []string{"linux"}, DefaultBuilders: []string{"macos"}}, "default builders are not allowed: macos"},
+		{"invalid authorization", RepoConfig{Authorization: AuthorizationPolicy{Rules: []AuthorizationRule{{Option: "forse"}}}}, `invalid authorization: rule 1: unknown option "forse"`},
+		{"alias", RepoConfig{Aliases: map[string]string{"full": "builder=linux builder=macos"}}, ""},
+		{"alias with spaces", RepoConfig{Aliases: map[string]string{"full build": "builder=linux"}}, `alias "full build" must be a single word`},
+		{"alias hides subcommand", RepoConfig{Aliases: map[string]string{"cancel": "builder=linux"}}, `alias "cancel" hides a subcommand or option of the same name`},
+		{"alias hides option", RepoConfig{Aliases: map[string]string{"Force": "builder=linux"}}, `alias "Force" hides a subcommand or option of the same name`},
+		{"invalid alias", RepoConfig{Aliases: map[string]string{"full": "builder="}}, `alias "full": `},
+		{"alias with preset", RepoConfig{Aliases: map[string]string{"all": "preset=full"}, Presets: command.Presets{"full": {Builders: []string{"linux"}}}}, ""},
+		{"alias with unknown preset", RepoConfig{Aliases: map[string]string{"all": "preset=full"}}, `alias "all": line 1, column 18: unknown preset "full"`},
+		{"commit statuses", RepoConfig{Reporting: ReportingCommitStatuses}, ""},
+		{"invalid reporting", RepoConfig{Reporting: "statuses"}, `invalid reporting: unknown reporting "statuses", use "check_runs" or "commit_statuses"`},
+		{"invalid presets", RepoConfig{Presets: command.Presets{"full": {Include: []string{"full"}}}}, "invalid presets: presets include each other: full -> full"},
+		{"auto build", RepoConfig{DefaultBuilders: []string{"linux"}, AutoBuilds: []AutoBuildRule{{Branches: []string{"release/*"}, Paths: []string{"docs/**"}}}}, ""},
+		{"auto build without builders", RepoConfig{AutoBuilds: []AutoBuildRule{{}}}, "auto build 1: the command has no builders and there are no default builders"},
+		{"auto build with invalid pattern", RepoConfig{AutoBuilds: []AutoBuildRule{{Branches: []string{"release/["}, Command: "builder=linux"}}}, `auto build 1: invalid pattern "release/["`},
+		{"auto build with subcommand", RepoConfig{AutoBuilds: []AutoBuildRule{{Command: "cancel"}}}, "auto build 1: only builds can be started automatically, not cancel"},
+		{"auto build with disallowed builder", RepoConfig{AllowedBuilders: []string{"linux"}, AutoBuilds: []AutoBuildRule{{Command: "builder=macos"}}}, "auto build 1: builders are not allowed: macos"},
+		{"label build", RepoConfig{LabelBuilds: LabelBuilds{"buildbot:full": "builder=linux builder=macos"}}, ""},
+		{"label build with subcommand", RepoConfig{LabelBuilds: LabelBuilds{"buildbot:cancel": "cancel"}}, `invalid label builds: label "buildbot:cancel": only builds can be started automatically, not cancel`},
+		{"label build without builders", RepoConfig{LabelBuilds: LabelBuilds{"buildbot:default": ""}}, `invalid label builds: label "buildbot:default": the command has no builders and there are no default builders`},
+		{"branch builds", RepoConfig{DefaultBuilders: []string{"linux"}, BranchBuilds: []BranchBuildRule{{Branches: []string{"main", "release/*"}}}}, ""},
+		{"branch build without branches", RepoConfig{DefaultBuilders: []string{"linux"}, BranchBuilds: []BranchBuildRule{{Command: "builder=linux"}}}, "branch build 1: the rule has no branches"},
+		{"branch build with invalid pattern", RepoConfig{DefaultBuilders: []string{"linux"}, BranchBuilds: []BranchBuildRule{{Branches: []string{"release/["}}}}, "branch build 1: invalid pattern \"release/[\""},
+		{"merge queue", RepoConfig{DefaultBuilders: []string{"linux"}, MergeQueue: MergeQueueConfig{Enabled: true}}, ""},
+		{"merge queue without builders", RepoConfig{MergeQueue: MergeQueueConfig{Enabled: true}}, "invalid merge queue: the command has no builders and there are no default builders"},
+		{"disabled merge queue", RepoConfig{MergeQueue: MergeQueueConfig{Command: "cancel"}}, ""},
+		{"disallowed preset builders", RepoConfig{AllowedBuilders: []string{"linux"}, Presets: command.Presets{"full": {Builders: []string{"linux", "macos"}}}}, `builders of preset "full" are not allowed: macos`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRepoConfig_Builders(t *testing.T) {
+	inventory := command.Inventory{
+		{Name: "linux", Os: "linux"},
+		{Name: "macos", Os: "macos"},
+		{Name: "windows", Os: "windows"},
+	}
+	require.Empty(t, RepoConfig{}.DisallowedBuilders([]string{"windows"}))
+	require.Equal(t, inventory, RepoConfig{}.FilterInventory(inventory))
+
+	config := RepoConfig{AllowedBuilders: []string{"macos", "linux"}}
+	require.Equal(t, []string{"foo", "windows"}, config.DisallowedBuilders([]string{"windows", "linux", "foo"}))
+	require.Equal(t, command.Inventory{inventory[0], inventory[1]}, config.FilterInventory(inventory))
+}
+
+func TestRepoConfig_ExpandAliases(t *testing.T) {
+	config := RepoConfig{Aliases: map[string]string{"full": "builder=linux builder=macos"}}
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"no alias", "/buildbot builder=linux", "/buildbot builder=linux"},
+		{"alias", "/buildbot full force=yes", "/buildbot builder=linux builder=macos force=yes"},
+		{"case-insensitive", "/buildbot FULL", "/buildbot builder=linux builder=macos"},
+		{"not the command itself", "full", "full"},
+		{"not part of a value", "/buildbot builder=full", "/buildbot builder=full"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, config.ExpandAliases(tt.line))
+		})
+	}
+}
+
+func TestLoadRepoConfig(t *testing.T) {
+	repo := github.Repository{DefaultBranch: github.String("main")}
+	t.Run("ok", func(t *testing.T) {
+		gh := github.NewClient(mock.NewMockedHTTPClient(
+			mock.WithRequestMatch(mock.GetReposByOwnerByRepo, repo),
+			mock.WithRequestMatchHandler(
+				mock.GetReposContentsByOwnerByRepoByPath,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					require.Equal(t, "main", r.URL.Query().Get("ref"))
+					w.Write(mock.MustMarshal(github.RepositoryContent{
+						Type:     github.String("file"),
+						Encoding: github.String("base64"),
+						Content:  github.String(base64.StdEncoding.EncodeToString([]byte("default_builders: [linux]\n"))),
+					}))
+				}),
+			),
+		))
+		config, err := loadRepoConfig(gh, "janedoe", "examplerepo")
+		require.NoError(t, err)
+		require.Equal(t, []string{"linux"}, config.DefaultBuilders)
+	})
+	t.Run("no file", func(t *testing.T) {
+		gh := github.NewClient(mock.NewMockedHTTPClient(
+			mock.WithRequestMatch(mock.GetReposByOwnerByRepo, repo),
+			mock.WithRequestMatchHandler(
+				mock.GetReposContentsByOwnerByRepoByPath,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					mock.WriteError(w, http.StatusNotFound, "Not Found")
+				}),
+			),
+		))
+		config, err := loadRepoConfig(gh, "janedoe", "examplerepo")
+		require.NoError(t, err)
+		require.Equal(t, &RepoConfig{}, config)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		gh := github.NewClient(mock.NewMockedHTTPClient(
+			mock.WithRequestMatch(mock.GetReposByOwnerByRepo, repo),
+			mock.WithRequestMatch(
+				mock.GetReposContentsByOwnerByRepoByPath,
+				github.RepositoryContent{
+					Type:     github.String("file"),
+					Encoding: github.String("base64"),
+					Content:  github.String(base64.StdEncoding.EncodeToString([]byte("default_builders: linux\n"))),
+				},
+			),
+		))
+		_, err := loadRepoConfig(gh, "janedoe", "examplerepo")
+		var configErr *RepoConfigError
+		require.True(t, errors.As(err, &configErr))
+		require.ErrorContains(t, err, "invalid .github/buildbot-app.yaml")
+	})
+}
+
+func TestRepoConfigCache(t *testing.T) {
+	cache := newRepoConfigCache(time.Hour)
+	_, ok := cache.Get(1, "janedoe", "examplerepo")
+	require.False(t, ok)
+
+	config := &RepoConfig{DefaultBuilders: []string{"linux"}}
+	cache.Put(1, "janedoe", "examplerepo", config)
+	got, ok := cache.Get(1, "janedoe", "examplerepo")
+	require.True(t, ok)
+	require.Same(t, config, got)
+	_, ok = cache.Get(2, "janedoe", "examplerepo")
+	require.False(t, ok)
+
+	cache.Forget(1)
+	_, ok = cache.Get(1, "janedoe", "examplerepo")
+	require.False(t, ok)
+
+	expired := newRepoConfigCache(0)
+	expired.Put(1, "janedoe", "examplerepo", config)
+	time.Sleep(time.Millisecond)
+	_, ok = expired.Get(1, "janedoe", "examplerepo")
+	require.False(t, ok)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/buildbot_http_status_push"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+)
+
+// tag::reporting[]
+// Reporting tells how the app reports builds on GitHub.
+type Reporting string
+
+const (
+	// ReportingAuto reports with check runs if the app's installation may
+	// write them and with commit statuses otherwise.
+	ReportingAuto Reporting = ""
+	// ReportingCheckRuns reports every build with a check run that has
+	// buttons to cancel, retry and change the build.
+	ReportingCheckRuns Reporting = "check_runs"
+	// ReportingCommitStatuses reports every builder with a classic commit
+	// status named buildbot/<builder>, for tools that only read those.
+	ReportingCommitStatuses Reporting = "commit_statuses"
+)
+
+// end::reporting[]
+
+// Validate returns an error if the reporting is unknown.
+func (r Reporting) Validate() error {
+	switch r {
+	case ReportingAuto, ReportingCheckRuns, ReportingCommitStatuses:
+		return nil
+	}
+	return fmt.Errorf("unknown reporting %q, use %q or %q", r, ReportingCheckRuns, ReportingCommitStatuses)
+}
+
+// tag::reporter[]
+// A Reporter shows the builds of the app on the commits that they build.
+type Reporter interface {
+	// ExistingBuilds returns the check runs of earlier builds for the head
+	// SHA of the target. Reporters that can't tell which command started a
+	// build return none, so nothing is skipped as a duplicate.
+	ExistingBuilds(gh *github.Client, appInstallationID int64, target *BuildTarget) ([]*github.CheckRun, error)
+
+	// BuildQueued reports the build of the request before it is forwarded
+	// to Buildbot. It returns the ID of the build's check run or 0 if the
+	// build has none.
+	BuildQueued(r BuildRequest, buildLogCommentID int64) (int64, error)
+
+	// BuildUpdated reports the state of a build that Buildbot pushed to the
+	// app.
+	BuildUpdated(ctx context.Context, gh *github.Client, u BuildUpdate) (BuildReport, error)
+
+	// TracksBuilds returns true if the reporter remembers the command of
+	// every build on GitHub. Finding earlier builds relies on it: status,
+	// cancel, retry, approvals, the duplicate check, timeouts and cancelling
+	// superseded builds.
+	TracksBuilds() bool
+}
+
+// end::reporter[]
+
+// BuildUpdate is what we know about a build when Buildbot pushes its state.
+type BuildUpdate struct {
+	Target     *BuildTarget
+	Properties *BuildProperties
+	Command    *command.Command
+	Status     *buildbot_http_status_push.Data
+}
+
+// BuildReport is what a Reporter learned about a build when it reported its
+// state.
+type BuildReport struct {
+	// How the build concluded as shown on GitHub
+	Conclusion CheckRunConclusion
+	// The comment that requested the build or 0 if unknown
+	CommentID int64
+}
+
+// untrackedBuildsNote is added to the build log comment of builds whose
+// Reporter doesn't track builds.
+const untrackedBuildsNote = "<sub>Builds in this repository are reported with commit statuses, so this build isn't checked for duplicates, isn't cancelled by new commits and can't be cancelled, retried or timed out.</sub>"
+
+// untrackedBuildsMessage explains why a subcommand can't be used when the
+// Reporter doesn't track builds.
+func untrackedBuildsMessage(subcommand command.Subcommand) string {
+	return fmt.Sprintf("Sorry, but <code>%s %s</code> needs check runs to find the builds and the builds of this repository are reported with commit statuses.", command.BuildbotCommand, subcommand)
+}
+
+// newReporter returns the Reporter that the repository configuration asks
+// for. Without a configured reporting, the permissions of the installation
+// decide.
+func newReporter(srv Server, appInstallationID int64, config *RepoConfig) (Reporter, error) {
+	reporting := config.Reporting
+	if reporting == ReportingAuto {
+		permissions, err := srv.GetInstallationPermissions(appInstallationID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get installation permissions: %w", err)
+		}
+		reporting = reportingFor(permissions)
+	}
+	if reporting == ReportingCommitStatuses {
+		return commitStatusReporter{}, nil
+	}
+	return checkRunReporter{}, nil
+}
+
+// reportingFor returns ReportingCheckRuns unless the installation may only
+// write commit statuses.
+func reportingFor(permissions *github.InstallationPermissions) Reporting {
+	if permissions.GetChecks() != "write" && permissions.GetStatuses() == "write" {
+		return ReportingCommitStatuses
+	}
+	return ReportingCheckRuns
+}
+
+// installationPermissionsCacheTTL is how long the permissions of an
+// installation are used before they are asked for again. Changed permissions
+// take effect after this time.
+const installationPermissionsCacheTTL = 5 * time.Minute
+
+// installationPermissionsCache keeps the permissions of installations for a
+// while, so that we don't have to get a new installation token for every
+// build.
+type installationPermissionsCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[int64]installationPermissionsCacheEntry
+}
+
+type installationPermissionsCacheEntry struct {
+	permissions *github.InstallationPermissions
+	loaded      time.Time
+}
+
+func newInstallationPermissionsCache(ttl time.Duration) *installationPermissionsCache {
+	return &installationPermissionsCache{
+		ttl:     ttl,
+		entries: map[int64]installationPermissionsCacheEntry{},
+	}
+}
+
+// Get returns the cached permissions of the installation if they aren't older
+// than the cache's TTL.
+func (c *installationPermissionsCache) Get(appInstallationID int64) (*github.InstallationPermissions, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[appInstallationID]
+	if !ok || time.Since(entry.loaded) > c.ttl {
+		return nil, false
+	}
+	return entry.permissions, true
+}
+
+// Put caches the permissions of the installation.
+func (c *installationPermissionsCache) Put(appInstallationID int64, permissions *github.InstallationPermissions) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[appInstallationID] = installationPermissionsCacheEntry{permissions: permissions, loaded: time.Now()}
+}
+
+// Forget drops the cached permissions of the installation.
+func (c *installationPermissionsCache) Forget(appInstallationID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, appInstallationID)
+}
+
+// reporterOfBuild returns the Reporter that reported the queued build. Only
+// builds that were reported with check runs have a check run ID, so a
+// changed configuration doesn't mix up the reporters of running builds.
+func reporterOfBuild(buildProperties *BuildProperties) Reporter {
+	if buildProperties.CheckRunID == 0 {
+		return commitStatusReporter{}
+	}
+	return checkRunReporter{}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/buildbot_http_status_push"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+	"github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewReporter(t *testing.T) {
+	tests := []struct {
+		name        string
+		reporting   Reporting
+		permissions *github.InstallationPermissions
+		want        Reporter
+	}{
+		{"checks permission", ReportingAuto, &github.InstallationPermissions{Checks: github.String("write"), Statuses: github.String("write")}, checkRunReporter{}},
+		{"statuses permission only", ReportingAuto, &github.InstallationPermissions{Checks: github.String("read"), Statuses: github.String("write")}, commitStatusReporter{}},
+		{"no permission", ReportingAuto, &github.InstallationPermissions{}, checkRunReporter{}},
+		{"configured commit statuses", ReportingCommitStatuses, &github.InstallationPermissions{Checks: github.String("write")}, commitStatusReporter{}},
+		{"configured check runs", ReportingCheckRuns, &github.InstallationPermissions{Statuses: github.String("write")}, checkRunReporter{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := NewMockServer()
+			srv.permissions = tt.permissions
+			got, err := newReporter(srv, 1234, &RepoConfig{Reporting: tt.reporting})
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestInstallationPermissionsCache(t *testing.T) {
+	cache := newInstallationPermissionsCache(time.Hour)
+	_, ok := cache.Get(1)
+	require.False(t, ok)
+
+	permissions := &github.InstallationPermissions{Checks: github.String("write")}
+	cache.Put(1, permissions)
+	got, ok := cache.Get(1)
+	require.True(t, ok)
+	require.Same(t, permissions, got)
+	_, ok = cache.Get(2)
+	require.False(t, ok)
+
+	cache.Forget(1)
+	_, ok = cache.Get(1)
+	require.False(t, ok)
+
+	expired := newInstallationPermissionsCache(0)
+	expired.Put(1, permissions)
+	time.Sleep(time.Millisecond)
+	_, ok = expired.Get(1)
+	require.False(t, ok)
+}
+
+// recordCommitStatuses returns a mock option that records all commit
+// statuses that are created.
+func recordCommitStatuses(t *testing.T, statuses *[]github.RepoStatus) mock.MockBackendOption {
+	return mock.WithRequestMatchHandler(
+		mock.PostReposStatusesByOwnerByRepoBySha,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.True(t, strings.HasSuffix(r.URL.Path, "/5da7cf6468aabc181b3c7c662539cd3e70526c1b"), r.URL.Path)
+			status := github.RepoStatus{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&status))
+			*statuses = append(*statuses, status)
+			w.Write(mock.MustMarshal(status))
+		}),
+	)
+}
+
+func TestCommitStatusReporter_BuildUpdated(t *testing.T) {
+	tests := []struct {
+		name        string
+		mandatory   bool
+		complete    bool
+		results     int
+		stateString string
+		wantState   string
+		wantDesc    string
+	}{
+		{"running", true, false, 0, "building", "pending", "building"},
+		{"succeeded", true, true, 0, "build successful", "success", "build successful"},
+		{"failed", true, true, 2, "failed compile", "failure", "failed compile"},
+		{"failed but not mandatory", false, true, 2, "failed compile", "success", "failed compile"},
+		{"cancelled", true, true, 6, "cancelled", "error", "cancelled"},
+		{"long state string", true, false, 0, strings.Repeat("a", 200), "pending", strings.Repeat("a", 139) + "…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statuses := []github.RepoStatus{}
+			gh, err := NewMockServer(recordCommitStatuses(t, &statuses)).NewGithubClient(1234)
+			require.NoError(t, err)
+			cmd := command.New()
+			cmd.IsMandatory = tt.mandatory
+			status := &buildbot_http_status_push.Data{
+				Complete:    tt.complete,
+				Results:     tt.results,
+				StateString: tt.stateString,
+				URL:         "http://buildbot/#/builders/1/builds/2",
+				Builder:     buildbot_http_status_push.Builder{Name: "linux"},
+			}
+			_, err = commitStatusReporter{}.BuildUpdated(context.Background(), gh, BuildUpdate{
+				Target:     &BuildTarget{Number: 123, BaseRepoOwner: "janedoe", BaseRepoName: "examplerepo", HeadSHA: "5da7cf6468aabc181b3c7c662539cd3e70526c1b"},
+				Properties: &BuildProperties{},
+				Command:    cmd,
+				Status:     status,
+			})
+			require.NoError(t, err)
+			require.Len(t, statuses, 1)
+			require.Equal(t, "buildbot/linux", statuses[0].GetContext())
+			require.Equal(t, tt.wantState, statuses[0].GetState())
+			require.Equal(t, tt.wantDesc, statuses[0].GetDescription())
+			require.Equal(t, "http://buildbot/#/builders/1/builds/2", statuses[0].GetTargetURL())
+		})
+	}
+}
+
+func TestOnIssueCommentEventCommitStatuses(t *testing.T) {
+	pr := prOK()
+	pr.Base.Ref = github.String("main")
+	pr.Base.SHA = github.String("1ea3fd4f1b3f2b58a7a2f2c2a25d1ecf0c8ff40b")
+	pr.Head.Ref = github.String("feature")
+	statuses := []github.RepoStatus{}
+	comments := []string{}
+	srv := NewMockServer(
+		mock.WithRequestMatch(
+			mock.GetReposPullsByOwnerByRepoByPullNumber,
+			pr,
+		),
+		recordComments(t, &comments),
+		recordCommitStatuses(t, &statuses),
+	)
+	// The installation didn't grant the app to write check runs, which is
+	// why no check runs are listed or created.
+	srv.permissions = &github.InstallationPermissions{Statuses: github.String("write")}
+	event := issueCommentEventOK()
+	event.Comment.Body = github.String("/buildbot builder=linux builder=macos timeout=1h")
+	err := OnIssueCommentEventAny(srv)("1234", "created", event)
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	for i, builderName := range []string{"linux", "macos"} {
+		require.Equal(t, "buildbot/"+builderName, statuses[i].GetContext())
+		require.Equal(t, "pending", statuses[i].GetState())
+	}
+	require.Len(t, *srv.tryBotRuns, 1)
+	require.Contains(t, (*srv.tryBotRuns)[0], "--property=github_check_run_id=0")
+	// The build log comment tells what commit statuses can't do
+	require.Len(t, comments, 1)
+	require.Contains(t, comments[0], untrackedBuildsNote)
+	// Without a check run, timeouts can't be watched
+	require.Empty(t, srv.buildTimeouts)
+
+	// No check runs are listed or created for the other commands either
+	for _, tt := range []struct {
+		name              string
+		body              string
+		authorAssociation string
+		repoConfig        *RepoConfig
+		wantComment       string
+	}{
+		{"status", "/buildbot status", "MEMBER", nil, untrackedBuildsMessage(command.SubcommandStatus)},
+		{"cancel", "/buildbot cancel", "MEMBER", nil, untrackedBuildsMessage(command.SubcommandCancel)},
+		{"denied", "/buildbot builder=linux", "CONTRIBUTOR", &RepoConfig{Authorization: AuthorizationPolicy{Rules: []AuthorizationRule{
+			{Option: command.CommandOptionBuilder, Value: "linux", Associations: []string{"OWNER"}},
+		}}}, "may only be used by OWNER"},
+		{"needs approval", "/buildbot builder=linux", "FIRST_TIME_CONTRIBUTOR", nil, "approvals need check runs"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			statuses := []github.RepoStatus{}
+			comments := []string{}
+			srv := NewMockServer(
+				mock.WithRequestMatch(
+					mock.GetReposPullsByOwnerByRepoByPullNumber,
+					pr,
+				),
+				recordComments(t, &comments),
+				recordCommitStatuses(t, &statuses),
+			)
+			srv.permissions = &github.InstallationPermissions{Statuses: github.String("write")}
+			if tt.repoConfig != nil {
+				srv.repoConfig = tt.repoConfig
+			}
+			event := issueCommentEventOK()
+			event.Comment.Body = github.String(tt.body)
+			event.Comment.AuthorAssociation = github.String(tt.authorAssociation)
+			err := OnIssueCommentEventAny(srv)("1234", "created", event)
+			require.NoError(t, err)
+			require.Empty(t, *srv.tryBotRuns)
+			require.Empty(t, statuses)
+			require.Len(t, comments, 1)
+			require.Contains(t, comments[0], tt.wantComment)
+		})
+	}
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"time"
+
 	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
 )
 
 // tag::server[]
@@ -15,6 +18,41 @@ type Server interface {
 
 	// RunTryBot runs a "buildbot try" command
 	RunTryBot(responsibleGithubLogin string, githubRepoOwner string, githubRepoName string, properties ...string) (string, error)
+
+	// GetBuilderInventory returns the builders and their attributes against
+	// which attribute selectors (e.g. os=linux) in a command are resolved.
+	GetBuilderInventory() (command.Inventory, error)
+
+	// CancelBuilds stops all Buildbot builds that run for the given check run.
+	CancelBuilds(checkRunID int64, reason string) error
+
+	// GetInstallationPermissions returns the permissions that the given
+	// installation granted the app.
+	GetInstallationPermissions(appInstallationID int64) (*github.InstallationPermissions, error)
+
+	// GetRepoConfig returns the configuration of the given repository. A
+	// *RepoConfigError is returned if the repository's configuration file is
+	// invalid.
+	GetRepoConfig(appInstallationID int64, repoOwner string, repoName string) (*RepoConfig, error)
+
+	// WatchBuildTimeout cancels the builds of the given check run and
+	// completes it as timed out unless Buildbot reports a completed build for
+	// it within the given duration.
+	WatchBuildTimeout(appInstallationID int64, repoOwner string, repoName string, checkRunID int64, timeout time.Duration)
+
+	// TakeBuildToken counts a build or retry command of the commenter against
+	// the rate limits of the repository. A *RateLimitError is returned if a
+	// limit is exceeded.
+	TakeBuildToken(repoOwner string, repoName string, commenter Commenter, limits RateLimits) error
+
+	// TrackCheckRun remembers a check run that was created for a build of the
+	// given pull request until its builds are complete.
+	TrackCheckRun(repoOwner string, repoName string, prNumber int, run inFlightCheckRun)
+
+	// TakeSupersededCheckRuns forgets about and returns the tracked check
+	// runs of the pull request that were created for another head SHA than
+	// the given one. An empty head SHA returns all of them.
+	TakeSupersededCheckRuns(repoOwner string, repoName string, prNumber int, headSHA string) []inFlightCheckRun
 }
 
 // end::server[]
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+)
+
+// cancelBuilds stops the in-flight builds of a pull request that match the
+// given cancel command and completes their check runs as cancelled. A comment
+// tells the user which check runs were cancelled.
+func cancelBuilds(srv Server, gh *github.Client, appInstallationID int64, pr *github.PullRequest, cmd *command.Command, thankYouComment string) error {
+	checkRuns, err := GetAllCheckRunsForPullRequest(gh, appInstallationID, pr)
+	if err != nil {
+		return fmt.Errorf("failed to get all check runs for pull request: %w", err)
+	}
+
+	reason := fmt.Sprintf("Cancelled by @%s", cmd.CommentAuthor)
+	cancelled := []string{}
+	for _, checkRun := range checkRuns {
+		if checkRun.GetStatus() == string(CheckRunStateCompleted) {
+			continue
+		}
+		meta, err := CheckRunMetaFromCheckRun(checkRun)
+		if err != nil {
+			log.Printf("skipping check run %d: %v", checkRun.GetID(), err)
+			continue
+		}
+		if !builderNamesOverlap(cmd.BuilderNames, meta.Command.BuilderNames) {
+			continue
+		}
+		if err := CancelCheckRun(srv, gh, pr.GetBase().GetRepo().GetOwner().GetLogin(), pr.GetBase().GetRepo().GetName(), checkRun, reason); err != nil {
+			return err
+		}
+		cancelled = append(cancelled, fmt.Sprintf("* [%s](%s)", checkRun.GetName(), checkRun.GetHTMLURL()))
+	}
+
+	msg := "There are no in-flight builds to cancel."
+	if len(cancelled) > 0 {
+		msg = "These check runs were cancelled:\n\n" + strings.Join(cancelled, "\n")
+	}
+	_, _, err = gh.Issues.CreateComment(context.Background(), pr.GetBase().GetRepo().GetOwner().GetLogin(), pr.GetBase().GetRepo().GetName(), pr.GetNumber(), &github.IssueComment{
+		Body: github.String(thankYouComment + msg),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write cancel comment: %w", err)
+	}
+	return nil
+}
+
+// CancelCheckRun stops all Buildbot builds of the given check run and completes
+// the check run as cancelled. The reason is appended to the check run's
+// summary.
+func CancelCheckRun(srv Server, gh *github.Client, repoOwner string, repoName string, checkRun *github.CheckRun, reason string) error {
+	return StopCheckRun(srv, gh, repoOwner, repoName, checkRun, CheckRunConclusionCancelled, reason)
+}
+
+// StopCheckRun stops all Buildbot builds of the given check run and completes
+// the check run with the given conclusion (e.g. cancelled or timed out). The
+// reason is appended to the check run's summary.
+func StopCheckRun(srv Server, gh *github.Client, repoOwner string, repoName string, checkRun *github.CheckRun, conclusion CheckRunConclusion, reason string) error {
+	if err := srv.CancelBuilds(checkRun.GetID(), reason); err != nil {
+		return fmt.Errorf("failed to cancel builds of check run %d: %w", checkRun.GetID(), err)
+	}
+	isMandatory := true
+	if meta, err := CheckRunMetaFromCheckRun(checkRun); err == nil {
+		isMandatory = meta.Command.IsMandatory
+	}
+	summary := WrapMsgWithTimePrefix(reason, time.Now())
+	if checkRun.GetOutput().GetSummary() != "" {
+		summary = strings.Join([]string{checkRun.GetOutput().GetSummary(), summary}, "\n")
+	}
+	_, _, err := gh.Checks.UpdateCheckRun(context.Background(), repoOwner, repoName, checkRun.GetID(), github.UpdateCheckRunOptions{
+		Name:       checkRun.GetName(),
+		Status:     github.String(string(CheckRunStateCompleted)),
+		Conclusion: github.String(string(conclusion)),
+		Output: &github.CheckRunOutput{
+			Title:   github.String(CheckRunTitle(isMandatory)),
+			Summary: github.String(summary),
+		},
+		Actions: CheckRunActions(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete check run %d as %s: %w", checkRun.GetID(), conclusion, err)
+	}
+	return nil
+}
+
+// builderNamesOverlap returns true if the filter is empty or if at least one
+// of the filter's builder names is also in the list of builder names.
+func builderNamesOverlap(filter []string, builderNames []string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, f := range filter {
+		for _, name := range builderNames {
+			if f == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/kwk/buildbot-app/cmd/buildbot-app/command"
+)
+
+// retryBuilds starts the builds of all check runs of the pull request's head
+// SHA again that ended as failure, cancelled or timed out and that match the
+// given retry command. A retry builds the command of the check run, so check
+// runs whose command the commenter may not run under the policy are skipped.
+func retryBuilds(srv Server, gh *github.Client, appInstallationID int64, pr *github.PullRequest, cmd *command.Command, policy AuthorizationPolicy, commenter Commenter, thankYouComment string) error {
+	checkRuns, err := GetAllCheckRunsForPullRequest(gh, appInstallationID, pr)
+	if err != nil {
+		return fmt.Errorf("failed to get all check runs for pull request: %w", err)
+	}
+
+	retried := map[string]bool{}
+	denied := []string{}
+	for _, checkRun := range checkRuns {
+		if !CheckRunNeedsRetry(checkRun) {
+			continue
+		}
+		meta, err := CheckRunMetaFromCheckRun(checkRun)
+		if err != nil {
+			log.Printf("skipping check run %d: %v", checkRun.GetID(), err)
+			continue
+		}
+		if !builderNamesOverlap(cmd.BuilderNames, meta.Command.BuilderNames) {
+			continue
+		}
+		retryCmd := *meta.Command
+		retryCmd.CommentAuthor = cmd.CommentAuthor
+		retryCmd.CommentID = cmd.CommentID
+		retryCmd.Force = true
+		if retried[retryCmd.ToGithubCheckNameString()] {
+			continue
+		}
+		retried[retryCmd.ToGithubCheckNameString()] = true
+		// Retries are forced so that they aren't skipped as duplicates of the
+		// builds that they retry, which is why the commenter is authorized
+		// for the command as it was requested.
+		requestedCmd := retryCmd
+		requestedCmd.Force = meta.Command.Force
+		reasons, err := policy.Authorize(&requestedCmd, commenter, githubTeamMembership(gh))
+		if err != nil {
+			return fmt.Errorf("failed to authorize retry of check run %d: %w", checkRun.GetID(), err)
+		}
+		if len(reasons) > 0 {
+			log.Printf("denied retry of check run %d by %s: %s", checkRun.GetID(), commenter.Login, strings.Join(reasons, "; "))
+			denied = append(denied, fmt.Sprintf("* <code>%s</code>: %s", checkRun.GetName(), strings.Join(reasons, "; ")))
+			continue
+		}
+		err = BuildRequest{
+			Server:            srv,
+			Github:            gh,
+			AppInstallationID: appInstallationID,
+			Target:            NewBuildTarget(pr),
+			Command:           &retryCmd,
+			ThankYouComment:   thankYouComment,
+		}.Run()
+		if err != nil {
+			return fmt.Errorf("failed to retry check run %d: %w", checkRun.GetID(), err)
+		}
+	}
+
+	msg := ""
+	if len(denied) > 0 {
+		msg = fmt.Sprintf("Sorry @%s, but you are not allowed to retry these builds:\n\n%s", commenter.Login, strings.Join(denied, "\n"))
+	} else if len(retried) == 0 {
+		msg = "There are no failed, cancelled or timed out builds to retry."
+	}
+	if msg != "" {
+		_, _, err = gh.Issues.CreateComment(context.Background(), pr.GetBase().GetRepo().GetOwner().GetLogin(), pr.GetBase().GetRepo().GetName(), pr.GetNumber(), &github.IssueComment{
+			Body: github.String(thankYouComment + msg),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write retry comment: %w", err)
+		}
+	}
+	return nil
+}
+
+// CheckRunNeedsRetry returns true if the check run is completed with a
+// conclusion of failure, cancelled or timed out.
+func CheckRunNeedsRetry(checkRun *github.CheckRun) bool {
+	if checkRun.GetStatus() != string(CheckRunStateCompleted) {
+		return false
+	}
+	switch CheckRunConclusion(checkRun.GetConclusion()) {
+	case CheckRunConclusionFailure, CheckRunConclusionCancelled, CheckRunConclusionTimedOut:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v50/github"
+)
+
+// replyWithStatus writes a comment with a table of all check runs that this
+// app has created for the pull request's head SHA.
+func replyWithStatus(gh *github.Client, appInstallationID int64, pr *github.PullRequest, thankYouComment string) error {
+	checkRuns, err := GetAllCheckRunsForPullRequest(gh, appInstallationID, pr)
+	if err != nil {
+		return fmt.Errorf("failed to get all check runs for pull request: %w", err)
+	}
+	_, _, err = gh.Issues.CreateComment(context.Background(), pr.GetBase().GetRepo().GetOwner().GetLogin(), pr.GetBase().GetRepo().GetName(), pr.GetNumber(), &github.IssueComment{
+		Body: github.String(thankYouComment + checkRunStatusTable(pr.GetHead().GetSHA(), checkRuns)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write status comment: %w", err)
+	}
+	return nil
+}
+
+// checkRunStatusTable returns a markdown table that lists the given check runs
+// with their status and conclusion.
+func checkRunStatusTable(headSHA string, checkRuns []*github.CheckRun) string {
+	if len(checkRuns) == 0 {
+		return fmt.Sprintf("There are no check runs for this pull request's SHA (%s) yet.", headSHA)
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "These are the check runs for this pull request's SHA (%s):\n\n", headSHA)
+	sb.WriteString("| Check run | Status | Conclusion |\n")
+	sb.WriteString("|-----------|--------|------------|\n")
+	for _, checkRun := range checkRuns {
+		conclusion := checkRun.GetConclusion()
+		if conclusion == "" {
+			conclusion = "-"
+		}
+		fmt.Fprintf(&sb, "| [%s](%s) | %s | %s |\n", checkRun.GetName(), checkRun.GetHTMLURL(), checkRun.GetStatus(), conclusion)
+	}
+	return sb.String()
+}
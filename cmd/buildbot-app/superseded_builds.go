@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/cbrgm/githubevents/githubevents"
+	"github.com/google/go-github/v50/github"
+)
+
+// inFlightCheckRun is a check run that was created for a build of a pull
+// request and whose builds may still be running.
+type inFlightCheckRun struct {
+	CheckRunID        int64
+	Name              string
+	HeadSHA           string
+	BuildLogCommentID int64
+}
+
+// pullRequestBuilds remembers the check runs of every pull request until
+// they are complete or superseded.
+type pullRequestBuilds struct {
+	mu sync.Mutex
+	// "owner/name#number" -> check runs
+	runs map[string][]inFlightCheckRun
+}
+
+func newPullRequestBuilds() *pullRequestBuilds {
+	return &pullRequestBuilds{
+		runs: map[string][]inFlightCheckRun{},
+	}
+}
+
+func pullRequestKey(repoOwner string, repoName string, prNumber int) string {
+	return fmt.Sprintf("%s/%s#%d", repoOwner, repoName, prNumber)
+}
+
+// Add remembers a check run of the pull request.
+func (b *pullRequestBuilds) Add(repoOwner string, repoName string, prNumber int, run inFlightCheckRun) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := pullRequestKey(repoOwner, repoName, prNumber)
+	b.runs[key] = append(b.runs[key], run)
+}
+
+// Remove forgets about a check run of the pull request, usually because its
+// builds are complete.
+func (b *pullRequestBuilds) Remove(repoOwner string, repoName string, prNumber int, checkRunID int64) {
+	b.TakeIf(repoOwner, repoName, prNumber, func(run inFlightCheckRun) bool {
+		return run.CheckRunID == checkRunID
+	})
+}
+
+// TakeIf forgets about and returns the check runs of the pull request for
+// which the given function returns true.
+func (b *pullRequestBuilds) TakeIf(repoOwner string, repoName string, prNumber int, f func(run inFlightCheckRun) bool) []inFlightCheckRun {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := pullRequestKey(repoOwner, repoName, prNumber)
+	taken := []inFlightCheckRun{}
+	kept := []inFlightCheckRun{}
+	for _, run := range b.runs[key] {
+		if f(run) {
+			taken = append(taken, run)
+		} else {
+			kept = append(kept, run)
+		}
+	}
+	if len(kept) == 0 {
+		delete(b.runs, key)
+	} else {
+		b.runs[key] = kept
+	}
+	return taken
+}
+
+// OnPullRequestEventSynchronize starts the automatic builds for the new head
+// SHA of a pull request before it cancels the builds of the old head SHAs, so
+// that the cancelled check runs can link to the ones that supersede them.
+func OnPullRequestEventSynchronize(srv Server) githubevents.PullRequestEventHandleFunc {
+	autoBuild := OnPullRequestEventAutoBuild(srv)
+	cancelBuilds := OnPullRequestEventCancelBuilds(srv)
+	return func(deliveryID string, eventName string, event *github.PullRequestEvent) error {
+		autoBuildErr := autoBuild(deliveryID, eventName, event)
+		if err := cancelBuilds(deliveryID, eventName, event); err != nil {
+			return err
+		}
+		return autoBuildErr
+	}
+}
+
+// OnPullRequestEventCancelBuilds cancels the in-flight builds of a pull
+// request that got new commits, was closed or was converted to a draft. After
+// new commits only the builds of the old head SHAs are cancelled.
+func OnPullRequestEventCancelBuilds(srv Server) githubevents.PullRequestEventHandleFunc {
+	return func(deliveryID string, eventName string, event *github.PullRequestEvent) error {
+		if event == nil || event.PullRequest == nil {
+			return nil
+		}
+		pr := event.GetPullRequest()
+		headSHA := pr.GetHead().GetSHA()
+		var why string
+		switch event.GetAction() {
+		case "synchronize":
+			why = fmt.Sprintf("new commits were pushed to the pull request (%s)", headSHA)
+		case "closed":
+			why = "the pull request was closed"
+			headSHA = ""
+		case "converted_to_draft":
+			why = "the pull request was converted to a draft"
+			headSHA = ""
+		default:
+			return nil
+		}
+		repoOwner := event.GetRepo().GetOwner().GetLogin()
+		repoName := event.GetRepo().GetName()
+		runs := srv.TakeSupersededCheckRuns(repoOwner, repoName, pr.GetNumber(), headSHA)
+		if len(runs) == 0 {
+			return nil
+		}
+
+		appInstallationID := event.GetInstallation().GetID()
+		gh, err := srv.NewGithubClient(appInstallationID)
+		if err != nil {
+			return fmt.Errorf("error creating github client: %w", err)
+		}
+		// The check runs of the new head SHA by name
+		newerCheckRuns := map[string]*github.CheckRun{}
+		if headSHA != "" {
+			checkRuns, err := GetAllCheckRunsForPullRequest(gh, appInstallationID, pr)
+			if err != nil {
+				return fmt.Errorf("failed to get all check runs for pull request: %w", err)
+			}
+			for _, checkRun := range checkRuns {
+				newerCheckRuns[checkRun.GetName()] = checkRun
+			}
+		}
+
+		errs := []string{}
+		for _, run := range runs {
+			reason := "Cancelled because " + why + "."
+			if newer, ok := newerCheckRuns[run.Name]; ok {
+				reason = fmt.Sprintf(`Superseded by <a href="%s">this check run</a> for the new head SHA (%s).`, newer.GetHTMLURL(), headSHA)
+			}
+			if err := cancelSupersededCheckRun(srv, gh, repoOwner, repoName, run, reason); err != nil {
+				log.Printf("failed to cancel superseded check run %d: %v", run.CheckRunID, err)
+				errs = append(errs, err.Error())
+			}
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("failed to cancel %d of %d superseded check run(s): %s", len(errs), len(runs), strings.Join(errs, "; "))
+		}
+		return nil
+	}
+}
+
+// cancelSupersededCheckRun stops the builds of the check run, completes it as
+// cancelled and adds the reason to its build log comment. Check runs that
+// completed in the meantime are left alone.
+func cancelSupersededCheckRun(srv Server, gh *github.Client, repoOwner string, repoName string, run inFlightCheckRun, reason string) error {
+	checkRun, _, err := gh.Checks.GetCheckRun(context.Background(), repoOwner, repoName, run.CheckRunID)
+	if err != nil {
+		return fmt.Errorf("error getting check run %d: %w", run.CheckRunID, err)
+	}
+	if checkRun.GetStatus() == string(CheckRunStateCompleted) {
+		return nil
+	}
+	log.Printf("cancelling superseded check run %d: %s", run.CheckRunID, reason)
+	if err := CancelCheckRun(srv, gh, repoOwner, repoName, checkRun, reason); err != nil {
+		return err
+	}
+	if run.BuildLogCommentID == 0 {
+		return nil
+	}
+	return appendToBuildLogComment(gh, repoOwner, repoName, run.BuildLogCommentID, run.Name, reason)
+}
+
+// cancelPullRequestCheckRuns cancels the in-flight check runs of the pull
+// request's head SHA for whose meta data the given function returns true (see
+// cancelCheckRunsOfRef).
+func cancelPullRequestCheckRuns(srv Server, gh *github.Client, appInstallationID int64, pr *github.PullRequest, reason string, f func(meta *CheckRunMeta) bool) ([]*CheckRunMeta, error) {
+	repoOwner := pr.GetBase().GetRepo().GetOwner().GetLogin()
+	repoName := pr.GetBase().GetRepo().GetName()
+	return cancelCheckRunsOfRef(srv, gh, appInstallationID, repoOwner, repoName, pr.GetHead().GetSHA(), reason, f)
+}
+
+// cancelCheckRunsOfRef cancels the in-flight check runs of the ref (e.g. a
+// head SHA) for whose meta data the given function returns true. Their build
+// log comments get a line with the reason. The meta data of the cancelled
+// check runs is returned. Builds that are reported with commit statuses
+// can't be found and are left alone.
+func cancelCheckRunsOfRef(srv Server, gh *github.Client, appInstallationID int64, repoOwner string, repoName string, ref string, reason string, f func(meta *CheckRunMeta) bool) ([]*CheckRunMeta, error) {
+	config, err := srv.GetRepoConfig(appInstallationID, repoOwner, repoName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repository configuration: %w", err)
+	}
+	reporter, err := newReporter(srv, appInstallationID, config)
+	if err != nil {
+		return nil, err
+	}
+	if !reporter.TracksBuilds() {
+		log.Printf("not cancelling builds of %s in %s/%s (%s): builds aren't tracked", ref, repoOwner, repoName, reason)
+		return nil, nil
+	}
+	checkRuns, err := reporter.ExistingBuilds(gh, appInstallationID, &BuildTarget{BaseRepoOwner: repoOwner, BaseRepoName: repoName, HeadSHA: ref})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all check runs for %s: %w", ref, err)
+	}
+	cancelled := []*CheckRunMeta{}
+	for _, checkRun := range checkRuns {
+		if checkRun.GetStatus() == string(CheckRunStateCompleted) {
+			continue
+		}
+		meta, ok := buildCheckRunMeta(checkRun)
+		if !ok || !f(meta) {
+			continue
+		}
+		log.Printf("cancelling check run %d: %s", checkRun.GetID(), reason)
+		if err := CancelCheckRun(srv, gh, repoOwner, repoName, checkRun, reason); err != nil {
+			return nil, err
+		}
+		if meta.BuildLogCommentID != 0 {
+			if err := appendToBuildLogComment(gh, repoOwner, repoName, meta.BuildLogCommentID, checkRun.GetName(), reason); err != nil {
+				return nil, err
+			}
+		}
+		cancelled = append(cancelled, meta)
+	}
+	return cancelled, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPullRequestBuilds(t *testing.T) {
+	b := newPullRequestBuilds()
+	b.Add("janedoe", "examplerepo", 1, inFlightCheckRun{CheckRunID: 1, HeadSHA: "old"})
+	b.Add("janedoe", "examplerepo", 1, inFlightCheckRun{CheckRunID: 2, HeadSHA: "old"})
+	b.Add("janedoe", "examplerepo", 1, inFlightCheckRun{CheckRunID: 3, HeadSHA: "new"})
+	b.Add("janedoe", "examplerepo", 2, inFlightCheckRun{CheckRunID: 4, HeadSHA: "old"})
+
+	b.Remove("janedoe", "examplerepo", 1, 2)
+	old := b.TakeIf("janedoe", "examplerepo", 1, func(run inFlightCheckRun) bool { return run.HeadSHA == "old" })
+	require.Equal(t, []inFlightCheckRun{{CheckRunID: 1, HeadSHA: "old"}}, old)
+	all := b.TakeIf("janedoe", "examplerepo", 1, func(run inFlightCheckRun) bool { return true })
+	require.Equal(t, []inFlightCheckRun{{CheckRunID: 3, HeadSHA: "new"}}, all)
+	require.Len(t, b.runs, 1)
+}
+
+func TestOnPullRequestEventCancelBuilds(t *testing.T) {
+	newHeadSHA := "5da7cf6468aabc181b3c7c662539cd3e70526c1b"
+	prEventOK := func(action string) *github.PullRequestEvent {
+		pr := prOK()
+		pr.Number = github.Int(123)
+		return &github.PullRequestEvent{
+			Action:       github.String(action),
+			PullRequest:  &pr,
+			Installation: &github.Installation{ID: github.Int64(1234)},
+			Repo: &github.Repository{
+				Owner: &github.User{Login: github.String("janedoe")},
+				Name:  github.String("examplerepo"),
+			},
+			Sender: &github.User{Login: github.String("johndoe")},
+		}
+	}
+	// Check runs 1 and 2 run for the old head SHA, check run 3 is already
+	// completed and check run 4 runs for the new head SHA.
+	track := func(srv *MockServer) {
+		srv.TrackCheckRun("janedoe", "examplerepo", 123, inFlightCheckRun{CheckRunID: 1, Name: "check run 1", HeadSHA: "old", BuildLogCommentID: 11})
+		srv.TrackCheckRun("janedoe", "examplerepo", 123, inFlightCheckRun{CheckRunID: 2, Name: "check run 2", HeadSHA: "old"})
+		srv.TrackCheckRun("janedoe", "examplerepo", 123, inFlightCheckRun{CheckRunID: 3, Name: "check run 3", HeadSHA: "old"})
+		srv.TrackCheckRun("janedoe", "examplerepo", 123, inFlightCheckRun{CheckRunID: 4, Name: "check run 1", HeadSHA: newHeadSHA})
+	}
+	newerCheckRun := checkRunOK(4, "queued", "", CheckRunMeta{})
+	newerCheckRun.Name = github.String("check run 1")
+
+	tests := []struct {
+		name          string
+		action        string
+		wantCancelled []int64
+		wantSummaries []string
+		wantTracked   int
+	}{
+		{
+			"new commits",
+			"synchronize",
+			[]int64{1, 2},
+			[]string{
+				`Superseded by <a href="http://github.com/check-runs/4">this check run</a> for the new head SHA (` + newHeadSHA + `).`,
+				"Cancelled because new commits were pushed to the pull request (" + newHeadSHA + ").",
+			},
+			1,
+		},
+		{
+			"closed",
+			"closed",
+			[]int64{1, 2, 4},
+			[]string{
+				"Cancelled because the pull request was closed.",
+				"Cancelled because the pull request was closed.",
+				"Cancelled because the pull request was closed.",
+			},
+			0,
+		},
+		{"other action", "edited", []int64{}, []string{}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updates := []github.UpdateCheckRunOptions{}
+			buildLogComments := []string{}
+			srv := NewMockServer(
+				mock.WithRequestMatchHandler(
+					mock.GetReposCheckRunsByOwnerByRepoByCheckRunId,
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						id, err := strconv.ParseInt(path.Base(r.URL.Path), 10, 64)
+						require.NoError(t, err)
+						status := "in_progress"
+						if id == 3 {
+							status = "completed"
+						}
+						w.Write(mock.MustMarshal(checkRunOK(id, status, "", CheckRunMeta{})))
+					}),
+				),
+				mock.WithRequestMatch(
+					mock.GetReposCommitsCheckRunsByOwnerByRepoByRef,
+					github.ListCheckRunsResults{Total: github.Int(1), CheckRuns: []*github.CheckRun{newerCheckRun}},
+				),
+				recordCheckRunUpdates(t, &updates),
+				mock.WithRequestMatch(
+					mock.GetReposIssuesCommentsByOwnerByRepoByCommentId,
+					github.IssueComment{Body: github.String("build log")},
+				),
+				mock.WithRequestMatchHandler(
+					mock.PatchReposIssuesCommentsByOwnerByRepoByCommentId,
+					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						comment := github.IssueComment{}
+						require.NoError(t, json.NewDecoder(r.Body).Decode(&comment))
+						buildLogComments = append(buildLogComments, comment.GetBody())
+						w.Write(mock.MustMarshal(comment))
+					}),
+				),
+			)
+			track(srv)
+			err := OnPullRequestEventCancelBuilds(srv)("1234", "pull_request", prEventOK(tt.action))
+			require.NoError(t, err)
+			require.Equal(t, tt.wantCancelled, *srv.cancelledCheckRuns)
+			require.Len(t, updates, len(tt.wantSummaries))
+			for i, update := range updates {
+				require.Equal(t, "cancelled", update.GetConclusion())
+				require.Contains(t, update.GetOutput().GetSummary(), tt.wantSummaries[i])
+			}
+			if len(tt.wantCancelled) > 0 {
+				require.Len(t, buildLogComments, 1)
+				require.Contains(t, buildLogComments[0], fmt.Sprintf("<i>[check run 1]</i> %s", tt.wantSummaries[0]))
+			}
+			require.Len(t, srv.TakeSupersededCheckRuns("janedoe", "examplerepo", 123, ""), tt.wantTracked)
+		})
+	}
+}